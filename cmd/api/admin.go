@@ -0,0 +1,191 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"gofetch.timwalker.dev/internal/validator"
+)
+
+// processStart is when the process started, for the uptime in runtime stats.
+var processStart = time.Now()
+
+func init() {
+	// expvar publishes cmdline and memstats itself.
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+}
+
+// adminRoutes returns the /admin route group: pprof profiles, runtime stats
+// and the log level. Callers protect it, either with requireRoles on the
+// public listener or by serving it on a private admin listener.
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /admin/stats", app.runtimeStatsHandler)
+	mux.HandleFunc("GET /admin/log-level", app.getLogLevelHandler)
+	mux.HandleFunc("PUT /admin/log-level", app.setLogLevelHandler)
+	mux.Handle("GET /admin/debug/vars", expvar.Handler())
+
+	// pprof.Index serves named profiles by trimming /debug/pprof/ from the
+	// path, so the prefix is stripped before the pprof handlers.
+	profiles := http.NewServeMux()
+	profiles.HandleFunc("/debug/pprof/", pprof.Index)
+	profiles.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	profiles.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	profiles.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	profiles.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/admin/debug/pprof/", http.StripPrefix("/admin", clearWriteDeadline(profiles)))
+
+	mux.HandleFunc("/", app.notFoundResponse)
+
+	return mux
+}
+
+// clearWriteDeadline middleware clears the write deadline of the connection,
+// so the WriteTimeout of the public listener does not cut off profiles and
+// traces that run for longer. Writers that cannot set a deadline have none
+// to clear.
+func clearWriteDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runtimeStats is a snapshot of the Go runtime.
+type runtimeStats struct {
+	Uptime     string         `json:"uptime"`
+	Goroutines int            `json:"goroutines"`
+	GOMAXPROCS int            `json:"gomaxprocs"`
+	NumCPU     int            `json:"numCPU"`
+	CgoCalls   int64          `json:"cgoCalls"`
+	Memory     memoryStats    `json:"memory"`
+	GC         runtimeGCStats `json:"gc"`
+}
+
+// memoryStats holds the runtime.MemStats fields most useful for spotting
+// leaks. Sizes are in bytes.
+type memoryStats struct {
+	Alloc        uint64 `json:"alloc"`
+	TotalAlloc   uint64 `json:"totalAlloc"`
+	Sys          uint64 `json:"sys"`
+	HeapAlloc    uint64 `json:"heapAlloc"`
+	HeapSys      uint64 `json:"heapSys"`
+	HeapIdle     uint64 `json:"heapIdle"`
+	HeapInuse    uint64 `json:"heapInuse"`
+	HeapReleased uint64 `json:"heapReleased"`
+	HeapObjects  uint64 `json:"heapObjects"`
+	StackInuse   uint64 `json:"stackInuse"`
+	Mallocs      uint64 `json:"mallocs"`
+	Frees        uint64 `json:"frees"`
+}
+
+// runtimeGCStats summarizes the garbage collector.
+type runtimeGCStats struct {
+	NumGC         uint32     `json:"numGC"`
+	NumForcedGC   uint32     `json:"numForcedGC"`
+	PauseTotal    string     `json:"pauseTotal"`
+	LastPause     string     `json:"lastPause"`
+	LastGC        *time.Time `json:"lastGC"`
+	NextGC        uint64     `json:"nextGC"`
+	GCCPUFraction float64    `json:"gcCPUFraction"`
+}
+
+// runtimeStatsHandler returns goroutine, memory and garbage collector
+// statistics. Reading them briefly stops the world.
+func (app *application) runtimeStatsHandler(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := runtimeStats{
+		Uptime:     time.Since(processStart).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		CgoCalls:   runtime.NumCgoCall(),
+		Memory: memoryStats{
+			Alloc:        m.Alloc,
+			TotalAlloc:   m.TotalAlloc,
+			Sys:          m.Sys,
+			HeapAlloc:    m.HeapAlloc,
+			HeapSys:      m.HeapSys,
+			HeapIdle:     m.HeapIdle,
+			HeapInuse:    m.HeapInuse,
+			HeapReleased: m.HeapReleased,
+			HeapObjects:  m.HeapObjects,
+			StackInuse:   m.StackInuse,
+			Mallocs:      m.Mallocs,
+			Frees:        m.Frees,
+		},
+		GC: runtimeGCStats{
+			NumGC:         m.NumGC,
+			NumForcedGC:   m.NumForcedGC,
+			PauseTotal:    time.Duration(m.PauseTotalNs).String(),
+			LastPause:     time.Duration(m.PauseNs[(m.NumGC+255)%256]).String(),
+			NextGC:        m.NextGC,
+			GCCPUFraction: m.GCCPUFraction,
+		},
+	}
+	if m.LastGC > 0 {
+		lastGC := time.Unix(0, int64(m.LastGC)).UTC()
+		stats.GC.LastGC = &lastGC
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := writeJSON(w, http.StatusOK, stats); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// logLevelResponse is the body of the log level routes.
+type logLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// getLogLevelHandler returns the minimum level of logged records.
+func (app *application) getLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := writeJSON(w, http.StatusOK, logLevelResponse{Level: app.logLevel.Level().String()})
+	if err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// setLogLevelHandler changes the minimum level of logged records at runtime.
+// Levels are parsed like slog.Level.UnmarshalText, so "debug", "WARN" and
+// "info+2" are all accepted.
+func (app *application) setLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input logLevelResponse
+	if err := readJSON(w, r, &input); err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(input.Level)); err != nil {
+		app.failedValidationResponse(w, r, validator.Errors{"level": "must be one of debug, info, warn, error"})
+		return
+	}
+
+	previous := app.logLevel.Level()
+	attrs := []any{"from", previous.String(), "to", level.String(), string(correlationIDContextKey), app.getCorrelationID(r.Context())}
+	if claims := app.getClaims(r.Context()); claims != nil {
+		attrs = append(attrs, "subject", claims.Subject)
+	}
+
+	// The change is logged while the more verbose of the two levels is set.
+	if level < previous {
+		app.logLevel.Set(level)
+		app.logger.Info("log level changed", attrs...)
+	} else {
+		app.logger.Info("log level changed", attrs...)
+		app.logLevel.Set(level)
+	}
+
+	if err := writeJSON(w, http.StatusOK, logLevelResponse{Level: level.String()}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
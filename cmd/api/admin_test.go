@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminRoutes(t *testing.T) {
+	app := newTestAuthApplication(t)
+	mux := app.registerRoutes()
+	admin := "Bearer " + newTestToken(t, map[string]any{"sub": "ops", "roles": []string{"admin"}})
+
+	t.Run("requires_admin_role", func(t *testing.T) {
+		user := "Bearer " + newTestToken(t, map[string]any{"sub": "u1", "roles": []string{"user"}})
+		for _, target := range []string{"/admin/stats", "/admin/log-level", "/admin/debug/pprof/", "/admin/debug/vars"} {
+			if rr := doRequest(mux, http.MethodGet, target, "", ""); rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s without credentials: got status %v want %v", target, rr.Code, http.StatusUnauthorized)
+			}
+			if rr := doRequest(mux, http.MethodGet, target, user, ""); rr.Code != http.StatusForbidden {
+				t.Errorf("%s without admin role: got status %v want %v", target, rr.Code, http.StatusForbidden)
+			}
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodGet, "/admin/stats", admin, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		var stats runtimeStats
+		if err := json.NewDecoder(rr.Body).Decode(&stats); err != nil {
+			t.Fatal(err)
+		}
+		if stats.Goroutines == 0 || stats.GOMAXPROCS == 0 || stats.Memory.Sys == 0 {
+			t.Errorf("unexpected stats: %+v", stats)
+		}
+	})
+
+	t.Run("expvar", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodGet, "/admin/debug/vars", admin, "")
+		var vars map[string]json.RawMessage
+		if err := json.NewDecoder(rr.Body).Decode(&vars); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"goroutines", "memstats", "cmdline"} {
+			if _, ok := vars[name]; !ok {
+				t.Errorf("missing expvar %q", name)
+			}
+		}
+	})
+
+	t.Run("pprof", func(t *testing.T) {
+		if rr := doRequest(mux, http.MethodGet, "/admin/debug/pprof/", admin, ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "goroutine") {
+			t.Errorf("index: got status %v: %s", rr.Code, rr.Body)
+		}
+		rr := doRequest(mux, http.MethodGet, "/admin/debug/pprof/goroutine?debug=1", admin, "")
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "goroutine profile:") {
+			t.Errorf("goroutine profile: got status %v: %.200s", rr.Code, rr.Body)
+		}
+		if rr := doRequest(mux, http.MethodGet, "/admin/debug/pprof/cmdline", admin, ""); rr.Code != http.StatusOK {
+			t.Errorf("cmdline: got status %v", rr.Code)
+		}
+	})
+
+	t.Run("pprof_outlives_write_timeout", func(t *testing.T) {
+		srv := httptest.NewUnstartedServer(clearWriteDeadline(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("profile"))
+		})))
+		srv.Config.WriteTimeout = 50 * time.Millisecond
+		srv.Start()
+		defer srv.Close()
+
+		resp, err := srv.Client().Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if body, err := io.ReadAll(resp.Body); err != nil || string(body) != "profile" {
+			t.Errorf("got body %q, %v", body, err)
+		}
+	})
+
+	t.Run("log_level", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodPut, "/admin/log-level", admin, `{"level":"debug"}`)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"DEBUG"`) {
+			t.Fatalf("got status %v: %s", rr.Code, rr.Body)
+		}
+		if got := app.logLevel.Level(); got != slog.LevelDebug {
+			t.Errorf("got level %v want %v", got, slog.LevelDebug)
+		}
+
+		rr = doRequest(mux, http.MethodGet, "/admin/log-level", admin, "")
+		if !strings.Contains(rr.Body.String(), `"DEBUG"`) {
+			t.Errorf("unexpected level: %s", rr.Body)
+		}
+
+		rr = doRequest(mux, http.MethodPut, "/admin/log-level", admin, `{"level":"loud"}`)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("invalid level: got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+		if got := app.logLevel.Level(); got != slog.LevelDebug {
+			t.Errorf("invalid level changed the level to %v", got)
+		}
+	})
+
+	t.Run("unknown_route", func(t *testing.T) {
+		if rr := doRequest(mux, http.MethodGet, "/admin/nope", admin, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestAdminListener(t *testing.T) {
+	app := newTestAuthApplication(t)
+	app.config.adminAddr = "localhost:0"
+
+	rr := httptest.NewRecorder()
+	app.registerRoutes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("public listener: got status %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	app.adminRoutes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/admin/stats", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("admin listener: got status %v want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
-)
+	"strconv"
 
-// Example struct to hold API data
-type Album struct {
-	ID     int    `json:"id"`
-	UserID int    `json:"userId"`
-	Title  string `json:"title"`
-}
+	"gofetch.timwalker.dev/internal/apiclient"
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/query"
+	"gofetch.timwalker.dev/internal/validator"
+)
 
 func (app *application) getAlbumsFromApiClientHandler(w http.ResponseWriter, r *http.Request) {
-	var albums []Album
+	var albums []database.Album
 	resp, err := app.apiClient.Get(r.Context(), "/static/albums.json", &albums)
 	if err != nil {
+		if errors.Is(err, apiclient.ErrCircuitOpen) {
+			app.upstreamUnavailableResponse(w, r, err)
+			return
+		}
 		if resp != nil {
 			app.apiClientErrorResponse(w, r, resp.StatusCode, err)
 			return
@@ -26,14 +31,279 @@ func (app *application) getAlbumsFromApiClientHandler(w http.ResponseWriter, r *
 }
 
 func (app *application) createAlbumHandler(w http.ResponseWriter, r *http.Request) {
-	var newAlbum Album
-	err := readJSON(w, r, &newAlbum)
+	var input struct {
+		UserID int    `json:"userId"`
+		Title  string `json:"title"`
+	}
+	err := readJSON(w, r, &input)
+	if err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	album := &database.Album{UserID: input.UserID, Title: input.Title}
+	if err := validator.Struct(album); err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	err = app.db.Albums().Create(r.Context(), album)
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/albums/%d", album.ID))
+	setAlbumValidators(w, album)
+	err = writeJSONData(w, http.StatusCreated, album)
+	if err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// albumListQuery is the allowlist for query parameters of GET /albums.
+var albumListQuery = query.Config{
+	Filters: map[string][]query.Operator{
+		"userId": {query.Equal},
+		"title":  {query.Equal, query.Contains},
+	},
+	Sort:         []string{"id", "userId", "title"},
+	Fields:       []string{"id", "userId", "title", "version", "updatedAt"},
+	Key:          "id",
+	DefaultSort:  "id",
+	DefaultLimit: 20,
+	MaxLimit:     100,
+}
+
+// albumField returns the album field named by its JSON name.
+func albumField(album *database.Album, field string) any {
+	switch field {
+	case "id":
+		return album.ID
+	case "userId":
+		return album.UserID
+	case "title":
+		return album.Title
+	}
+	return nil
+}
+
+func (app *application) listAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := query.Parse(r.URL, albumListQuery)
+	if err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	// A userId filter narrows the albums loaded using the owner index.
+	var filter database.AlbumFilter
+	indexed := len(q.Sort) == 1 && q.Sort[0].Field == "id"
+	for _, f := range q.Filters {
+		if f.Field != "userId" || filter.UserID != 0 {
+			indexed = false
+			continue
+		}
+		userID, err := strconv.Atoi(f.Value)
+		if err != nil {
+			app.failedValidationResponse(w, r, validator.Errors{"userId": "must be an integer"})
+			return
+		}
+		filter.UserID = userID
+	}
+
+	var albums []*database.Album
+	var page query.Page
+	if indexed {
+		// The indexes are ordered by ID, so the repository returns just the
+		// page, plus one album to tell whether there is a next page.
+		filter.Desc = q.Sort[0].Desc
+		filter.Offset = q.Offset()
+		filter.Limit = q.Limit + 1
+		if after := q.After(); after != nil {
+			id, ok := after[0].(float64)
+			if !ok {
+				app.failedValidationResponse(w, r, validator.Errors{"cursor": "is invalid"})
+				return
+			}
+			filter.AfterID = int(id)
+		}
+
+		list, total, err := app.db.Albums().List(r.Context(), filter)
+		if err != nil {
+			app.databaseErrorResponse(w, r, err)
+			return
+		}
+		albums, page = query.Paginate(list, total, q, albumField)
+	} else {
+		// Other sorts and filters need every album.
+		list, _, err := app.db.Albums().List(r.Context(), filter)
+		if err != nil {
+			app.databaseErrorResponse(w, r, err)
+			return
+		}
+		albums, page = query.Apply(list, q, albumField)
+	}
+
+	data, err := query.Select(albums, q)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if link := page.LinkHeader(); link != "" {
+		w.Header().Set("Link", link)
+	}
+	err = writeJSONDataMeta(w, http.StatusOK, data, page)
+	if err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) getAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	album, ok := app.loadAlbum(w, r)
+	if !ok {
 		return
 	}
-	err = writeJSONData(w, http.StatusCreated, newAlbum)
+	if notModified(w, r, versionETag(album.Version), album.UpdatedAt) {
+		return
+	}
+
+	err := writeJSONData(w, http.StatusOK, album)
 	if err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
+
+// updateAlbumHandler replaces every field of an album.
+func (app *application) updateAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	album, ok := app.loadAlbum(w, r)
+	if !ok || !app.checkIfMatch(w, r, versionETag(album.Version)) {
+		return
+	}
+
+	var input struct {
+		UserID int    `json:"userId"`
+		Title  string `json:"title"`
+	}
+	err := readJSON(w, r, &input)
+	if err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	album.UserID = input.UserID
+	album.Title = input.Title
+	app.saveAlbum(w, r, album)
+}
+
+// patchAlbumHandler updates only the fields present in the request body.
+func (app *application) patchAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	album, ok := app.loadAlbum(w, r)
+	if !ok || !app.checkIfMatch(w, r, versionETag(album.Version)) {
+		return
+	}
+
+	var input struct {
+		UserID *int    `json:"userId"`
+		Title  *string `json:"title"`
+	}
+	err := readJSON(w, r, &input)
+	if err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+
+	if input.UserID != nil {
+		album.UserID = *input.UserID
+	}
+	if input.Title != nil {
+		album.Title = *input.Title
+	}
+	app.saveAlbum(w, r, album)
+}
+
+func (app *application) deleteAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	album, ok := app.loadAlbum(w, r)
+	if !ok || !app.checkIfMatch(w, r, versionETag(album.Version)) {
+		return
+	}
+
+	// The version is checked again as the album is deleted, so an update
+	// made since it was read is not lost.
+	err := app.db.Albums().Delete(r.Context(), album.ID, album.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrVersionConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, database.ErrVersionConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.databaseErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadAlbum fetches the album for the {id} route parameter, responding with
+// a 404 when it does not exist.
+func (app *application) loadAlbum(w http.ResponseWriter, r *http.Request) (*database.Album, bool) {
+	id, err := readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	album, err := app.db.Albums().Get(r.Context(), id)
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return nil, false
+	}
+	return album, true
+}
+
+// saveAlbum validates and stores an updated album and writes it to the
+// response. An album changed by another request since it was loaded is a
+// 412 when the request had an If-Match precondition and a 409 otherwise.
+func (app *application) saveAlbum(w http.ResponseWriter, r *http.Request, album *database.Album) {
+	if err := validator.Struct(album); err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	err := app.db.Albums().Update(r.Context(), album)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrVersionConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, database.ErrVersionConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.databaseErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	setAlbumValidators(w, album)
+
+	err = writeJSONData(w, http.StatusOK, album)
+	if err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// setAlbumValidators sets the ETag and Last-Modified headers for album.
+func setAlbumValidators(w http.ResponseWriter, album *database.Album) {
+	w.Header().Set("ETag", versionETag(album.Version))
+	w.Header().Set("Last-Modified", album.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+// readIDParam parses the {id} route parameter as a positive integer.
+func readIDParam(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid id parameter %q", r.PathValue("id"))
+	}
+	return id, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+func TestAlbums(t *testing.T) {
+	app := newTestAuthApplication(t)
+	mux := app.registerRoutes()
+	token := "Bearer " + newTestToken(t, map[string]any{"sub": "user-1", "scope": "albums:write"})
+
+	decode := func(t *testing.T, rr *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		envelope := struct {
+			Data any `json:"data"`
+		}{Data: v}
+		if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := doRequest(mux, http.MethodPost, "/albums", token, `{"userId":1,"title":"first"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: got status %v want %v: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	if got := rr.Header().Get("Location"); got != "/albums/1" {
+		t.Errorf("Location: got %q want %q", got, "/albums/1")
+	}
+	doRequest(mux, http.MethodPost, "/albums", token, `{"userId":2,"title":"second"}`)
+
+	t.Run("get", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodGet, "/albums/1", token, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %v want %v", rr.Code, http.StatusOK)
+		}
+		var album database.Album
+		decode(t, rr, &album)
+		if album.ID != 1 || album.UserID != 1 || album.Title != "first" || album.Version != 1 {
+			t.Errorf("unexpected album: %+v", album)
+		}
+	})
+
+	t.Run("list_by_user", func(t *testing.T) {
+		var albums []database.Album
+		decode(t, doRequest(mux, http.MethodGet, "/albums?userId=2", token, ""), &albums)
+		if len(albums) != 1 || albums[0].Title != "second" {
+			t.Errorf("unexpected albums: %+v", albums)
+		}
+	})
+
+	t.Run("put_replaces_album", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodPut, "/albums/1", token, `{"userId":3,"title":"replaced"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %v want %v", rr.Code, http.StatusOK)
+		}
+		var albums []database.Album
+		decode(t, doRequest(mux, http.MethodGet, "/albums?userId=3", token, ""), &albums)
+		if len(albums) != 1 || albums[0].Title != "replaced" {
+			t.Errorf("unexpected albums: %+v", albums)
+		}
+	})
+
+	t.Run("patch_updates_present_fields", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodPatch, "/albums/2", token, `{"title":"patched"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %v want %v", rr.Code, http.StatusOK)
+		}
+		var album database.Album
+		decode(t, rr, &album)
+		if album.UserID != 2 || album.Title != "patched" {
+			t.Errorf("unexpected album: %+v", album)
+		}
+	})
+
+	t.Run("rejects_invalid_album", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodPost, "/albums", token, `{"userId":0,"title":""}`)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+
+		var body struct {
+			Fields map[string]string `json:"fields"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Fields["userId"] == "" || body.Fields["title"] == "" {
+			t.Errorf("expected userId and title field errors, got %v", body.Fields)
+		}
+
+		if rr := doRequest(mux, http.MethodPatch, "/albums/2", token, `{"title":""}`); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("patch: got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("list_query", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodGet, "/albums?title~=PATCH&fields=id,title&limit=1", token, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+
+		var body struct {
+			Data []map[string]any `json:"data"`
+			Meta struct {
+				Total int `json:"total"`
+			} `json:"meta"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Data) != 1 || body.Data[0]["title"] != "patched" || body.Data[0]["userId"] != nil {
+			t.Errorf("unexpected albums: %v", body.Data)
+		}
+		if body.Meta.Total != 1 {
+			t.Errorf("total: got %d want 1", body.Meta.Total)
+		}
+
+		rr = doRequest(mux, http.MethodGet, "/albums?limit=1", token, "")
+		if link := rr.Header().Get("Link"); !strings.Contains(link, `rel="next"`) {
+			t.Errorf("expected a next link, got %q", link)
+		}
+
+		if rr := doRequest(mux, http.MethodGet, "/albums?sort=year", token, ""); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("invalid sort: got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if rr := doRequest(mux, http.MethodDelete, "/albums/2", token, ""); rr.Code != http.StatusNoContent {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusNoContent)
+		}
+		if rr := doRequest(mux, http.MethodGet, "/albums/2", token, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+		if rr := doRequest(mux, http.MethodDelete, "/albums/2", token, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("second delete: got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("not_found_for_invalid_id", func(t *testing.T) {
+		if rr := doRequest(mux, http.MethodGet, "/albums/abc", token, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestAlbumPagination(t *testing.T) {
+	app := newTestAuthApplication(t)
+	mux := app.registerRoutes()
+	token := "Bearer " + newTestToken(t, map[string]any{"sub": "user-1", "scope": "albums:write"})
+	for _, body := range []string{`{"userId":1,"title":"a"}`, `{"userId":2,"title":"b"}`, `{"userId":1,"title":"c"}`} {
+		doRequest(mux, http.MethodPost, "/albums", token, body)
+	}
+
+	list := func(t *testing.T, target string) ([]int, string) {
+		t.Helper()
+		rr := doRequest(mux, http.MethodGet, target, "", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: got status %v want %v: %s", target, rr.Code, http.StatusOK, rr.Body)
+		}
+		var body struct {
+			Data []database.Album `json:"data"`
+			Meta struct {
+				Next string `json:"next"`
+			} `json:"meta"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, album := range body.Data {
+			ids = append(ids, album.ID)
+		}
+		return ids, body.Meta.Next
+	}
+
+	for _, tt := range []struct {
+		target string
+		want   [][]int
+	}{
+		{"/albums?limit=2", [][]int{{1, 2}, {3}}},
+		{"/albums?sort=-id&limit=2", [][]int{{3, 2}, {1}}},
+		{"/albums?userId=1&limit=1", [][]int{{1}, {3}}},
+		{"/albums?sort=title&limit=2", [][]int{{1, 2}, {3}}},
+	} {
+		target := tt.target
+		for i, want := range tt.want {
+			ids, next := list(t, target)
+			if !slices.Equal(ids, want) {
+				t.Errorf("%s page %d: got IDs %v want %v", tt.target, i+1, ids, want)
+			}
+			if (next == "") != (i == len(tt.want)-1) {
+				t.Errorf("%s page %d: unexpected next link %q", tt.target, i+1, next)
+			}
+			target = next
+		}
+	}
+
+	if ids, _ := list(t, "/albums?sort=-id&page=2&limit=2"); !slices.Equal(ids, []int{1}) {
+		t.Errorf("page 2 by descending ID: got IDs %v want [1]", ids)
+	}
+}
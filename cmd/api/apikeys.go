@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gofetch.timwalker.dev/internal/auth"
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/validator"
+)
+
+// apiKeyContextKey is the key used to store the authenticated API key in the context.
+const apiKeyContextKey contextKey = "apiKey"
+
+// errInvalidAPIKey is returned for unknown, mismatched and expired API keys
+// so callers cannot tell which check failed.
+var errInvalidAPIKey = errors.New("invalid api key")
+
+// apiKeyResponse includes the plaintext key, which is only returned when a
+// key is created or rotated.
+type apiKeyResponse struct {
+	*database.APIKey
+	Key string `json:"key,omitempty"`
+}
+
+func (app *application) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Owner     string    `json:"owner" validate:"max=200"`
+		Scopes    []string  `json:"scopes" validate:"max=50"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	if err := readJSON(w, r, &input); err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+	if err := validator.Struct(input); err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	claims := app.getClaims(r.Context())
+	if input.Owner == "" {
+		input.Owner = claims.Subject
+	}
+	if !input.ExpiresAt.IsZero() && !input.ExpiresAt.After(time.Now()) {
+		app.failedValidationResponse(w, r, validator.Errors{"expiresAt": "must be in the future"})
+		return
+	}
+
+	// Only admins may issue keys for other owners or with scopes they do
+	// not hold themselves.
+	if !claims.HasRole("admin") {
+		if input.Owner != claims.Subject {
+			app.forbiddenResponse(w, r, fmt.Errorf("subject %q cannot create keys for %q", claims.Subject, input.Owner))
+			return
+		}
+		for _, scope := range input.Scopes {
+			if !claims.HasScope(scope) {
+				app.forbiddenResponse(w, r, fmt.Errorf("subject %q cannot grant scope %q", claims.Subject, scope))
+				return
+			}
+		}
+	}
+
+	plaintext, prefix, hash, err := database.GenerateAPIKey()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	key := &database.APIKey{
+		ID:        uuid.NewString(),
+		Prefix:    prefix,
+		Hash:      hash,
+		Owner:     input.Owner,
+		Scopes:    input.Scopes,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: input.ExpiresAt.UTC(),
+	}
+
+	if err := app.db.APIKeys().Create(r.Context(), key); err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/apikeys/"+key.ID)
+	if err := writeJSONData(w, http.StatusCreated, apiKeyResponse{APIKey: key, Key: plaintext}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	claims := app.getClaims(r.Context())
+
+	var (
+		keys []*database.APIKey
+		err  error
+	)
+	switch owner := r.URL.Query().Get("owner"); {
+	case !claims.HasRole("admin"):
+		keys, err = app.db.APIKeys().List(r.Context(), claims.Subject)
+	case owner == "":
+		keys, err = app.db.APIKeys().ListAll(r.Context())
+	default:
+		keys, err = app.db.APIKeys().List(r.Context(), owner)
+	}
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONData(w, http.StatusOK, keys); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) getAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.loadOwnedAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	if err := writeJSONData(w, http.StatusOK, key); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) rotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.loadOwnedAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	plaintext, prefix, hash, err := database.GenerateAPIKey()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	key, err = app.db.APIKeys().Rotate(r.Context(), key.ID, prefix, hash)
+	if err != nil {
+		if errors.Is(err, database.ErrVersionConflict) {
+			app.editConflictResponse(w, r)
+			return
+		}
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONData(w, http.StatusOK, apiKeyResponse{APIKey: key, Key: plaintext}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := app.loadOwnedAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.db.APIKeys().Delete(r.Context(), key.ID)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadOwnedAPIKey loads the key for the {id} route parameter. Keys owned by
+// someone else are reported as not found unless the caller is an admin.
+func (app *application) loadOwnedAPIKey(w http.ResponseWriter, r *http.Request) (*database.APIKey, bool) {
+	key, err := app.db.APIKeys().Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	claims := app.getClaims(r.Context())
+	if key.Owner != claims.Subject && !claims.HasRole("admin") {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	return key, true
+}
+
+// authenticateAPIKey looks up the key by its prefix and compares hashes in
+// constant time. Successful lookups are recorded as the key's last use.
+func (app *application) authenticateAPIKey(ctx context.Context, plaintext string) (*database.APIKey, error) {
+	prefix, ok := database.ParseAPIKeyPrefix(plaintext)
+	if !ok {
+		return nil, errInvalidAPIKey
+	}
+
+	key, err := app.db.APIKeys().GetByPrefix(ctx, prefix)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, errInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	hash := database.HashAPIKey(plaintext)
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(key.Hash)) != 1 {
+		return nil, errInvalidAPIKey
+	}
+
+	now := time.Now()
+	if key.Expired(now) {
+		return nil, errInvalidAPIKey
+	}
+
+	if app.apiKeyUsage != nil {
+		app.apiKeyUsage.record(key.ID, now)
+	}
+	return key, nil
+}
+
+// apiKeyClaims maps an API key onto claims so routes can authorize API key
+// and bearer token callers the same way.
+func apiKeyClaims(key *database.APIKey) *auth.Claims {
+	return &auth.Claims{
+		Subject:   key.Owner,
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+		Raw:       map[string]any{"apiKeyId": key.ID},
+	}
+}
+
+// getAPIKey retrieves the authenticated API key from the context. It is nil
+// for requests authenticated with a bearer token.
+func (app *application) getAPIKey(ctx context.Context) *database.APIKey {
+	if key, ok := ctx.Value(apiKeyContextKey).(*database.APIKey); ok {
+		return key
+	}
+	return nil
+}
+
+// requireUser middleware authenticates the request and rejects API keys, so
+// a leaked key cannot be used to mint or revoke other keys. Tokens without a
+// subject are rejected too, since keys are owned by the subject.
+func (app *application) requireUser(next http.Handler) http.Handler {
+	return app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims := app.getClaims(r.Context()); claims == nil || claims.Subject == "" {
+			app.unauthorizedResponse(w, r, errors.New("token has no subject"))
+			return
+		}
+		if key := app.getAPIKey(r.Context()); key != nil {
+			app.forbiddenResponse(w, r, fmt.Errorf("api key %q cannot manage api keys", key.ID))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// apiKeyUsage collects last used timestamps so they can be written to the
+// database in batches, off the request path.
+type apiKeyUsage struct {
+	mu      sync.Mutex
+	pending map[string]time.Time
+}
+
+func newAPIKeyUsage() *apiKeyUsage {
+	return &apiKeyUsage{pending: make(map[string]time.Time)}
+}
+
+func (u *apiKeyUsage) record(id string, t time.Time) {
+	u.mu.Lock()
+	u.pending[id] = t
+	u.mu.Unlock()
+}
+
+// flush writes the pending timestamps and clears them.
+func (u *apiKeyUsage) flush(ctx context.Context, repo database.APIKeyRepository) error {
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = make(map[string]time.Time)
+	u.mu.Unlock()
+
+	var errs []error
+	for id, t := range pending {
+		if err := repo.TouchLastUsed(ctx, id, t); err != nil {
+			errs = append(errs, fmt.Errorf("api key %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// startRecordingAPIKeyUsage runs recordAPIKeyUsage until the server shuts
+// down, when stopRecordingAPIKeyUsage waits for its final flush.
+func (app *application) startRecordingAPIKeyUsage(interval time.Duration) {
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.recordAPIKeyUsage(ctx, interval)
+	}()
+
+	app.stopRecordingAPIKeyUsage = func(shutdownCtx context.Context) error {
+		stop()
+		select {
+		case <-done:
+			return nil
+		case <-shutdownCtx.Done():
+			return shutdownCtx.Err()
+		}
+	}
+}
+
+// recordAPIKeyUsage flushes API key usage every interval until ctx is done,
+// then flushes once more.
+func (app *application) recordAPIKeyUsage(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Flush anything recorded since the last tick before exiting.
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := app.apiKeyUsage.flush(flushCtx, app.db.APIKeys()); err != nil {
+				app.logger.Warn("failed to record api key usage", "error", err.Error())
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := app.apiKeyUsage.flush(ctx, app.db.APIKeys()); err != nil {
+				app.logger.Warn("failed to record api key usage", "error", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+func TestAPIKeys(t *testing.T) {
+	app := newTestAuthApplication(t)
+	app.apiKeyUsage = newAPIKeyUsage()
+	mux := app.registerRoutes()
+
+	userToken := "Bearer " + newTestToken(t, map[string]any{"sub": "user-1", "scope": "albums:read albums:write"})
+	otherToken := "Bearer " + newTestToken(t, map[string]any{"sub": "user-2"})
+
+	create := func(t *testing.T, body string) apiKeyResponse {
+		t.Helper()
+		rr := doRequest(mux, http.MethodPost, "/apikeys", userToken, body)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create: got status %v want %v: %s", rr.Code, http.StatusCreated, rr.Body)
+		}
+		var resp struct {
+			Data apiKeyResponse `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data
+	}
+
+	t.Run("authenticates_with_created_key", func(t *testing.T) {
+		key := create(t, `{"scopes":["albums:write"]}`)
+		if key.Key == "" || key.Owner != "user-1" {
+			t.Fatalf("unexpected key: %+v", key)
+		}
+
+		handler := app.requireScopes("albums:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if got := app.getClaims(r.Context()).Subject; got != "user-1" {
+				t.Errorf("subject got %q want %q", got, "user-1")
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		for _, header := range []string{"X-API-Key", "Authorization"} {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if header == "Authorization" {
+				r.Header.Set(header, "ApiKey "+key.Key)
+			} else {
+				r.Header.Set(header, key.Key)
+			}
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("%s: got status %v want %v", header, rr.Code, http.StatusOK)
+			}
+		}
+
+		if err := app.apiKeyUsage.flush(context.Background(), app.db.APIKeys()); err != nil {
+			t.Fatal(err)
+		}
+		stored, err := app.db.APIKeys().Get(context.Background(), key.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.LastUsedAt.IsZero() {
+			t.Error("Expected last used timestamp to be recorded")
+		}
+	})
+
+	t.Run("cannot_grant_scopes_the_user_lacks", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodPost, "/apikeys", userToken, `{"scopes":["admin:all"]}`)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("rotate_invalidates_old_key", func(t *testing.T) {
+		key := create(t, `{}`)
+
+		rr := doRequest(mux, http.MethodPost, "/apikeys/"+key.ID+"/rotate", userToken, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("rotate: got status %v want %v", rr.Code, http.StatusOK)
+		}
+
+		if _, err := app.authenticateAPIKey(context.Background(), key.Key); err != errInvalidAPIKey {
+			t.Errorf("old key: got error %v want %v", err, errInvalidAPIKey)
+		}
+	})
+
+	t.Run("revoke_and_ownership", func(t *testing.T) {
+		key := create(t, `{}`)
+
+		if rr := doRequest(mux, http.MethodDelete, "/apikeys/"+key.ID, otherToken, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("other owner: got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+		if rr := doRequest(mux, http.MethodDelete, "/apikeys/"+key.ID, "ApiKey "+key.Key, ""); rr.Code != http.StatusForbidden {
+			t.Errorf("api key caller: got status %v want %v", rr.Code, http.StatusForbidden)
+		}
+		if rr := doRequest(mux, http.MethodDelete, "/apikeys/"+key.ID, userToken, ""); rr.Code != http.StatusNoContent {
+			t.Errorf("owner: got status %v want %v", rr.Code, http.StatusNoContent)
+		}
+		if _, err := app.authenticateAPIKey(context.Background(), key.Key); err != errInvalidAPIKey {
+			t.Errorf("revoked key: got error %v want %v", err, errInvalidAPIKey)
+		}
+	})
+
+	t.Run("lists_only_own_keys", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodGet, "/apikeys", otherToken, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %v want %v", rr.Code, http.StatusOK)
+		}
+		if body := strings.TrimSpace(rr.Body.String()); body != `{"data":[]}` {
+			t.Errorf("got body %s want no keys", body)
+		}
+	})
+
+	t.Run("rejects_tokens_without_subject", func(t *testing.T) {
+		noSubject := "Bearer " + newTestToken(t, map[string]any{"scope": "albums:read"})
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			if rr := doRequest(mux, method, "/apikeys", noSubject, `{}`); rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s: got status %v want %v", method, rr.Code, http.StatusUnauthorized)
+			}
+		}
+	})
+}
+
+func TestAPIKeyUsageFinalFlush(t *testing.T) {
+	app := newTestApplication(t)
+	app.apiKeyUsage = newAPIKeyUsage()
+	ctx := context.Background()
+
+	key := &database.APIKey{ID: "k1", Prefix: "abc123", Owner: "user-1", CreatedAt: time.Now()}
+	if err := app.db.APIKeys().Create(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Usage recorded after the last tick is flushed on shutdown.
+	app.startRecordingAPIKeyUsage(time.Hour)
+	app.apiKeyUsage.record(key.ID, time.Now())
+	if err := app.stopRecordingAPIKeyUsage(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := app.db.APIKeys().Get(ctx, key.ID)
+	if err != nil || stored.LastUsedAt.IsZero() {
+		t.Errorf("last used not recorded on shutdown: %+v, %v", stored, err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gofetch.timwalker.dev/internal/auth"
+)
+
+// claimsContextKey is the key used to store the authenticated claims in the context.
+const claimsContextKey contextKey = "claims"
+
+// newVerifier creates a JWT verifier from the auth config. It returns nil
+// when no signing keys are configured, in which case bearer tokens are
+// rejected.
+func newVerifier(cfg authConfig) (*auth.Verifier, error) {
+	var keys auth.KeySource
+	switch {
+	case cfg.jwksFile != "":
+		ks, err := auth.LoadJWKSFile(cfg.jwksFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWKS file: %w", err)
+		}
+		keys = ks
+	case cfg.jwksURL != "":
+		keys = auth.NewRemoteKeySet(cfg.jwksURL, nil, time.Hour)
+	}
+
+	if cfg.hmacSecret == "" && keys == nil {
+		return nil, nil
+	}
+
+	return auth.NewVerifier(auth.Config{
+		HMACSecret: []byte(cfg.hmacSecret),
+		Keys:       keys,
+		Issuer:     cfg.issuer,
+		Audience:   cfg.audience,
+		Leeway:     cfg.leeway,
+	})
+}
+
+// authenticate middleware validates the API key or bearer token in the
+// request and adds the resulting claims to the request context. Requests
+// without valid credentials receive a 401.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		if plaintext := apiKeyFromRequest(r); plaintext != "" {
+			key, err := app.authenticateAPIKey(r.Context(), plaintext)
+			if err != nil {
+				if !errors.Is(err, errInvalidAPIKey) {
+					app.databaseErrorResponse(w, r, err)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `ApiKey realm="gofetch"`)
+				app.unauthorizedResponse(w, r, err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsContextKey, apiKeyClaims(key))
+			ctx = context.WithValue(ctx, apiKeyContextKey, key)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gofetch"`)
+			app.unauthorizedResponse(w, r, errors.New("missing bearer token"))
+			return
+		}
+
+		if app.verifier == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gofetch"`)
+			app.unauthorizedResponse(w, r, errors.New("bearer authentication is not configured"))
+			return
+		}
+
+		claims, err := app.verifier.Verify(r.Context(), strings.TrimSpace(token))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gofetch", error="invalid_token"`)
+			app.unauthorizedResponse(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// getClaims retrieves the authenticated claims from the context.
+func (app *application) getClaims(ctx context.Context) *auth.Claims {
+	if claims, ok := ctx.Value(claimsContextKey).(*auth.Claims); ok {
+		return claims
+	}
+	return nil
+}
+
+// requireRoles middleware authenticates the request and responds with a 403
+// unless the claims include every role.
+func (app *application) requireRoles(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := app.getClaims(r.Context())
+			for _, role := range roles {
+				if !claims.HasRole(role) {
+					app.forbiddenResponse(w, r, fmt.Errorf("subject %q is missing role %q", claims.Subject, role))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		}))
+	}
+}
+
+// requireScopes middleware authenticates the request and responds with a 403
+// unless the claims include every scope. When authentication has been
+// switched off with AUTH_REQUIRED=false, requests without credentials are let
+// through anonymously.
+func (app *application) requireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authenticated := app.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := app.getClaims(r.Context())
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="gofetch", error="insufficient_scope", scope=%q`, strings.Join(scopes, " ")))
+					app.forbiddenResponse(w, r, fmt.Errorf("subject %q is missing scope %q", claims.Subject, scope))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		}))
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.config.auth.required && apiKeyFromRequest(r) == "" && r.Header.Get("Authorization") == "" {
+				w.Header().Add("Vary", "Authorization")
+				next.ServeHTTP(w, r)
+				return
+			}
+			authenticated.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/auth"
+)
+
+const testJWTSecret = "test-secret"
+
+// newTestToken returns an HS256 token signed with testJWTSecret.
+func newTestToken(t *testing.T, claims map[string]any) string {
+	t.Helper()
+
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	}
+	h, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	p, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+
+	mac := hmac.New(sha256.New, []byte(testJWTSecret))
+	mac.Write([]byte(input))
+	return input + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newTestAuthApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := newTestApplication(t)
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: []byte(testJWTSecret)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.verifier = verifier
+	app.config.auth.required = true
+	return app
+}
+
+func TestAuthorization(t *testing.T) {
+	app := newTestAuthApplication(t)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.getClaims(r.Context()) == nil {
+			t.Error("Expected claims in request context")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+		header     string
+		expected   int
+	}{
+		{"missing_token", app.authenticate, "", http.StatusUnauthorized},
+		{"wrong_scheme", app.authenticate, "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"invalid_token", app.authenticate, "Bearer not.a.token", http.StatusUnauthorized},
+		{"expired_token", app.authenticate, "Bearer " + newTestToken(t, map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized},
+		{"valid_token", app.authenticate, "Bearer " + newTestToken(t, map[string]any{"sub": "u1"}), http.StatusOK},
+		{"role_present", app.requireRoles("admin"), "Bearer " + newTestToken(t, map[string]any{"roles": []string{"admin"}}), http.StatusOK},
+		{"role_missing", app.requireRoles("admin"), "Bearer " + newTestToken(t, map[string]any{"roles": []string{"user"}}), http.StatusForbidden},
+		{"role_unauthenticated", app.requireRoles("admin"), "", http.StatusUnauthorized},
+		{"scope_present", app.requireScopes("albums:write"), "Bearer " + newTestToken(t, map[string]any{"scope": "albums:read albums:write"}), http.StatusOK},
+		{"scope_missing", app.requireScopes("albums:write"), "Bearer " + newTestToken(t, map[string]any{"scope": "albums:read"}), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			tt.middleware(next).ServeHTTP(rr, r)
+
+			if rr.Code != tt.expected {
+				t.Errorf("Handler returned wrong status code: got %v want %v", rr.Code, tt.expected)
+			}
+			if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected WWW-Authenticate header on 401 response")
+			}
+		})
+	}
+
+	t.Run("rejects_tokens_when_not_configured", func(t *testing.T) {
+		app := newTestApplication(t)
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+newTestToken(t, map[string]any{}))
+
+		app.authenticate(next).ServeHTTP(rr, r)
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("anonymous_writes_when_not_required", func(t *testing.T) {
+		app := newTestAuthApplication(t)
+		app.config.auth.required = false
+		handler := app.requireScopes("albums:write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		for header, want := range map[string]int{
+			"":                   http.StatusOK,
+			"Bearer not.a.token": http.StatusUnauthorized,
+			"Bearer " + newTestToken(t, map[string]any{"scope": "albums:read"}): http.StatusForbidden,
+		} {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/albums", nil)
+			if header != "" {
+				r.Header.Set("Authorization", header)
+			}
+			handler.ServeHTTP(rr, r)
+			if rr.Code != want {
+				t.Errorf("Authorization %q: got status %v want %v", header, rr.Code, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressMinBytes is the smallest response body worth compressing.
+const compressMinBytes = 1024
+
+// zstdWindowSize bounds the memory clients need to decode zstd responses.
+const zstdWindowSize = 1 << 20
+
+// contentEncoder creates pooled compressors for a content-coding.
+type contentEncoder struct {
+	name string
+	pool sync.Pool
+}
+
+// compressor is implemented by the writers of compress/gzip and
+// compress/flate, and by zstd.Encoder.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func newContentEncoder(name string, newCompressor func() compressor) *contentEncoder {
+	return &contentEncoder{name: name, pool: sync.Pool{New: func() any { return newCompressor() }}}
+}
+
+// contentEncoders are the supported content-codings in order of server
+// preference, used to break ties between equal q-values. Brotli (br) has no
+// encoder here; registering one is enough for it to be negotiated.
+var contentEncoders = []*contentEncoder{
+	newContentEncoder("zstd", func() compressor {
+		// One goroutine per encoder suits responses of a few kilobytes.
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(zstdWindowSize))
+		return w
+	}),
+	newContentEncoder("gzip", func() compressor {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}),
+	newContentEncoder("deflate", func() compressor {
+		w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+		return w
+	}),
+}
+
+// negotiateEncoding returns the supported encoder with the highest q-value
+// in an Accept-Encoding header, or nil when identity should be used.
+func negotiateEncoding(acceptEncoding string) *contentEncoder {
+	qvalues := map[string]float64{}
+	for part := range strings.SplitSeq(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if coding != "" {
+			qvalues[strings.ToLower(coding)] = q
+		}
+	}
+
+	var best *contentEncoder
+	bestQ := 0.0
+	for _, enc := range contentEncoders {
+		q, ok := qvalues[enc.name]
+		if !ok {
+			q, ok = qvalues["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// isCompressible reports whether content of contentType benefits from
+// compression.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	return slices.Contains([]string{"application/json", "application/javascript", "application/xml"}, mediaType)
+}
+
+// compress middleware compresses responses with the content-coding the client
+// prefers. Responses smaller than compressMinBytes, with an incompressible or
+// existing encoding, and without a body are sent unchanged. ETags of
+// compressed responses gain a suffix naming the coding, which is removed from
+// conditional request headers before they reach the handler.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressResponseWriter{ResponseWriter: w, enc: enc, status: http.StatusOK}
+		for _, name := range []string{"If-None-Match", "If-Match"} {
+			if v := r.Header.Get(name); strings.Contains(v, cw.etagSuffix()) {
+				r.Header.Set(name, strings.ReplaceAll(v, cw.etagSuffix(), `"`))
+				cw.conditional = true
+			}
+		}
+
+		next.ServeHTTP(cw, r)
+		// Not deferred: after a panic the buffered response is discarded so
+		// recoverPanic can send its own.
+		cw.close()
+	})
+}
+
+// compressResponseWriter buffers the start of a response until it can decide
+// whether to compress it, then streams through a pooled compressor.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	enc         *contentEncoder
+	status      int
+	wroteHeader bool
+	// decided is set once the response is either compressing or passing
+	// through unchanged.
+	decided bool
+	buf     []byte
+	cw      compressor
+	// conditional is set when the request validated a compressed
+	// representation, so a 304 carries the compressed ETag.
+	conditional bool
+}
+
+func (c *compressResponseWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	// Informational responses are sent immediately and do not end the header.
+	if status >= 100 && status < 200 {
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+	c.status = status
+	c.wroteHeader = true
+
+	if status == http.StatusNotModified && c.conditional {
+		c.suffixETag()
+	}
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		c.decide(false)
+	}
+}
+
+func (c *compressResponseWriter) Write(p []byte) (int, error) {
+	c.WriteHeader(http.StatusOK)
+
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) < compressMinBytes {
+			return len(p), nil
+		}
+		c.decide(true)
+		return len(p), c.flushBuffer()
+	}
+
+	if c.cw != nil {
+		return c.cw.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// Flush sends buffered data to the client, deciding whether to compress if
+// that has not happened yet, so streaming responses are not held back.
+func (c *compressResponseWriter) Flush() {
+	c.WriteHeader(http.StatusOK)
+	if !c.decided {
+		c.decide(true)
+		c.flushBuffer()
+	}
+	if c.cw != nil {
+		c.cw.Flush()
+	}
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+func (c *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(c.ResponseWriter).Hijack()
+}
+
+func (c *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+// decide writes the header, compressing if want is set and the response
+// allows it.
+func (c *compressResponseWriter) decide(want bool) {
+	c.decided = true
+	h := c.Header()
+
+	if h.Get("Content-Type") == "" && len(c.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+
+	if want && h.Get("Content-Encoding") == "" && isCompressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", c.enc.name)
+		h.Del("Content-Length")
+		c.suffixETag()
+
+		c.cw = c.enc.pool.Get().(compressor)
+		c.cw.Reset(c.ResponseWriter)
+	}
+
+	c.ResponseWriter.WriteHeader(c.status)
+}
+
+// etagSuffix is the end of the ETag of a representation compressed by c.
+func (c *compressResponseWriter) etagSuffix() string {
+	return "-" + c.enc.name + `"`
+}
+
+// suffixETag marks the ETag as belonging to the compressed representation.
+func (c *compressResponseWriter) suffixETag() {
+	if etag := c.Header().Get("ETag"); strings.HasSuffix(etag, `"`) {
+		c.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+c.etagSuffix())
+	}
+}
+
+func (c *compressResponseWriter) flushBuffer() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.cw != nil {
+		_, err = c.cw.Write(c.buf)
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf)
+	}
+	c.buf = nil
+	return err
+}
+
+// close sends a response that was too small to compress and finishes and
+// returns the compressor to its pool.
+func (c *compressResponseWriter) close() {
+	if !c.decided {
+		if !c.wroteHeader && len(c.buf) == 0 {
+			// The handler wrote nothing; let the server send its default.
+			return
+		}
+		c.decide(false)
+		c.flushBuffer()
+	}
+
+	if c.cw != nil {
+		c.cw.Close()
+		c.enc.pool.Put(c.cw)
+		c.cw = nil
+	}
+}
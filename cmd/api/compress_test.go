@@ -0,0 +1,181 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expect         string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"br", ""},
+		{"br, gzip;q=0.8", "gzip"},
+		{"*", "zstd"},
+		{"zstd;q=0, *", "gzip"},
+		{"identity", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			got := ""
+			if enc := negotiateEncoding(tt.acceptEncoding); enc != nil {
+				got = enc.name
+			}
+			if got != tt.expect {
+				t.Errorf("got %q want %q", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	app := newTestApplication(t)
+	large := `{"data":"` + strings.Repeat("a", compressMinBytes) + `"}`
+
+	serve := func(handler http.HandlerFunc, header map[string]string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		for k, v := range header {
+			r.Header.Set(k, v)
+		}
+		app.compress(handler).ServeHTTP(rr, r)
+		return rr
+	}
+
+	t.Run("compresses_large_json", func(t *testing.T) {
+		rr := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"abc"`)
+			writeJSON(w, http.StatusOK, large)
+		}, nil)
+
+		if rr.Header().Get("Content-Encoding") != "gzip" || rr.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("unexpected headers: %v", rr.Header())
+		}
+		if got := rr.Header().Get("ETag"); got != `"abc-gzip"` {
+			t.Errorf("ETag: got %s want %s", got, `"abc-gzip"`)
+		}
+
+		zr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), strings.Repeat("a", compressMinBytes)) {
+			t.Errorf("unexpected body: %s", body)
+		}
+	})
+
+	t.Run("compresses_with_zstd", func(t *testing.T) {
+		rr := serve(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, large)
+		}, map[string]string{"Accept-Encoding": "zstd"})
+
+		if rr.Header().Get("Content-Encoding") != "zstd" {
+			t.Fatalf("unexpected headers: %v", rr.Header())
+		}
+		zr, err := zstd.NewReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		body, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), strings.Repeat("a", compressMinBytes)) {
+			t.Errorf("unexpected body: %s", body)
+		}
+	})
+
+	t.Run("skips_small_responses", func(t *testing.T) {
+		rr := serve(func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, "small")
+		}, nil)
+
+		if rr.Header().Get("Content-Encoding") != "" || rr.Body.String() != "\"small\"\n" {
+			t.Errorf("unexpected response: %v %q", rr.Header(), rr.Body)
+		}
+	})
+
+	t.Run("skips_incompressible_and_encoded", func(t *testing.T) {
+		rr := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(large))
+		}, nil)
+		if rr.Header().Get("Content-Encoding") != "" {
+			t.Errorf("compressed image/png")
+		}
+
+		rr = serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte(large))
+		}, nil)
+		if rr.Header().Get("Content-Encoding") != "br" || rr.Body.String() != large {
+			t.Errorf("re-encoded an encoded response")
+		}
+	})
+
+	t.Run("skips_no_content", func(t *testing.T) {
+		rr := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, nil)
+		if rr.Code != http.StatusNoContent || rr.Header().Get("Content-Encoding") != "" {
+			t.Errorf("unexpected response: %v %v", rr.Code, rr.Header())
+		}
+	})
+
+	t.Run("not_modified_keeps_compressed_etag", func(t *testing.T) {
+		rr := serve(func(w http.ResponseWriter, r *http.Request) {
+			if notModified(w, r, `"abc"`, time.Time{}) {
+				return
+			}
+			writeJSON(w, http.StatusOK, large)
+		}, map[string]string{"If-None-Match": `"abc-gzip"`})
+
+		if rr.Code != http.StatusNotModified || rr.Header().Get("ETag") != `"abc-gzip"` {
+			t.Errorf("unexpected response: %v %v", rr.Code, rr.Header())
+		}
+	})
+
+	t.Run("flushes_streaming_responses", func(t *testing.T) {
+		rr := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: 1\n\n"))
+			http.NewResponseController(w).Flush()
+
+			if !strings.Contains(w.Header().Get("Content-Encoding"), "gzip") {
+				t.Error("expected the stream to be compressed once flushed")
+			}
+		}, nil)
+
+		if !rr.Flushed {
+			t.Error("expected the response to be flushed")
+		}
+		zr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body, _ := io.ReadAll(zr); string(body) != "data: 1\n\n" {
+			t.Errorf("unexpected body: %q", body)
+		}
+	})
+}
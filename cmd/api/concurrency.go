@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"gofetch.timwalker.dev/internal/metrics"
+)
+
+// concurrencyConfig bounds the concurrent requests of a route.
+type concurrencyConfig struct {
+	// limit is the number of requests handled at once, and the starting
+	// limit when adaptive is set.
+	limit int
+	// minLimit and maxLimit bound an adaptive limit.
+	minLimit int
+	maxLimit int
+	// queue is how many requests may wait for a slot, each for at most
+	// queueTimeout, before new ones are rejected.
+	queue        int
+	queueTimeout time.Duration
+	// adaptive adjusts the limit with AIMD: it grows by one per limit
+	// requests that finish within targetLatency while the route is busy, and
+	// shrinks by backoff when a request is slower, times out, or waits out
+	// queueTimeout. Requests cancelled by the client do not change it.
+	adaptive      bool
+	targetLatency time.Duration
+}
+
+// concurrencyBackoff is the multiplicative decrease of an adaptive limit.
+const concurrencyBackoff = 0.9
+
+// errConcurrencyLimited is returned by acquire when a request is shed.
+var errConcurrencyLimited = errors.New("concurrency limit reached")
+
+// concurrencyLimiter admits up to limit requests at once and queues a few
+// more in arrival order.
+type concurrencyLimiter struct {
+	cfg concurrencyConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inflight int
+	waiters  []chan struct{}
+}
+
+func newConcurrencyLimiter(cfg concurrencyConfig) *concurrencyLimiter {
+	cfg.limit = max(cfg.limit, 1)
+	cfg.minLimit = min(max(cfg.minLimit, 1), cfg.limit)
+	cfg.maxLimit = max(cfg.maxLimit, cfg.limit)
+	return &concurrencyLimiter{cfg: cfg, limit: float64(cfg.limit)}
+}
+
+// acquire takes a slot, waiting in the queue if there is room. It returns
+// errConcurrencyLimited when the queue is full or the wait times out, and the
+// context error when the request is cancelled while waiting. Waits that time
+// out, in the queue or on the request deadline, back off an adaptive limit.
+func (l *concurrencyLimiter) acquire(ctx context.Context) error {
+	l.mu.Lock()
+	if l.inflight < l.currentLimit() && len(l.waiters) == 0 {
+		l.inflight++
+		l.mu.Unlock()
+		return nil
+	}
+	if len(l.waiters) >= l.cfg.queue {
+		l.mu.Unlock()
+		return errConcurrencyLimited
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.cfg.queueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		err = errConcurrencyLimited
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if errors.Is(err, errConcurrencyLimited) || errors.Is(err, context.DeadlineExceeded) {
+		l.backoffLocked()
+	}
+	select {
+	case <-ready:
+		// A slot was handed over while timing out; pass it on.
+		l.inflight--
+		l.dispatchLocked()
+	default:
+		l.removeWaiterLocked(ready)
+	}
+	return err
+}
+
+// release frees a slot and, for adaptive limiters, adjusts the limit from the
+// latency of the request and whether it timed out.
+func (l *concurrencyLimiter) release(latency time.Duration, timedOut bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cfg.adaptive {
+		switch {
+		case timedOut || latency > l.cfg.targetLatency:
+			l.backoffLocked()
+		case l.inflight >= l.currentLimit():
+			// Only grow while the limit is being used, otherwise an idle
+			// route would drift to maxLimit.
+			l.limit = min(l.limit+1/l.limit, float64(l.cfg.maxLimit))
+		}
+	}
+
+	l.inflight--
+	l.dispatchLocked()
+}
+
+// backoffLocked applies the multiplicative decrease to an adaptive limit.
+func (l *concurrencyLimiter) backoffLocked() {
+	if l.cfg.adaptive {
+		l.limit = max(l.limit*concurrencyBackoff, float64(l.cfg.minLimit))
+	}
+}
+
+// dispatchLocked hands free slots to queued requests.
+func (l *concurrencyLimiter) dispatchLocked() {
+	for len(l.waiters) > 0 && l.inflight < l.currentLimit() {
+		ready := l.waiters[0]
+		l.waiters = l.waiters[1:]
+		l.inflight++
+		close(ready)
+	}
+}
+
+func (l *concurrencyLimiter) removeWaiterLocked(ready chan struct{}) {
+	for i, c := range l.waiters {
+		if c == ready {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (l *concurrencyLimiter) currentLimit() int {
+	return int(math.Floor(l.limit))
+}
+
+// stats returns the current limit, in-flight requests and queue depth.
+func (l *concurrencyLimiter) stats() (limit, inflight, queued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.currentLimit(), l.inflight, len(l.waiters)
+}
+
+// concurrencyLimit middleware caps the concurrent requests to a route so a
+// CPU-heavy route cannot starve the rest of the server. Requests over the
+// limit wait briefly in a small queue and are otherwise rejected with a 503
+// and Retry-After. The limit, in-flight requests, queue depth and rejections
+// are exported at /metrics under the route name.
+func (app *application) concurrencyLimit(route string, cfg concurrencyConfig) func(http.Handler) http.Handler {
+	limiter := newConcurrencyLimiter(cfg)
+
+	var rejected *metrics.CounterVec
+	if app.metrics != nil {
+		app.metrics.Gauge("gofetch_concurrency_limit", "Current concurrency limit of a route.", "route").
+			Func(func() float64 { v, _, _ := limiter.stats(); return float64(v) }, route)
+		app.metrics.Gauge("gofetch_concurrency_inflight", "Requests a route is handling.", "route").
+			Func(func() float64 { _, v, _ := limiter.stats(); return float64(v) }, route)
+		app.metrics.Gauge("gofetch_concurrency_queue_depth", "Requests waiting for a concurrency slot.", "route").
+			Func(func() float64 { _, _, v := limiter.stats(); return float64(v) }, route)
+		rejected = app.metrics.Counter("gofetch_concurrency_rejected_total", "Requests rejected by the concurrency limiter.", "route")
+		rejected.Add(0, route)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := limiter.acquire(r.Context()); err != nil {
+				if !errors.Is(err, errConcurrencyLimited) {
+					// The client went away or the route timed out while queued.
+					return
+				}
+				if rejected != nil {
+					rejected.Inc(route)
+				}
+				app.overloadedResponse(w, r, route)
+				return
+			}
+
+			// Only the route timing out signals overload; a client that went
+			// away says nothing about the server.
+			start := time.Now()
+			defer func() {
+				limiter.release(time.Since(start), errors.Is(r.Context().Err(), context.DeadlineExceeded))
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	t.Run("queues_then_sheds", func(t *testing.T) {
+		l := newConcurrencyLimiter(concurrencyConfig{limit: 1, queue: 1, queueTimeout: time.Second})
+		ctx := context.Background()
+
+		if err := l.acquire(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		queued := make(chan error)
+		go func() { queued <- l.acquire(ctx) }()
+		waitFor(t, func() bool { _, _, q := l.stats(); return q == 1 })
+
+		if err := l.acquire(ctx); !errors.Is(err, errConcurrencyLimited) {
+			t.Fatalf("expected the full queue to shed, got %v", err)
+		}
+
+		l.release(0, false)
+		if err := <-queued; err != nil {
+			t.Fatalf("expected the queued request to be admitted, got %v", err)
+		}
+		if limit, inflight, queue := l.stats(); limit != 1 || inflight != 1 || queue != 0 {
+			t.Errorf("unexpected stats: limit=%d inflight=%d queue=%d", limit, inflight, queue)
+		}
+	})
+
+	t.Run("queue_timeout", func(t *testing.T) {
+		l := newConcurrencyLimiter(concurrencyConfig{limit: 1, queue: 1, queueTimeout: 10 * time.Millisecond})
+		l.acquire(context.Background())
+
+		if err := l.acquire(context.Background()); !errors.Is(err, errConcurrencyLimited) {
+			t.Fatalf("expected a queue timeout, got %v", err)
+		}
+		if _, _, queue := l.stats(); queue != 0 {
+			t.Errorf("expected the timed out request to leave the queue, got %d", queue)
+		}
+	})
+
+	t.Run("aimd", func(t *testing.T) {
+		l := newConcurrencyLimiter(concurrencyConfig{
+			limit: 10, minLimit: 2, maxLimit: 11, adaptive: true, targetLatency: 100 * time.Millisecond,
+		})
+
+		l.acquire(context.Background())
+		l.release(time.Second, false)
+		if limit, _, _ := l.stats(); limit != 9 {
+			t.Errorf("expected a slow request to decrease the limit to 9, got %d", limit)
+		}
+
+		for range 20 {
+			l.release(time.Second, true)
+			l.inflight++
+		}
+		if limit, _, _ := l.stats(); limit != 2 {
+			t.Errorf("expected the limit to stop at the minimum, got %d", limit)
+		}
+
+		// Fast requests grow the limit only while it is saturated.
+		l.release(time.Millisecond, false)
+		if limit, _, _ := l.stats(); limit != 2 {
+			t.Errorf("expected an idle route to keep its limit, got %d", limit)
+		}
+		for range 10 {
+			l.inflight = l.currentLimit() + 1
+			l.release(time.Millisecond, false)
+		}
+		if limit, _, _ := l.stats(); limit <= 2 {
+			t.Errorf("expected a busy route to grow its limit, got %d", limit)
+		}
+	})
+
+	t.Run("client_cancellation_keeps_limit", func(t *testing.T) {
+		l := newConcurrencyLimiter(concurrencyConfig{
+			limit: 10, minLimit: 1, queue: 1, queueTimeout: time.Second, adaptive: true, targetLatency: time.Second,
+		})
+		for range 10 {
+			l.acquire(context.Background())
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		queued := make(chan error)
+		go func() { queued <- l.acquire(ctx) }()
+		waitFor(t, func() bool { _, _, q := l.stats(); return q == 1 })
+		cancel()
+		if err := <-queued; !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected the cancellation, got %v", err)
+		}
+		if limit, _, _ := l.stats(); limit != 10 {
+			t.Errorf("expected a cancelled request to keep the limit at 10, got %d", limit)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := l.acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the deadline, got %v", err)
+		}
+		if limit, _, _ := l.stats(); limit != 9 {
+			t.Errorf("expected a request timing out in the queue to decrease the limit to 9, got %d", limit)
+		}
+	})
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	app := newTestApplication(t)
+	release := make(chan struct{})
+	handler := app.concurrencyLimit("test", concurrencyConfig{limit: 1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	waitFor(t, func() bool {
+		return strings.Contains(scrapeMetrics(app), `gofetch_concurrency_inflight{route="test"} 1`)
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	close(release)
+	wg.Wait()
+
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected a 503 with Retry-After, got %d %v", rr.Code, rr.Header())
+	}
+	if !strings.Contains(scrapeMetrics(app), `gofetch_concurrency_rejected_total{route="test"} 1`) {
+		t.Errorf("expected the rejection to be counted:\n%s", scrapeMetrics(app))
+	}
+}
+
+func scrapeMetrics(app *application) string {
+	var b strings.Builder
+	app.metrics.WriteTo(&b)
+	return b.String()
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// versionETag returns a strong ETag for a version of a resource.
+func versionETag(version int) string {
+	return `"v` + strconv.Itoa(version) + `"`
+}
+
+// bodyETag returns a strong ETag for a response body.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// etagListMatches reports whether etag is in an If-Match or If-None-Match
+// header value, or the value is "*". Weak comparison ignores the W/ prefix of
+// weak tags; strong comparison never matches them.
+func etagListMatches(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for tag := range strings.SplitSeq(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag and, when modified is not zero, Last-Modified
+// validators of a GET or HEAD response. It writes a 304 and returns true when
+// If-None-Match, or If-Modified-Since in its absence, shows the client already
+// has the current representation.
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagListMatches(inm, etag, true) {
+			return false
+		}
+	} else {
+		since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		// HTTP dates have second precision.
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// checkIfMatch enforces the If-Match precondition of an unsafe request
+// against the current ETag of the resource. It writes a 412 and returns false
+// when the precondition fails. Requests without If-Match pass.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || etagListMatches(ifMatch, etag, false) {
+		return true
+	}
+
+	app.preconditionFailedResponse(w, r)
+	return false
+}
+
+// etag middleware adds a strong ETag computed from the body of successful
+// GET and HEAD responses that do not set their own, and answers a matching
+// If-None-Match with 304 Not Modified.
+func (app *application) etag(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bufferedResponseWriter{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+
+		if buf.status == http.StatusOK && w.Header().Get("ETag") == "" {
+			if notModified(w, r, bodyETag(buf.body.Bytes()), time.Time{}) {
+				return
+			}
+		}
+
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	})
+}
+
+// bufferedResponseWriter holds the status and body of a response so they can
+// be inspected before being written. Headers are shared with the underlying
+// writer.
+type bufferedResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (b *bufferedResponseWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponseWriter) WriteHeader(status int) {
+	if !b.wroteHeader {
+		b.status = status
+		b.wroteHeader = true
+	}
+}
+
+func (b *bufferedResponseWriter) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	app := newTestAuthApplication(t)
+	mux := app.registerRoutes()
+	token := "Bearer " + newTestToken(t, map[string]any{"sub": "user-1", "scope": "albums:write"})
+
+	rr := doRequest(mux, http.MethodPost, "/albums", token, `{"userId":1,"title":"first"}`)
+	etag := rr.Header().Get("ETag")
+	if etag != versionETag(1) {
+		t.Fatalf("create ETag: got %q want %q", etag, versionETag(1))
+	}
+
+	t.Run("if_none_match", func(t *testing.T) {
+		if rr := doRequest(mux, http.MethodGet, "/albums/1", token, "", "If-None-Match", `"other", `+etag); rr.Code != http.StatusNotModified {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusNotModified)
+		}
+		if rr := doRequest(mux, http.MethodGet, "/albums/1", token, "", "If-None-Match", `"other"`); rr.Code != http.StatusOK {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("if_modified_since", func(t *testing.T) {
+		future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+		if rr := doRequest(mux, http.MethodGet, "/albums/1", token, "", "If-Modified-Since", future); rr.Code != http.StatusNotModified {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusNotModified)
+		}
+		past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+		if rr := doRequest(mux, http.MethodGet, "/albums/1", token, "", "If-Modified-Since", past); rr.Code != http.StatusOK {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("if_match", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodPatch, "/albums/1", token, `{"title":"second"}`, "If-Match", etag)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %v want %v", rr.Code, http.StatusOK)
+		}
+		if got := rr.Header().Get("ETag"); got != versionETag(2) {
+			t.Errorf("ETag: got %q want %q", got, versionETag(2))
+		}
+
+		// The first ETag is now stale.
+		if rr := doRequest(mux, http.MethodPut, "/albums/1", token, `{"userId":1,"title":"lost"}`, "If-Match", etag); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("put: got status %v want %v", rr.Code, http.StatusPreconditionFailed)
+		}
+		if rr := doRequest(mux, http.MethodDelete, "/albums/1", token, "", "If-Match", etag); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("delete: got status %v want %v", rr.Code, http.StatusPreconditionFailed)
+		}
+		if rr := doRequest(mux, http.MethodDelete, "/albums/1", token, "", "If-Match", "W/"+versionETag(2)); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("weak tag: got status %v want %v", rr.Code, http.StatusPreconditionFailed)
+		}
+	})
+
+	t.Run("body_etag", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodGet, "/albums", token, "")
+		etag := rr.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("expected an ETag")
+		}
+		if rr := doRequest(mux, http.MethodGet, "/albums", token, "", "If-None-Match", etag); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusNotModified)
+		}
+
+		doRequest(mux, http.MethodPatch, "/albums/1", token, `{"title":"third"}`)
+		if rr := doRequest(mux, http.MethodGet, "/albums", token, "", "If-None-Match", etag); rr.Code != http.StatusOK {
+			t.Errorf("after update: got status %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("delete_race", func(t *testing.T) {
+		// An update between reading the album and deleting it fails the
+		// delete, even though If-Match matched the version read.
+		mdb := app.db.(*MockDB)
+		app.db = racingDB{mdb}
+		defer func() { app.db = mdb }()
+
+		if rr := doRequest(mux, http.MethodDelete, "/albums/1", token, "", "If-Match", versionETag(3)); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusPreconditionFailed)
+		}
+		if album, err := mdb.Albums().Get(context.Background(), 1); err != nil || album.Version != 4 {
+			t.Errorf("album after a failed delete: %+v, %v", album, err)
+		}
+	})
+}
+
+// racingDB updates each album just after it is read, as a concurrent
+// request would.
+type racingDB struct{ *MockDB }
+
+func (db racingDB) Albums() database.AlbumRepository {
+	return racingAlbums{db.MockDB.Albums()}
+}
+
+type racingAlbums struct{ database.AlbumRepository }
+
+func (r racingAlbums) Get(ctx context.Context, id int) (*database.Album, error) {
+	album, err := r.AlbumRepository.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	updated := *album
+	if err := r.AlbumRepository.Update(ctx, &updated); err != nil {
+		return nil, err
+	}
+	return album, nil
+}
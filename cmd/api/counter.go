@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/metrics"
 )
 
 // viewCounterHandler shows and increments a counter stored in Redis.
 func (app *application) viewCounterHandler(w http.ResponseWriter, r *http.Request) {
-	count := app.db.IncrementCounter()
+	count, err := app.db.IncrementCounter(r.Context())
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
 
 	jsonResp, err := json.Marshal(map[string]int{"count": count})
 	if err != nil {
@@ -17,3 +30,220 @@ func (app *application) viewCounterHandler(w http.ResponseWriter, r *http.Reques
 
 	w.Write(jsonResp)
 }
+
+// counterStreamHandler streams the counter as Server-Sent Events named count,
+// with the value as the event ID. The current value is sent first, unless it
+// equals the Last-Event-ID of a reconnecting client; intermediate values
+// missed while disconnected are not replayed, since only the latest matters.
+func (app *application) counterStreamHandler(w http.ResponseWriter, r *http.Request) {
+	updates, err := app.counterBroker.subscribe()
+	if err != nil {
+		app.overloadedResponse(w, r, "counter-stream")
+		return
+	}
+	defer app.counterBroker.unsubscribe(updates)
+
+	// Read after subscribing so an increment in between is not missed.
+	count, err := app.db.CounterValue(r.Context())
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	cfg := app.config.counterStream
+	stream, err := newSSEWriter(w, cfg.writeTimeout)
+	if err != nil {
+		return
+	}
+
+	send := func(count int) error {
+		id := strconv.Itoa(count)
+		return stream.event(id, "count", `{"count":`+id+`}`)
+	}
+
+	if r.Header.Get("Last-Event-ID") != strconv.Itoa(count) {
+		if err := send(count); err != nil {
+			return
+		}
+	}
+	last := count
+
+	heartbeat := time.NewTicker(cfg.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case count, ok := <-updates:
+			if !ok {
+				// The server is shutting down.
+				return
+			}
+			// Messages from several replicas may arrive out of order.
+			if count <= last {
+				continue
+			}
+			last = count
+			err = send(count)
+		case <-heartbeat.C:
+			err = stream.comment("heartbeat")
+		}
+		if err != nil {
+			app.logger.Debug("counter stream closed", "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+			return
+		}
+	}
+}
+
+var (
+	errTooManyStreams = errors.New("too many streams")
+	errStreamsClosed  = errors.New("streams are closed")
+)
+
+// counterBroker fans the values published on database.CounterChannel out to
+// the streams of this replica, so a replica holds one subscription however
+// many clients are connected.
+type counterBroker struct {
+	pubsub     database.PubSub
+	logger     *slog.Logger
+	maxClients int
+
+	mu      sync.Mutex
+	clients map[chan int]struct{}
+	closed  bool
+
+	// stop ends run when the broker is closed.
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+// newCounterBroker returns a broker serving at most maxClients streams. Call
+// run to start receiving values.
+func newCounterBroker(pubsub database.PubSub, logger *slog.Logger, maxClients int, reg *metrics.Registry) *counterBroker {
+	ctx, stop := context.WithCancel(context.Background())
+	b := &counterBroker{
+		pubsub:     pubsub,
+		logger:     logger,
+		maxClients: max(maxClients, 1),
+		clients:    make(map[chan int]struct{}),
+		ctx:        ctx,
+		stop:       stop,
+	}
+
+	reg.Gauge("gofetch_counter_stream_clients", "Connected counter streams.").
+		Func(func() float64 { return float64(b.clientCount()) })
+	return b
+}
+
+// clientCount returns the number of connected streams.
+func (b *counterBroker) clientCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+// run subscribes to the counter channel until the broker is closed,
+// resubscribing with backoff when the subscription fails.
+func (b *counterBroker) run() {
+	backoff := time.Second
+	for {
+		sub, err := b.pubsub.Subscribe(b.ctx, database.CounterChannel)
+		if err != nil {
+			if b.ctx.Err() != nil {
+				return
+			}
+			b.logger.Warn("counter subscription failed", "error", err.Error(), "retry", backoff.String())
+			select {
+			case <-time.After(backoff):
+				backoff = min(2*backoff, 30*time.Second)
+				continue
+			case <-b.ctx.Done():
+				return
+			}
+		}
+		backoff = time.Second
+
+		b.receive(sub)
+		sub.Close()
+		if b.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// receive broadcasts the values of sub until it ends or the broker is closed.
+func (b *counterBroker) receive(sub database.Subscription) {
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+			count, err := strconv.Atoi(string(msg.Payload))
+			if err != nil {
+				b.logger.Warn("invalid counter message", "payload", string(msg.Payload))
+				continue
+			}
+			b.broadcast(count)
+		}
+	}
+}
+
+// broadcast sends count to every stream. A stream that has not taken the
+// previous value has it replaced, so slow clients skip to the latest value
+// instead of holding up the others.
+func (b *counterBroker) broadcast(count int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.clients {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- count
+	}
+}
+
+// subscribe registers a stream. It returns errTooManyStreams at the
+// connection cap and errStreamsClosed once the broker is closed.
+func (b *counterBroker) subscribe() (chan int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, errStreamsClosed
+	}
+	if len(b.clients) >= b.maxClients {
+		return nil, errTooManyStreams
+	}
+	ch := make(chan int, 1)
+	b.clients[ch] = struct{}{}
+	return ch, nil
+}
+
+func (b *counterBroker) unsubscribe(ch chan int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.clients, ch)
+}
+
+// close ends every stream and the subscription. It is registered with
+// http.Server.RegisterOnShutdown, since streams never go idle on their own.
+func (b *counterBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.stop()
+	for ch := range b.clients {
+		close(ch)
+		delete(b.clients, ch)
+	}
+}
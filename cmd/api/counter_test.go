@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+// sseEvent is an event or comment read from a stream; retry fields are
+// skipped.
+type sseEvent struct {
+	id, event, data, comment string
+}
+
+// openStream connects to an event stream and returns its events, closed
+// when the stream ends.
+func openStream(t *testing.T, url, lastEventID string) (*http.Response, <-chan sseEvent) {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		var ev sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			field, value, _ := strings.Cut(line, ": ")
+			switch field {
+			case "":
+				if line == "" && ev != (sseEvent{}) {
+					events <- ev
+					ev = sseEvent{}
+				} else if strings.HasPrefix(line, ":") {
+					ev.comment = strings.TrimPrefix(line, ": ")
+				}
+			case "id":
+				ev.id = value
+			case "event":
+				ev.event = value
+			case "data":
+				ev.data = value
+			}
+		}
+	}()
+	return resp, events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	return sseEvent{}
+}
+
+func TestCounterStream(t *testing.T) {
+	app := newTestApplication(t)
+	mdb := app.db.(*MockDB)
+	srv := httptest.NewServer(app.registerRoutes())
+	defer srv.Close()
+	waitFor(t, func() bool { return mdb.NumSub(database.CounterChannel) > 0 })
+
+	value := func() int64 {
+		count, _ := mdb.CounterValue(context.Background())
+		return int64(count)
+	}
+
+	increment := func() {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/counter")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	t.Run("sends_current_value_then_updates", func(t *testing.T) {
+		increment()
+		resp, events := openStream(t, srv.URL+"/counter/stream", "")
+		if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+			t.Fatalf("got status %v and content type %q", resp.StatusCode, ct)
+		}
+
+		current := value()
+		ev := nextEvent(t, events)
+		if ev.event != "count" || ev.id != itoa(current) || ev.data != `{"count":`+itoa(current)+`}` {
+			t.Fatalf("unexpected first event %+v", ev)
+		}
+
+		increment()
+		if ev := nextEvent(t, events); ev.id != itoa(current+1) {
+			t.Errorf("got event %+v want id %d", ev, current+1)
+		}
+	})
+
+	t.Run("resumes_from_last_event_id", func(t *testing.T) {
+		current := value()
+		_, events := openStream(t, srv.URL+"/counter/stream", itoa(current))
+		waitFor(t, func() bool { return app.counterBroker.clientCount() == 1 })
+
+		increment()
+		if ev := nextEvent(t, events); ev.id != itoa(current+1) {
+			t.Errorf("got event %+v want id %d", ev, current+1)
+		}
+	})
+
+	t.Run("sends_heartbeats", func(t *testing.T) {
+		app.config.counterStream.heartbeat = 10 * time.Millisecond
+		defer func() { app.config.counterStream.heartbeat = time.Minute }()
+
+		_, events := openStream(t, srv.URL+"/counter/stream", itoa(value()))
+		if ev := nextEvent(t, events); ev.comment != "heartbeat" {
+			t.Errorf("got event %+v want heartbeat", ev)
+		}
+	})
+
+	t.Run("caps_connections", func(t *testing.T) {
+		waitFor(t, func() bool { return app.counterBroker.clientCount() == 0 })
+		for range app.config.counterStream.maxClients {
+			openStream(t, srv.URL+"/counter/stream", "")
+		}
+		waitFor(t, func() bool { return app.counterBroker.clientCount() == app.config.counterStream.maxClients })
+
+		resp, err := http.Get(srv.URL + "/counter/stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("got status %v want %v", resp.StatusCode, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("ends_on_shutdown", func(t *testing.T) {
+		waitFor(t, func() bool { return app.counterBroker.clientCount() == 0 })
+		_, events := openStream(t, srv.URL+"/counter/stream", "")
+		nextEvent(t, events)
+
+		app.counterBroker.close()
+		select {
+		case _, ok := <-events:
+			if ok {
+				t.Error("unexpected event after close")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("stream did not end")
+		}
+
+		resp, err := http.Get(srv.URL + "/counter/stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("got status %v want %v after close", resp.StatusCode, http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+func TestViewCounterDatabaseErrors(t *testing.T) {
+	app := newTestApplication(t)
+	mux := app.registerRoutes()
+
+	for _, tt := range []struct {
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{fmt.Errorf("%w: connection refused", database.ErrUnavailable), http.StatusServiceUnavailable, "5"},
+		{fmt.Errorf("%w: i/o timeout", database.ErrTimeout), http.StatusGatewayTimeout, ""},
+		{errors.New("ERR unknown command"), http.StatusInternalServerError, ""},
+	} {
+		app.db.(*MockDB).pingErr = tt.err
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/counter", nil))
+		if rr.Code != tt.status || rr.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("%v: got status %v, Retry-After %q", tt.err, rr.Code, rr.Header().Get("Retry-After"))
+		}
+	}
+}
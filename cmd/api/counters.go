@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/validator"
+)
+
+const (
+	// maxCounterStep bounds the by parameter of increments and decrements.
+	maxCounterStep = 1_000_000_000
+	// maxCounterTTL bounds counter TTLs and windows.
+	maxCounterTTL = 365 * 24 * time.Hour
+)
+
+var counterNameRX = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,128}$`)
+
+// readCounterName returns the {name} route parameter.
+func readCounterName(r *http.Request) (string, error) {
+	name := r.PathValue("name")
+	if !counterNameRX.MatchString(name) {
+		return "", validator.Errors{"name": "must be 1 to 128 letters, digits, '_', '.', ':' or '-'"}
+	}
+	return name, nil
+}
+
+// readCounterDuration parses the duration query parameter key, which is
+// zero when absent.
+func readCounterDuration(r *http.Request, key string, errs validator.Errors) time.Duration {
+	s := r.URL.Query().Get(key)
+	if s == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 || d > maxCounterTTL {
+		errs.Add(key, fmt.Sprintf("must be a duration such as 30s or 1h, at most %s", maxCounterTTL))
+		return 0
+	}
+	if d > 0 && d < time.Millisecond {
+		errs.Add(key, "must be at least 1ms")
+	}
+	return d
+}
+
+// getCounterHandler returns a counter; counters that do not exist are zero.
+func (app *application) getCounterHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := readCounterName(r)
+	if err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	counter, err := app.db.Counters().Get(r.Context(), name)
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	err = writeJSONData(w, http.StatusOK, counter)
+	if err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// incrCounterHandler adds the by query parameter, default 1, to a counter.
+// The ttl parameter sets the counter to expire after the update, and the
+// window parameter makes it a fixed-window counter that restarts from zero
+// the given time after its first update.
+func (app *application) incrCounterHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateCounter(w, r, 1)
+}
+
+// decrCounterHandler subtracts the by query parameter, default 1, from a
+// counter, with the same parameters as incrCounterHandler.
+func (app *application) decrCounterHandler(w http.ResponseWriter, r *http.Request) {
+	app.updateCounter(w, r, -1)
+}
+
+func (app *application) updateCounter(w http.ResponseWriter, r *http.Request, sign int64) {
+	name, err := readCounterName(r)
+	if err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	errs := validator.Errors{}
+	by := int64(1)
+	if s := r.URL.Query().Get("by"); s != "" {
+		by, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || by < 1 || by > maxCounterStep {
+			errs.Add("by", fmt.Sprintf("must be an integer between 1 and %d", maxCounterStep))
+		}
+	}
+	opts := database.CounterOptions{
+		TTL:    readCounterDuration(r, "ttl", errs),
+		Window: readCounterDuration(r, "window", errs),
+	}
+	if opts.TTL > 0 && opts.Window > 0 {
+		errs.Add("window", "cannot be combined with ttl")
+	}
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	counter, err := app.db.Counters().Incr(r.Context(), name, sign*by, opts)
+	if err != nil {
+		if errors.Is(err, database.ErrCounterOverflow) {
+			app.failedValidationResponse(w, r, validator.Errors{"by": "would overflow the counter"})
+			return
+		}
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	err = writeJSONData(w, http.StatusOK, counter)
+	if err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// expireCounterHandler sets the TTL of a counter to the ttl query parameter,
+// or removes it when ttl is 0.
+func (app *application) expireCounterHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := readCounterName(r)
+	if err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+	errs := validator.Errors{}
+	if r.URL.Query().Get("ttl") == "" {
+		errs.Add("ttl", "must be provided")
+	}
+	ttl := readCounterDuration(r, "ttl", errs)
+	if len(errs) > 0 {
+		app.failedValidationResponse(w, r, errs)
+		return
+	}
+
+	counter, err := app.db.Counters().Expire(r.Context(), name, ttl)
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	err = writeJSONData(w, http.StatusOK, counter)
+	if err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// resetCounterHandler deletes a counter, returning it to zero.
+func (app *application) resetCounterHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := readCounterName(r)
+	if err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	err = app.db.Counters().Reset(r.Context(), name)
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	err = writeJSONData(w, http.StatusOK, &database.Counter{Name: name})
+	if err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+func TestCounters(t *testing.T) {
+	app := newTestApplication(t)
+	mux := app.registerRoutes()
+
+	counter := func(t *testing.T, rr *httptest.ResponseRecorder) database.Counter {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		var envelope struct {
+			Data database.Counter `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&envelope); err != nil {
+			t.Fatal(err)
+		}
+		return envelope.Data
+	}
+
+	t.Run("operations", func(t *testing.T) {
+		if c := counter(t, doRequest(mux, http.MethodGet, "/counters/visits", "", "")); c.Name != "visits" || c.Value != 0 {
+			t.Errorf("missing counter: %+v", c)
+		}
+		counter(t, doRequest(mux, http.MethodPost, "/counters/visits/incr", "", ""))
+		if c := counter(t, doRequest(mux, http.MethodPost, "/counters/visits/incr?by=10", "", "")); c.Value != 11 {
+			t.Errorf("incr: got %d want 11", c.Value)
+		}
+		if c := counter(t, doRequest(mux, http.MethodPost, "/counters/visits/decr?by=4", "", "")); c.Value != 7 {
+			t.Errorf("decr: got %d want 7", c.Value)
+		}
+		if c := counter(t, doRequest(mux, http.MethodPost, "/counters/visits/expire?ttl=1h", "", "")); c.Value != 7 || c.ExpiresAt.IsZero() {
+			t.Errorf("expire: unexpected counter %+v", c)
+		}
+		if c := counter(t, doRequest(mux, http.MethodPost, "/counters/visits/expire?ttl=0", "", "")); !c.ExpiresAt.IsZero() {
+			t.Errorf("persist: unexpected counter %+v", c)
+		}
+		if c := counter(t, doRequest(mux, http.MethodPost, "/counters/visits/reset", "", "")); c.Value != 0 {
+			t.Errorf("reset: got %d want 0", c.Value)
+		}
+		if c := counter(t, doRequest(mux, http.MethodGet, "/counters/visits", "", "")); c.Value != 0 {
+			t.Errorf("after reset: got %d want 0", c.Value)
+		}
+	})
+
+	t.Run("window", func(t *testing.T) {
+		c := counter(t, doRequest(mux, http.MethodPost, "/counters/logins/incr?window=1m", "", ""))
+		if c.Value != 1 || c.ExpiresAt.IsZero() {
+			t.Fatalf("unexpected counter %+v", c)
+		}
+		if next := counter(t, doRequest(mux, http.MethodPost, "/counters/logins/incr?window=1m", "", "")); next.Value != 2 || !next.ExpiresAt.Equal(c.ExpiresAt) {
+			t.Errorf("the window moved: %+v then %+v", c, next)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		for _, path := range []string{
+			"/counters/visits/incr?by=0",
+			"/counters/visits/incr?by=x",
+			"/counters/visits/decr?by=-1",
+			"/counters/visits/incr?ttl=soon",
+			"/counters/visits/incr?ttl=1m&window=1m",
+			"/counters/visits/expire",
+			"/counters/bad%20name/incr",
+		} {
+			if rr := doRequest(mux, http.MethodPost, path, "", ""); rr.Code != http.StatusUnprocessableEntity {
+				t.Errorf("%s: got status %v want %v", path, rr.Code, http.StatusUnprocessableEntity)
+			}
+		}
+		if rr := doRequest(mux, http.MethodPost, "/counters/missing/expire?ttl=1m", "", ""); rr.Code != http.StatusNotFound {
+			t.Errorf("expire missing: got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestCounterWriteProtection(t *testing.T) {
+	t.Run("requires_scope", func(t *testing.T) {
+		app := newTestAuthApplication(t)
+		mux := app.registerRoutes()
+
+		for header, want := range map[string]int{
+			"": http.StatusUnauthorized,
+			"Bearer " + newTestToken(t, map[string]any{"sub": "user-1"}):                            http.StatusForbidden,
+			"Bearer " + newTestToken(t, map[string]any{"sub": "user-1", "scope": "counters:write"}): http.StatusOK,
+		} {
+			if rr := doRequest(mux, http.MethodPost, "/counters/visits/incr", header, ""); rr.Code != want {
+				t.Errorf("Authorization %q: got status %v want %v", header, rr.Code, want)
+			}
+		}
+	})
+
+	t.Run("rate_limited", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.config.rateLimit.enabled = true
+		app.config.rateLimit.policy = database.RateLimitPolicy{Algorithm: database.FixedWindow, Limit: 1, Window: time.Minute}
+		mux := app.registerRoutes()
+
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			if rr := doRequest(mux, http.MethodPost, "/counters/visits/reset", "", ""); rr.Code != want {
+				t.Errorf("request %d: got status %v want %v", i, rr.Code, want)
+			}
+		}
+	})
+}
@@ -1,48 +1,205 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/validator"
 )
 
 // internalServerError returns a 500 error response and logs the provided error.
 func (app *application) internalServerError(w http.ResponseWriter, r *http.Request, err error) {
 	app.logger.Error("internal error", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()), "stack", string(debug.Stack()))
 
-	writeJSONError(w, http.StatusInternalServerError, "the server encountered a problem")
+	app.errorResponse(w, r, http.StatusInternalServerError, problemInternal, "the server encountered a problem", nil)
+}
+
+// databaseErrorResponse responds to an error from the database: a 404 for
+// missing records, a 504 when Redis timed out, a 503 with a Retry-After
+// header when it is unreachable, and a 500 otherwise.
+func (app *application) databaseErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		app.notFoundResponse(w, r)
+	case errors.Is(err, database.ErrTimeout):
+		app.logger.Error("database timeout", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+		app.errorResponse(w, r, http.StatusGatewayTimeout, problemGatewayTimeout, "the database did not respond in time", nil)
+	case errors.Is(err, database.ErrUnavailable):
+		app.logger.Error("database unavailable", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+		w.Header().Set("Retry-After", "5")
+		app.errorResponse(w, r, http.StatusServiceUnavailable, problemDatabaseUnavailable, "the database is unavailable, retry later", nil)
+	default:
+		app.internalServerError(w, r, err)
+	}
 }
 
 // badRequestResponse returns a 400 error response and logs the provided error.
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logger.Warn("bad request", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
 
-	writeJSONError(w, http.StatusBadRequest, err.Error())
+	app.errorResponse(w, r, http.StatusBadRequest, problemBadRequest, err.Error(), nil)
+}
+
+// readJSONErrorResponse responds to an error from readJSON with a 413 when
+// the body exceeds the route's limit, a 415 for a non-JSON Content-Type, and
+// a 400 otherwise.
+func (app *application) readJSONErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesError):
+		app.requestTooLargeResponse(w, r, maxBytesError.Limit)
+	case errors.Is(err, errUnsupportedMediaType):
+		app.unsupportedMediaTypeResponse(w, r)
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
+
+// requestTooLargeResponse returns a 413 error response and logs the rejected request.
+func (app *application) requestTooLargeResponse(w http.ResponseWriter, r *http.Request, limit int64) {
+	app.logger.Warn("request body too large", "method", r.Method, "path", r.URL.Path, "limit", limit, string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, problemRequestTooLarge, fmt.Sprintf("body must not be larger than %d bytes", limit), nil)
+}
+
+// unsupportedMediaTypeResponse returns a 415 error response and logs the rejected request.
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warn("unsupported media type", "method", r.Method, "path", r.URL.Path, "content_type", r.Header.Get("Content-Type"), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, problemUnsupportedMediaType, errUnsupportedMediaType.Error(), nil)
+}
+
+// preconditionFailedResponse returns a 412 error response and logs the rejected request.
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warn("precondition failed", "method", r.Method, "path", r.URL.Path, "if_match", r.Header.Get("If-Match"), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	app.errorResponse(w, r, http.StatusPreconditionFailed, problemPreconditionFailed, "the resource has been modified, fetch it again and retry", nil)
+}
+
+// editConflictResponse returns a 409 error response and logs the rejected request.
+func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warn("edit conflict", "method", r.Method, "path", r.URL.Path, string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	app.errorResponse(w, r, http.StatusConflict, problemEditConflict, "the resource was modified by another request, please try again", nil)
+}
+
+// idempotencyKeyReusedResponse returns a 422 error response when an
+// Idempotency-Key is reused with a different request payload.
+func (app *application) idempotencyKeyReusedResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warn("idempotency key reused", "method", r.Method, "path", r.URL.Path, string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, problemIdempotencyKeyReused, "the Idempotency-Key was already used with a different request payload", nil)
+}
+
+// idempotencyKeyInProgressResponse returns a 409 error response when a
+// request with the same Idempotency-Key is still being handled.
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warn("idempotency key in progress", "method", r.Method, "path", r.URL.Path, string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	w.Header().Set("Retry-After", "1")
+	app.errorResponse(w, r, http.StatusConflict, problemIdempotencyKeyInProgress, "a request with this Idempotency-Key is still being processed", nil)
+}
+
+// timeoutResponse returns a 503 or 504 error response when a handler misses
+// its deadline and logs the timeout.
+func (app *application) timeoutResponse(w http.ResponseWriter, r *http.Request, timeout time.Duration, status int) {
+	app.logger.Error("request timed out", "method", r.Method, "path", r.URL.Path, "timeout", timeout.String(), "status", status, string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	pt := problemServiceUnavailable
+	if status == http.StatusGatewayTimeout {
+		pt = problemGatewayTimeout
+	}
+	app.errorResponse(w, r, status, pt, "the server timed out processing the request", nil)
+}
+
+// jobFinishedResponse returns a 409 error response when canceling a job that
+// has already finished.
+func (app *application) jobFinishedResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.Warn("job already finished", "method", r.Method, "path", r.URL.Path, string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	app.errorResponse(w, r, http.StatusConflict, problemJobFinished, "the job has already finished", nil)
+}
+
+// overloadedResponse returns a 503 error response with a Retry-After header
+// when a route sheds a request over its concurrency limit or a full job
+// queue, and logs it.
+func (app *application) overloadedResponse(w http.ResponseWriter, r *http.Request, route string) {
+	app.logger.Warn("server overloaded", "method", r.Method, "path", r.URL.Path, "route", route, string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	w.Header().Set("Retry-After", "1")
+	app.errorResponse(w, r, http.StatusServiceUnavailable, problemOverloaded, "the server is overloaded, retry later", nil)
+}
+
+// failedValidationResponse returns a 422 error response with the field errors
+// from err and logs them. Errors other than validator.Errors are reported
+// against the request body.
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warn("failed validation", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	var fields validator.Errors
+	if !errors.As(err, &fields) {
+		fields = validator.Errors{"body": err.Error()}
+	}
+
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, problemValidation, "the request contains invalid fields", fields)
 }
 
 // forbiddenResponse returns a 403 response and logs the provided error.
 func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logger.Warn("forbidden", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
 
-	writeJSONError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+	app.errorResponse(w, r, http.StatusForbidden, problemForbidden, http.StatusText(http.StatusForbidden), nil)
+}
+
+// upgradeRequiredResponse returns a 426 error response to requests for a
+// WebSocket route that are not a valid opening handshake, and logs them.
+// The handshake error sets the Upgrade or Sec-WebSocket-Version header.
+func (app *application) upgradeRequiredResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warn("upgrade required", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	app.errorResponse(w, r, http.StatusUpgradeRequired, problemUpgradeRequired, "this route requires a WebSocket connection", nil)
 }
 
 // unauthorizedResponse returns a 401 error response and logs the provided error.
 func (app *application) unauthorizedResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logger.Warn("unauthorized error", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
 
-	writeJSONError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+	app.errorResponse(w, r, http.StatusUnauthorized, problemUnauthorized, http.StatusText(http.StatusUnauthorized), nil)
 }
 
 // notFoundResponse returns a 404 error respons and logs the provided error.
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	app.logger.Warn("not found error", "method", r.Method, "path", r.URL.Path, string(correlationIDContextKey), app.getCorrelationID(r.Context()))
 
-	writeJSONError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	app.errorResponse(w, r, http.StatusNotFound, problemNotFound, http.StatusText(http.StatusNotFound), nil)
 }
 
 // apiClientErrorResponse returns the status code from the api client logs the error.
 func (app *application) apiClientErrorResponse(w http.ResponseWriter, r *http.Request, status int, err error) {
 	app.logger.Error("apiclient error", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()), "stack", string(debug.Stack()))
 
-	writeJSONError(w, status, "the server encountered a problem")
+	app.errorResponse(w, r, status, problemUpstreamFailure, "the server encountered a problem", nil)
+}
+
+// upstreamUnavailableResponse returns a 503 error response with a Retry-After
+// header while the circuit breaker to the upstream API is open, and logs it.
+func (app *application) upstreamUnavailableResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warn("upstream unavailable", "method", r.Method, "path", r.URL.Path, "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	w.Header().Set("Retry-After", "30")
+	app.errorResponse(w, r, http.StatusServiceUnavailable, problemUpstreamUnavailable, "the upstream service is unavailable", nil)
+}
+
+// rateLimitExceededResponse returns a 429 error response with a Retry-After
+// header and logs the rejected request.
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	app.logger.Warn("rate limit exceeded", "method", r.Method, "path", r.URL.Path, string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds(retryAfter), 1)))
+	app.errorResponse(w, r, http.StatusTooManyRequests, problemRateLimited, "rate limit exceeded", nil)
 }
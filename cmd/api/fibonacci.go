@@ -1,48 +1,161 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/fibonacci"
+	"gofetch.timwalker.dev/internal/validator"
 )
 
-// For measuring high CPU
-func fibonacci(n int) int {
-	if n <= 1 {
-		return n
+// fibonacciResponse is the result of a Fibonacci calculation. Result is a
+// decimal string since it overflows int64 past the 92nd number. Num, Result
+// and TimeToCalculate keep their original untagged JSON keys for existing
+// clients.
+type fibonacciResponse struct {
+	Num             int
+	Result          string
+	Algorithm       fibonacci.Algorithm `json:"algorithm"`
+	Cached          bool                `json:"cached"`
+	TimeToCalculate string
+}
+
+// fibonacciAlgorithmNames is the validation message for unknown algorithms.
+var fibonacciAlgorithmNames = func() string {
+	names := make([]string, len(fibonacci.Algorithms))
+	for i, algo := range fibonacci.Algorithms {
+		names[i] = string(algo)
 	}
+	return "must be one of " + strings.Join(names, ", ")
+}()
 
-	return fibonacci(n-1) + fibonacci(n-2)
+// parseFibonacciAlgorithm parses the algo parameter, defaulting to the naive
+// recursion that emulates a CPU-heavy route.
+func parseFibonacciAlgorithm(s string) (fibonacci.Algorithm, error) {
+	algo, err := fibonacci.ParseAlgorithm(cmp.Or(s, string(fibonacci.Naive)))
+	if err != nil {
+		return "", validator.Errors{"algo": fibonacciAlgorithmNames}
+	}
+	return algo, nil
 }
 
-// fibonacciHandler runs the Fibonacci sequence for the provided num route parameter.
-// It emulates a high CPU intensive routes.
+// fibonacciHandler calculates the Fibonacci number for the num route
+// parameter with the algorithm in the algo query parameter. The default naive
+// algorithm emulates a CPU intensive route; the others return numbers too
+// large for int64.
 func (app *application) fibonacciHandler(w http.ResponseWriter, r *http.Request) {
 	num, err := strconv.Atoi(r.PathValue("num"))
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
-	if num >= 50 {
-		app.badRequestResponse(w, r, fmt.Errorf("numbers >= 50 result in timeouts: %d", num))
+	algo, err := parseFibonacciAlgorithm(r.URL.Query().Get("algo"))
+	if err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+	if num < 0 || num > algo.Limit() {
+		app.failedValidationResponse(w, r, validator.Errors{
+			"num": fmt.Sprintf("must be between 0 and %d for the %s algorithm", algo.Limit(), algo),
+		})
 		return
 	}
 
+	response, err := app.calculateFibonacci(r.Context(), algo, num, nil)
+	if err != nil {
+		if r.Context().Err() != nil {
+			// The timeout middleware responds once the deadline passes.
+			return
+		}
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// calculateFibonacci computes the Fibonacci number for num. Results are
+// cached for every algorithm except naive, which exists to burn CPU.
+func (app *application) calculateFibonacci(ctx context.Context, algo fibonacci.Algorithm, num int, progress func(float64)) (*fibonacciResponse, error) {
 	start := time.Now()
-	result := fibonacci(num)
-	end := time.Now()
-	timeToCalculate := end.Sub(start)
+	response := &fibonacciResponse{Num: num, Algorithm: algo}
+	key := "fibonacci:" + strconv.Itoa(num)
+	useCache := algo != fibonacci.Naive
+
+	if useCache {
+		result, err := app.db.Cache().Get(ctx, key)
+		switch {
+		case err == nil:
+			response.Result = result
+			response.Cached = true
+			response.TimeToCalculate = time.Since(start).String()
+			return response, nil
+		case !errors.Is(err, database.ErrNotFound):
+			app.logger.Warn("fibonacci cache read failed", "num", num, "error", err.Error())
+		}
+	}
+
+	result, err := fibonacci.Compute(ctx, algo, num, progress)
+	if err != nil {
+		return nil, err
+	}
+	response.Result = result.String()
+	response.TimeToCalculate = time.Since(start).String()
+
+	if useCache {
+		err := app.db.Cache().Set(context.WithoutCancel(ctx), key, response.Result, app.config.fibonacciCacheTTL)
+		if err != nil {
+			app.logger.Warn("fibonacci cache write failed", "num", num, "error", err.Error())
+		}
+	}
+	return response, nil
+}
 
-	response := struct {
-		Num             int
-		Result          int
-		TimeToCalculate string
-	}{
-		Num:             num,
-		Result:          result,
-		TimeToCalculate: timeToCalculate.String(),
+// fibonacciJobInput is the request body of POST /jobs/fibonacci.
+type fibonacciJobInput struct {
+	Num  int    `json:"num" validate:"min=0"`
+	Algo string `json:"algo,omitempty"`
+}
+
+// fibonacciJobNaiveLimit is the largest num of a naive job. Jobs are not
+// bound by the request timeout, so they may run the naive recursion for
+// minutes; the other algorithms keep their limits.
+const fibonacciJobNaiveLimit = 55
+
+func (in fibonacciJobInput) Validate() error {
+	algo, err := parseFibonacciAlgorithm(in.Algo)
+	if err != nil {
+		return err
+	}
+
+	limit := algo.Limit()
+	if algo == fibonacci.Naive {
+		limit = fibonacciJobNaiveLimit
+	}
+	if in.Num > limit {
+		return validator.Errors{"num": fmt.Sprintf("must be at most %d for the %s algorithm", limit, algo)}
+	}
+	return nil
+}
+
+// fibonacciJob runs a Fibonacci calculation as an asynchronous job.
+func (app *application) fibonacciJob(ctx context.Context, raw json.RawMessage, progress func(float64)) (any, error) {
+	var input fibonacciJobInput
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return nil, err
+	}
+	algo, err := parseFibonacciAlgorithm(input.Algo)
+	if err != nil {
+		return nil, err
 	}
 
-	writeJSON(w, 200, response)
+	return app.calculateFibonacci(ctx, algo, input.Num, progress)
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFibonacciHandler(t *testing.T) {
+	app := newTestApplication(t)
+	mux := app.registerRoutes()
+
+	get := func(target string) (*httptest.ResponseRecorder, fibonacciResponse) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		var resp fibonacciResponse
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr, resp
+	}
+
+	t.Run("defaults_to_naive", func(t *testing.T) {
+		rr, resp := get("/fibonacci/20")
+		if rr.Code != http.StatusOK || resp.Result != "6765" || resp.Algorithm != "naive" || resp.Cached {
+			t.Errorf("unexpected response: %v %+v", rr.Code, resp)
+		}
+	})
+
+	t.Run("field_names", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/fibonacci/10?algo=iterative", nil))
+		var fields map[string]any
+		if err := json.NewDecoder(rr.Body).Decode(&fields); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"Num", "Result", "TimeToCalculate", "algorithm", "cached"} {
+			if _, ok := fields[name]; !ok {
+				t.Errorf("missing field %q in %v", name, fields)
+			}
+		}
+	})
+
+	t.Run("big_results_are_cached", func(t *testing.T) {
+		rr, resp := get("/fibonacci/100?algo=matrix")
+		if rr.Code != http.StatusOK || resp.Result != "354224848179261915075" || resp.Algorithm != "matrix" || resp.Cached {
+			t.Fatalf("unexpected response: %v %+v", rr.Code, resp)
+		}
+
+		rr, resp = get("/fibonacci/100?algo=iterative")
+		if rr.Code != http.StatusOK || resp.Result != "354224848179261915075" || !resp.Cached {
+			t.Errorf("expected a cached result: %v %+v", rr.Code, resp)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		for _, target := range []string{"/fibonacci/50", "/fibonacci/-1?algo=iterative", "/fibonacci/10001?algo=memo", "/fibonacci/1?algo=quantum"} {
+			if rr, _ := get(target); rr.Code != http.StatusUnprocessableEntity {
+				t.Errorf("%s: got status %v want %v", target, rr.Code, http.StatusUnprocessableEntity)
+			}
+		}
+		if rr, _ := get("/fibonacci/ten"); rr.Code != http.StatusBadRequest {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"time"
+
+	"gofetch.timwalker.dev/internal/apiclient"
+	"gofetch.timwalker.dev/internal/health"
 )
 
 func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
@@ -9,21 +15,117 @@ func (app *application) healthCheckHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	type health struct {
+	// The cached redis check keeps frequent polling off the database.
+	db, ok := app.health.Result(r.Context(), redisCheckName)
+
+	type healthResponse struct {
 		CorrelationID        string `json:"correlationId"`
 		Env                  string `json:"env"`
 		ApplicationIsHealthy bool   `json:"appIsHealthy"`
 		DatabaseIsHealthy    bool   `json:"dbIsHealthy"`
 	}
 
-	response := health{
+	response := healthResponse{
 		CorrelationID:        app.getCorrelationID(r.Context()),
 		Env:                  app.config.env,
 		ApplicationIsHealthy: true,
-		DatabaseIsHealthy:    app.db.IsHealthy(),
+		DatabaseIsHealthy:    ok && db.Status == health.Pass,
 	}
 
 	if err := writeJSON(w, http.StatusOK, response); err != nil {
 		writeJSONError(w, http.StatusInternalServerError, err.Error())
 	}
 }
+
+// Health check settings. Redis is critical since most routes need it; the
+// upstream API only backs /apiclient routes.
+const (
+	redisCheckName       = "redis"
+	redisCheckTimeout    = time.Second
+	redisCheckCacheTTL   = 2 * time.Second
+	upstreamCheckTimeout = 2 * time.Second
+	upstreamCheckTTL     = 10 * time.Second
+)
+
+// newHealthRegistry returns the dependency checks behind /readyz and
+// /startupz.
+func (app *application) newHealthRegistry() *health.Registry {
+	reg := health.NewRegistry()
+	reg.Register(health.Check{
+		Name:     redisCheckName,
+		Check:    app.db.Ping,
+		Timeout:  redisCheckTimeout,
+		Critical: true,
+		CacheTTL: redisCheckCacheTTL,
+	})
+
+	if app.apiClient != nil {
+		reg.Register(health.Check{
+			Name:     "upstream",
+			Check:    app.apiClient.Ping,
+			Timeout:  upstreamCheckTimeout,
+			CacheTTL: upstreamCheckTTL,
+		})
+		reg.Register(health.Check{
+			Name: "upstream-circuit",
+			Check: func(ctx context.Context) error {
+				if state := app.apiClient.Breaker().State(); state != apiclient.BreakerClosed {
+					return fmt.Errorf("circuit breaker is %s", state)
+				}
+				return nil
+			},
+		})
+	}
+	return reg
+}
+
+// livezHandler reports that the process is running. It checks no
+// dependencies, so a failing dependency does not get the process restarted.
+func (app *application) livezHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeHealthReport(w, r, health.Report{Status: health.Pass})
+}
+
+// readyzHandler reports whether the server can handle traffic: it responds
+// 503 when a critical dependency check fails.
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeHealthReport(w, r, app.health.Run(r.Context()))
+}
+
+// startupzHandler reports whether the server has finished starting: it is
+// listening and the critical dependency checks have passed once. After that
+// it always passes, leaving dependency failures to /readyz.
+func (app *application) startupzHandler(w http.ResponseWriter, r *http.Request) {
+	if app.startupComplete.Load() {
+		app.writeHealthReport(w, r, health.Report{Status: health.Pass})
+		return
+	}
+
+	report := app.health.Run(r.Context())
+	if !app.listening.Load() {
+		report.Status = health.Fail
+		report.Checks = append(report.Checks, health.Result{
+			Name: "listener", Status: health.Fail, Critical: true, Error: "the server is not listening yet",
+		})
+	}
+	if report.Status != health.Fail {
+		app.startupComplete.Store(true)
+	}
+	app.writeHealthReport(w, r, report)
+}
+
+// writeHealthReport writes report with a 503 when it failed. Per-check
+// results are included when the verbose query parameter is present.
+func (app *application) writeHealthReport(w http.ResponseWriter, r *http.Request, report health.Report) {
+	status := http.StatusOK
+	if report.Status == health.Fail {
+		status = http.StatusServiceUnavailable
+	}
+	if !r.URL.Query().Has("verbose") {
+		report.Checks = nil
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := writeJSON(w, status, report); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
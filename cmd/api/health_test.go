@@ -1,33 +1,102 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database/memory"
+	"gofetch.timwalker.dev/internal/health"
+	"gofetch.timwalker.dev/internal/jobs"
+	"gofetch.timwalker.dev/internal/metrics"
 )
 
-type MockDB struct{}
+// MockDB is the in-memory database with failures injected by setting
+// pingErr, which Ping and IncrementCounter return.
+type MockDB struct {
+	*memory.Service
+	pingErr error
+}
 
-func (mdb *MockDB) IsHealthy() bool {
-	return true
+func (mdb *MockDB) Ping(ctx context.Context) error {
+	return mdb.pingErr
 }
 
-func (mdb *MockDB) IncrementCounter() int {
-	return 42
+func (mdb *MockDB) IncrementCounter(ctx context.Context) (int, error) {
+	if mdb.pingErr != nil {
+		return 0, mdb.pingErr
+	}
+	return mdb.Service.IncrementCounter(ctx)
 }
 
 // newTestApplication helper returns an instance of the
 // application struct containing mocked dependencies.
-func newTestApplication() *application {
-	return &application{
-		logger: slog.New(slog.DiscardHandler),
-		db:     &MockDB{},
+// newTestApplication returns an application backed by the in-memory
+// database. The counter broker, WebSocket hub and job pool it starts are
+// stopped when the test finishes.
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := &application{
+		config: config{
+			idempotencyTTL: time.Hour,
+			timeouts:       timeoutConfig{request: 5 * time.Second, fibonacci: 5 * time.Second},
+			fibonacciConcurrency: concurrencyConfig{
+				limit: 4, maxLimit: 4, queue: 4, queueTimeout: time.Second,
+			},
+			counterStream: streamConfig{maxClients: 4, heartbeat: time.Minute, writeTimeout: time.Second},
+			ws:            streamConfig{maxClients: 4, heartbeat: time.Minute, writeTimeout: time.Second},
+		},
+		logger:   slog.New(slog.DiscardHandler),
+		logLevel: new(slog.LevelVar),
+		metrics:  metrics.NewRegistry(),
+		db:       &MockDB{Service: memory.New()},
 	}
+
+	app.health = app.newHealthRegistry()
+	app.counterBroker = newCounterBroker(app.db.PubSub(), app.logger, app.config.counterStream.maxClients, app.metrics)
+	go app.counterBroker.run()
+	app.wsHub = newWSHub(app.db.PubSub(), app.logger, app.config.ws, app.wsTopicAllowed, app.metrics)
+	app.jobs = jobs.New(app.db.Jobs(), app.logger, jobs.Config{Workers: 2, Queue: 2, PollInterval: 10 * time.Millisecond, PubSub: app.db.PubSub()})
+	app.jobs.Register("fibonacci", app.fibonacciJob)
+	app.jobs.Start()
+
+	// Tests may swap these fields, so stop the ones started here.
+	broker, hub, pool := app.counterBroker, app.wsHub, app.jobs
+	t.Cleanup(func() {
+		broker.close()
+		hub.close()
+		if err := pool.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	return app
+}
+
+// doRequest serves a request to h and returns the recorded response. The
+// Authorization header is set to authorization unless it is empty, and
+// header holds further header names and values in pairs.
+func doRequest(h http.Handler, method, target, authorization, body string, header ...string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	h.ServeHTTP(rr, r)
+	return rr
 }
 
 func TestHealthCheck(t *testing.T) {
-	app := newTestApplication()
+	app := newTestApplication(t)
 	rr := httptest.NewRecorder()
 
 	r, err := http.NewRequest(http.MethodGet, "/health", nil)
@@ -47,4 +116,79 @@ func TestHealthCheck(t *testing.T) {
 	if expectedContentType := "application/json"; contentType != expectedContentType {
 		t.Errorf("Handler return wrong content type: got %v want %v", contentType, expectedContentType)
 	}
+
+	dbIsHealthy := func(app *application) bool {
+		var body struct {
+			DatabaseIsHealthy bool `json:"dbIsHealthy"`
+		}
+		rr := doRequest(app.registerRoutes(), http.MethodGet, "/health", "", "")
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body.DatabaseIsHealthy
+	}
+
+	// The database status comes from the cached redis check.
+	if !dbIsHealthy(app) {
+		t.Error("expected a healthy database")
+	}
+	app.db.(*MockDB).pingErr = errors.New("connection refused")
+	if !dbIsHealthy(app) {
+		t.Error("expected the cached database status")
+	}
+	down := newTestApplication(t)
+	down.db.(*MockDB).pingErr = errors.New("connection refused")
+	if dbIsHealthy(down) {
+		t.Error("expected an unhealthy database")
+	}
+}
+
+func TestProbes(t *testing.T) {
+	app := newTestApplication(t)
+	mux := app.registerRoutes()
+
+	get := func(target string) (*httptest.ResponseRecorder, health.Report) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		var report health.Report
+		if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return rr, report
+	}
+
+	if rr, report := get("/livez"); rr.Code != http.StatusOK || report.Status != health.Pass {
+		t.Errorf("livez: unexpected response %v %+v", rr.Code, report)
+	}
+
+	if rr, report := get("/startupz?verbose"); rr.Code != http.StatusServiceUnavailable || len(report.Checks) != 2 {
+		t.Errorf("startupz before listening: unexpected response %v %+v", rr.Code, report)
+	}
+	app.listening.Store(true)
+	if rr, _ := get("/startupz"); rr.Code != http.StatusOK {
+		t.Errorf("startupz: got status %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr, report := get("/readyz")
+	if rr.Code != http.StatusOK || report.Status != health.Pass || report.Checks != nil {
+		t.Errorf("readyz: unexpected response %v %+v", rr.Code, report)
+	}
+
+	// Redis going down fails readiness, but startup stays complete. A new
+	// registry skips the cached result.
+	app.db.(*MockDB).pingErr = errors.New("connection refused")
+	app.health = app.newHealthRegistry()
+	app.counterBroker = newCounterBroker(app.db.PubSub(), app.logger, app.config.counterStream.maxClients, app.metrics)
+	go app.counterBroker.run()
+
+	rr, report = get("/readyz?verbose")
+	if rr.Code != http.StatusServiceUnavailable || report.Status != health.Fail {
+		t.Fatalf("readyz: unexpected response %v %+v", rr.Code, report)
+	}
+	if len(report.Checks) != 1 || report.Checks[0].Name != "redis" || report.Checks[0].Error != "connection refused" {
+		t.Errorf("readyz: unexpected checks %+v", report.Checks)
+	}
+	if rr, _ := get("/startupz"); rr.Code != http.StatusOK {
+		t.Errorf("startupz: got status %v want %v", rr.Code, http.StatusOK)
+	}
 }
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+// idempotencyKeyHeader is the request header from the IETF
+// idempotency-key-header draft.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyLockTTL bounds how long a request holds its key. A key whose
+// request crashed can be retried once the lock expires.
+const idempotencyLockTTL = time.Minute
+
+// idempotentReplayHeaders are the response headers stored and replayed with
+// an idempotent response. Other headers, such as the correlation ID, belong
+// to the request that receives them.
+var idempotentReplayHeaders = []string{"Content-Type", "Location", "ETag", "Last-Modified"}
+
+// idempotent middleware makes retries of a request carrying an
+// Idempotency-Key return the response of the first request instead of
+// running the handler again. Keys are scoped to the authenticated user, or
+// the client IP for anonymous requests.
+//
+// A request for a key still being handled receives a 409, and reusing a key
+// with a different payload a 422. Server errors release the key so the
+// request can be retried. Requests without the header are not affected.
+func (app *application) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+		if idempotencyKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(idempotencyKey) > 255 {
+			app.badRequestResponse(w, r, errors.New("the Idempotency-Key header must be at most 255 characters"))
+			return
+		}
+
+		maxBytes := defaultMaxBodyBytes
+		if n, ok := r.Context().Value(maxBodyBytesContextKey).(int64); ok {
+			maxBytes = n
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+		if err != nil {
+			app.readJSONErrorResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := app.idempotencyScope(r) + ":" + idempotencyKey
+		fingerprint := requestFingerprint(r, body)
+		store := app.db.Idempotency()
+
+		token, existing, err := store.Lock(r.Context(), key, fingerprint, idempotencyLockTTL)
+		if err != nil {
+			app.databaseErrorResponse(w, r, err)
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				app.idempotencyKeyReusedResponse(w, r)
+			case !existing.Completed:
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				for _, name := range idempotentReplayHeaders {
+					if v, ok := existing.Header[name]; ok {
+						w.Header()[name] = v
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		// The key is released if the handler panics or fails so a retry can
+		// run the request again. Releasing a completed key is a no-op.
+		defer func() {
+			if err := store.Release(context.WithoutCancel(r.Context()), key, token); err != nil {
+				app.logger.Error("failed to release idempotency key", "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+			}
+		}()
+
+		buf := &bufferedResponseWriter{header: w.Header(), status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+
+		if buf.status < http.StatusInternalServerError {
+			record := &database.IdempotencyRecord{
+				Fingerprint: fingerprint,
+				Completed:   true,
+				Status:      buf.status,
+				Header:      make(map[string][]string),
+				Body:        buf.body.Bytes(),
+			}
+			for _, name := range idempotentReplayHeaders {
+				if v, ok := w.Header()[name]; ok {
+					record.Header[name] = v
+				}
+			}
+
+			err := store.Complete(context.WithoutCancel(r.Context()), key, token, record, app.config.idempotencyTTL)
+			if err != nil {
+				app.logger.Error("failed to store idempotent response", "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+			}
+		}
+
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	})
+}
+
+// idempotencyScope identifies the client an Idempotency-Key belongs to.
+func (app *application) idempotencyScope(r *http.Request) string {
+	if claims := app.getClaims(r.Context()); claims != nil && claims.Subject != "" {
+		return "user:" + claims.Subject
+	}
+	return "ip:" + app.clientIP(r)
+}
+
+// requestFingerprint hashes the parts of a request that must be identical
+// when an Idempotency-Key is reused.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	h.Write([]byte(strconv.Itoa(len(body)) + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
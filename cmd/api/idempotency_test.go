@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	app := newTestAuthApplication(t)
+	mux := app.registerRoutes()
+	token := "Bearer " + newTestToken(t, map[string]any{"sub": "user-1", "scope": "albums:write"})
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(body))
+		r.Header.Set("Authorization", token)
+		if key != "" {
+			r.Header.Set(idempotencyKeyHeader, key)
+		}
+		mux.ServeHTTP(rr, r)
+		return rr
+	}
+
+	first := post("key-1", `{"userId":1,"title":"first"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("got status %v want %v", first.Code, http.StatusCreated)
+	}
+
+	t.Run("replays_response", func(t *testing.T) {
+		rr := post("key-1", `{"userId":1,"title":"first"}`)
+		if rr.Code != http.StatusCreated || rr.Body.String() != first.Body.String() {
+			t.Errorf("got %v %s want %v %s", rr.Code, rr.Body, first.Code, first.Body)
+		}
+		if rr.Header().Get("Location") != "/albums/1" || rr.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("unexpected headers: %v", rr.Header())
+		}
+
+		albums, _, _ := app.db.Albums().List(context.Background(), database.AlbumFilter{})
+		if len(albums) != 1 {
+			t.Errorf("expected 1 album, got %d", len(albums))
+		}
+	})
+
+	t.Run("rejects_different_payload", func(t *testing.T) {
+		if rr := post("key-1", `{"userId":1,"title":"other"}`); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+	})
+
+	t.Run("conflict_while_in_progress", func(t *testing.T) {
+		body := `{"userId":1,"title":"slow"}`
+		r := httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(body))
+		_, _, err := app.db.Idempotency().Lock(context.Background(), "user:user-1:key-2", requestFingerprint(r, []byte(body)), time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := post("key-2", body)
+		if rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") == "" {
+			t.Errorf("got status %v want %v with Retry-After", rr.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("without_key_is_not_replayed", func(t *testing.T) {
+		post("", `{"userId":1,"title":"no key"}`)
+		if rr := post("", `{"userId":1,"title":"no key"}`); rr.Header().Get("Location") != "/albums/3" {
+			t.Errorf("expected a new album, got Location %q", rr.Header().Get("Location"))
+		}
+	})
+}
+
+func TestIdempotencyKeyReleasedOnServerError(t *testing.T) {
+	app := newTestApplication(t)
+	calls := 0
+	handler := app.idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusCreated, http.StatusCreated} {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(`{}`))
+		r.Header.Set(idempotencyKeyHeader, "key")
+		handler.ServeHTTP(rr, r)
+
+		if rr.Code != want {
+			t.Errorf("got status %v want %v", rr.Code, want)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
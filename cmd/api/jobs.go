@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"gofetch.timwalker.dev/internal/auth"
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/jobs"
+	"gofetch.timwalker.dev/internal/validator"
+)
+
+// createFibonacciJobHandler queues a fibonacci calculation and returns the
+// job with a 202 and its Location.
+func (app *application) createFibonacciJobHandler(w http.ResponseWriter, r *http.Request) {
+	// Jobs are owned by the subject of the token. Only requests without
+	// credentials, let through when authentication is not required, create
+	// anonymous jobs.
+	owner := ""
+	if claims := app.getClaims(r.Context()); claims != nil {
+		if claims.Subject == "" {
+			app.unauthorizedResponse(w, r, errors.New("token has no subject"))
+			return
+		}
+		owner = claims.Subject
+	}
+
+	var input fibonacciJobInput
+	if err := readJSON(w, r, &input); err != nil {
+		app.readJSONErrorResponse(w, r, err)
+		return
+	}
+	if err := validator.Struct(input); err != nil {
+		app.failedValidationResponse(w, r, err)
+		return
+	}
+
+	job, err := app.jobs.Submit(r.Context(), "fibonacci", owner, input)
+	if err != nil {
+		if errors.Is(err, jobs.ErrQueueFull) || errors.Is(err, jobs.ErrClosed) {
+			app.overloadedResponse(w, r, "jobs")
+			return
+		}
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	if err := writeJSONData(w, http.StatusAccepted, job); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// canAccessJob reports whether a client with claims may read or cancel job.
+// Jobs are open to their owner and admins. Anonymous jobs are open to anyone
+// with their ID, but only while authentication is not required.
+func (app *application) canAccessJob(claims *auth.Claims, job *database.Job) bool {
+	if claims != nil && claims.HasRole("admin") {
+		return true
+	}
+	if job.Owner == "" {
+		return !app.config.auth.required
+	}
+	return claims != nil && claims.Subject == job.Owner
+}
+
+// getAccessibleJob returns the job of the request, responding with a 404 if
+// the client may not access it.
+func (app *application) getAccessibleJob(w http.ResponseWriter, r *http.Request) (*database.Job, bool) {
+	job, err := app.db.Jobs().Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		app.databaseErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !app.canAccessJob(app.getClaims(r.Context()), job) {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+	return job, true
+}
+
+// getJobHandler reports the status, progress and outcome of a job. Clients
+// polling an unfinished job are asked to wait via Retry-After.
+func (app *application) getJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.getAccessibleJob(w, r)
+	if !ok {
+		return
+	}
+
+	if !job.Status.Finished() {
+		w.Header().Set("Retry-After", "1")
+	}
+	if err := writeJSONData(w, http.StatusOK, job); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// cancelJobHandler cancels a queued or running job.
+func (app *application) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := app.getAccessibleJob(w, r)
+	if !ok {
+		return
+	}
+	job, err := app.jobs.Cancel(r.Context(), job.ID)
+	if err != nil {
+		if errors.Is(err, database.ErrJobFinished) {
+			app.jobFinishedResponse(w, r)
+			return
+		}
+		app.databaseErrorResponse(w, r, err)
+		return
+	}
+
+	if err := writeJSONData(w, http.StatusOK, job); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// newCleanupElector returns the election of the replica that cleans up
+// finished jobs, so replicas do not repeat each other's cleanup.
+func (app *application) newCleanupElector() *database.Elector {
+	const name = "jobs-cleanup"
+	elector := database.NewElector(app.db.Locks(), database.ElectionConfig{
+		Name: name,
+		TTL:  app.config.leaderElectionTTL,
+		OnElected: func(ctx context.Context, token int64) {
+			app.logger.Info("elected leader", "election", name, "token", token)
+			app.cleanupJobs(ctx, app.config.jobs)
+		},
+		OnDemoted: func(err error) {
+			if err != nil {
+				app.logger.Warn("lost leadership", "election", name, "error", err.Error())
+				return
+			}
+			app.logger.Info("resigned leadership", "election", name)
+		},
+	})
+	app.metrics.Gauge("gofetch_leader", "Whether this replica leads an election.", "election").
+		Func(func() float64 {
+			if elector.IsLeader() {
+				return 1
+			}
+			return 0
+		}, name)
+	return elector
+}
+
+// cleanupJobs deletes jobs finished more than cfg.ttl ago, and fails jobs
+// left running by a replica that crashed, every cfg.cleanupInterval until ctx
+// is done.
+func (app *application) cleanupJobs(ctx context.Context, cfg jobsConfig) {
+	ticker := time.NewTicker(cfg.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if cfg.staleAfter > 0 {
+				failed, err := app.jobs.FailStale(ctx, cfg.staleAfter)
+				if err != nil {
+					app.logger.Warn("failed to fail stale jobs", "error", err.Error())
+				}
+				if failed > 0 {
+					app.logger.Warn("failed jobs without a heartbeat", "failed", failed, "stale_after", cfg.staleAfter.String())
+				}
+			}
+
+			deleted, err := app.jobs.Cleanup(ctx, cfg.ttl)
+			if err != nil {
+				app.logger.Warn("failed to clean up jobs", "error", err.Error())
+				continue
+			}
+			if deleted > 0 {
+				app.logger.Info("cleaned up finished jobs", "deleted", deleted)
+			}
+		}
+	}
+}
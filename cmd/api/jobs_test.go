@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+func TestJobs(t *testing.T) {
+	app := newTestApplication(t)
+	mux := app.registerRoutes()
+
+	job := func(rr *httptest.ResponseRecorder) database.Job {
+		t.Helper()
+		var resp struct{ Data database.Job }
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Data
+	}
+
+	t.Run("runs_to_completion", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodPost, "/jobs/fibonacci", "", `{"num":20}`)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("got status %v want %v: %s", rr.Code, http.StatusAccepted, rr.Body)
+		}
+		location := rr.Header().Get("Location")
+		if created := job(rr); location != "/jobs/"+created.ID || created.Status != database.JobQueued {
+			t.Fatalf("unexpected job %+v at %q", created, location)
+		}
+
+		var got database.Job
+		waitFor(t, func() bool {
+			got = job(doRequest(mux, http.MethodGet, location, "", ""))
+			return got.Status.Finished()
+		})
+		if got.Status != database.JobSucceeded || got.Progress != 1 || got.FinishedAt == nil {
+			t.Fatalf("unexpected job: %+v", got)
+		}
+
+		var result fibonacciResponse
+		if err := json.Unmarshal(got.Result, &result); err != nil || result.Result != "6765" {
+			t.Errorf("unexpected result %s: %v", got.Result, err)
+		}
+
+		if rr := doRequest(mux, http.MethodDelete, location, "", ""); rr.Code != http.StatusConflict {
+			t.Errorf("canceling a finished job: got status %v want %v", rr.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("cancels", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodPost, "/jobs/fibonacci", "", `{"num":55}`)
+		location := rr.Header().Get("Location")
+		waitFor(t, func() bool {
+			return job(doRequest(mux, http.MethodGet, location, "", "")).Status == database.JobRunning
+		})
+
+		rr = doRequest(mux, http.MethodDelete, location, "", "")
+		if rr.Code != http.StatusOK || job(rr).Status != database.JobCanceled {
+			t.Fatalf("unexpected cancel response: %v %s", rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("validates", func(t *testing.T) {
+		if rr := doRequest(mux, http.MethodPost, "/jobs/fibonacci", "", `{"num":56}`); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+		if rr := doRequest(mux, http.MethodGet, "/jobs/missing", "", ""); rr.Code != http.StatusNotFound {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("cleanup_on_one_replica", func(t *testing.T) {
+		rr := doRequest(mux, http.MethodPost, "/jobs/fibonacci", "", `{"num":10}`)
+		location := rr.Header().Get("Location")
+		waitFor(t, func() bool {
+			return job(doRequest(mux, http.MethodGet, location, "", "")).Status.Finished()
+		})
+
+		// A job left running by a replica that crashed is failed, then
+		// deleted.
+		abandoned := &database.Job{ID: "abandoned", Type: "fibonacci", Input: json.RawMessage(`{"num":10}`)}
+		if err := app.db.Jobs().Create(context.Background(), abandoned); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := app.db.Jobs().Start(context.Background(), abandoned.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		// Two replicas share the database, and only one leads the cleanup.
+		app.config.jobs = jobsConfig{cleanupInterval: 10 * time.Millisecond, staleAfter: 10 * time.Millisecond}
+		app.config.leaderElectionTTL = time.Second
+		other := newTestApplication(t)
+		other.db, other.jobs, other.config = app.db, app.jobs, app.config
+		electors := []*database.Elector{app.newCleanupElector(), other.newCleanupElector()}
+		for _, e := range electors {
+			e.Start()
+		}
+		waitFor(t, func() bool { return electors[0].IsLeader() || electors[1].IsLeader() })
+		if electors[0].IsLeader() && electors[1].IsLeader() {
+			t.Error("both replicas lead the cleanup")
+		}
+
+		waitFor(t, func() bool {
+			return doRequest(mux, http.MethodGet, location, "", "").Code == http.StatusNotFound
+		})
+		waitFor(t, func() bool {
+			return doRequest(mux, http.MethodGet, "/jobs/abandoned", "", "").Code == http.StatusNotFound
+		})
+		for _, e := range electors {
+			if err := e.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if e.IsLeader() {
+				t.Error("replica leads the cleanup after Shutdown")
+			}
+		}
+	})
+}
+
+func TestJobOwnership(t *testing.T) {
+	app := newTestAuthApplication(t)
+	mux := app.registerRoutes()
+	owner := "Bearer " + newTestToken(t, map[string]any{"sub": "user-1", "scope": "jobs:read jobs:write"})
+	other := "Bearer " + newTestToken(t, map[string]any{"sub": "user-2", "scope": "jobs:read jobs:write"})
+	admin := "Bearer " + newTestToken(t, map[string]any{"sub": "ops", "roles": []string{"admin"}, "scope": "jobs:read"})
+
+	rr := doRequest(mux, http.MethodPost, "/jobs/fibonacci", owner, `{"num":10}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got status %v want %v: %s", rr.Code, http.StatusAccepted, rr.Body)
+	}
+	location := rr.Header().Get("Location")
+	stored, err := app.db.Jobs().Get(context.Background(), strings.TrimPrefix(location, "/jobs/"))
+	if err != nil || stored.Owner != "user-1" {
+		t.Fatalf("stored job %+v: %v", stored, err)
+	}
+
+	for _, tt := range []struct {
+		name          string
+		method, token string
+		expected      int
+	}{
+		{"other_get", http.MethodGet, other, http.StatusNotFound},
+		{"other_cancel", http.MethodDelete, other, http.StatusNotFound},
+		{"admin_get", http.MethodGet, admin, http.StatusOK},
+		{"owner_get", http.MethodGet, owner, http.StatusOK},
+	} {
+		if rr := doRequest(mux, tt.method, location, tt.token, ""); rr.Code != tt.expected {
+			t.Errorf("%s: got status %v want %v", tt.name, rr.Code, tt.expected)
+		}
+	}
+
+	noSubject := "Bearer " + newTestToken(t, map[string]any{"scope": "jobs:read jobs:write"})
+	if rr := doRequest(mux, http.MethodPost, "/jobs/fibonacci", noSubject, `{"num":10}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("no_subject_create: got status %v want %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	anonymous := &database.Job{ID: "anonymous", Type: "fibonacci", Input: json.RawMessage(`{"num":10}`)}
+	if err := app.db.Jobs().Create(context.Background(), anonymous); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{noSubject, other} {
+		if rr := doRequest(mux, http.MethodGet, "/jobs/anonymous", token, ""); rr.Code != http.StatusNotFound {
+			t.Errorf("anonymous job with authentication required: got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+	}
+}
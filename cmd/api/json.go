@@ -1,19 +1,137 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
 )
 
-// readJSON reads and decodes request body json
+// defaultMaxBodyBytes is the request body limit for readJSON on routes that
+// do not set their own with the maxBodyBytes middleware.
+const defaultMaxBodyBytes int64 = 1 << 20 // 1mb
+
+// maxBodyBytesContextKey is the key used to store a route's body limit in the context.
+const maxBodyBytesContextKey contextKey = "maxBodyBytes"
+
+// errUnsupportedMediaType is returned by readJSON for a non-JSON Content-Type.
+var errUnsupportedMediaType = errors.New("body must be sent with Content-Type application/json")
+
+// readJSON reads and decodes request body json into data. The body must be a
+// single JSON value with no fields that data does not declare. A missing
+// Content-Type is accepted; any other than application/json or a +json type
+// returns errUnsupportedMediaType. A body over the route's limit returns an
+// *http.MaxBytesError. Other errors have messages suitable for clients.
 func readJSON(w http.ResponseWriter, r *http.Request, data any) error {
-	maxBytes := 1_048_578 // 1mb
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+			return errUnsupportedMediaType
+		}
+	}
+
+	if r.Body == nil {
+		return errors.New("body must not be empty")
+	}
+
+	maxBytes := defaultMaxBodyBytes
+	if n, ok := r.Context().Value(maxBodyBytesContextKey).(int64); ok {
+		maxBytes = n
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	// The body is buffered so syntax error offsets can be reported as a line
+	// and column.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return errors.New("body must not be empty")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(data); err != nil {
+		return jsonDecodeError(body, err)
+	}
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return errors.New("body must only contain a single JSON value")
+	}
+	return nil
+}
+
+// jsonDecodeError rewrites an encoding/json error as a client-friendly error.
+func jsonDecodeError(body []byte, err error) error {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		line, column := jsonPosition(body, syntaxError.Offset)
+		return fmt.Errorf("body contains badly-formed JSON at line %d, column %d", line, column)
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		line, column := jsonPosition(body, int64(len(bytes.TrimRightFunc(body, unicode.IsSpace))))
+		return fmt.Errorf("body contains incomplete JSON ending at line %d, column %d", line, column)
 
-	decoder := json.NewDecoder(r.Body)
-	// decoder.DisallowUnknownFields()
+	case errors.As(err, &typeError):
+		if typeError.Field != "" {
+			return fmt.Errorf("body contains an invalid value for field %q: must be %s", typeError.Field, jsonTypeName(typeError.Type))
+		}
+		return fmt.Errorf("body must be %s", jsonTypeName(typeError.Type))
 
-	return decoder.Decode(data)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return fmt.Errorf("body contains unknown field %s", field)
+
+	case errors.As(err, &invalidUnmarshalError):
+		// A non-pointer destination is a programming error.
+		panic(err)
+	}
+
+	return err
+}
+
+// jsonPosition converts an encoding/json error offset, which counts the
+// bytes read up to and including the offending byte, into a 1-based line and
+// column.
+func jsonPosition(body []byte, offset int64) (line, column int) {
+	prefix := body[:min(max(offset-1, 0), int64(len(body)))]
+	line = bytes.Count(prefix, []byte("\n")) + 1
+	column = len(prefix) - bytes.LastIndexByte(prefix, '\n')
+	return line, column
+}
+
+// jsonTypeName describes the JSON type expected for a Go type.
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
 }
 
 // writeJSON encodes json and writes to the response
@@ -25,11 +143,18 @@ func writeJSON(w http.ResponseWriter, status int, data any) error {
 
 // writeJSON encodes json in a "data" envelope and writes to the response
 func writeJSONData(w http.ResponseWriter, status int, data any) error {
+	return writeJSONDataMeta(w, status, data, nil)
+}
+
+// writeJSONDataMeta encodes json in a "data" envelope with a "meta" object,
+// such as pagination details, and writes to the response
+func writeJSONDataMeta(w http.ResponseWriter, status int, data, meta any) error {
 	type envelope struct {
 		Data any `json:"data"`
+		Meta any `json:"meta,omitempty"`
 	}
 
-	return writeJSON(w, status, &envelope{Data: data})
+	return writeJSON(w, status, &envelope{Data: data, Meta: meta})
 }
 
 // writeJSONError encodes json in a "error" envelope and writes to the response
@@ -40,3 +165,21 @@ func writeJSONError(w http.ResponseWriter, status int, message string) error {
 
 	return writeJSON(w, status, &envelope{Error: message})
 }
+
+// writeJSONFieldErrors encodes json in a "error" envelope with a map of
+// per-field messages and writes to the response
+func writeJSONFieldErrors(w http.ResponseWriter, status int, message string, fields map[string]string) error {
+	type envelope struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+
+	return writeJSON(w, status, &envelope{Error: message, Fields: fields})
+}
+
+// writeProblemJSON encodes an RFC 9457 problem and writes to the response
+func writeProblemJSON(w http.ResponseWriter, p problem) error {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadJSON(t *testing.T) {
+	type input struct {
+		UserID int      `json:"userId"`
+		Title  string   `json:"title"`
+		Tags   []string `json:"tags"`
+	}
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		expect      string
+	}{
+		{"valid", `{"userId":1,"title":"a"}`, "application/json", ""},
+		{"missing_content_type", `{"userId":1}`, "", ""},
+		{"json_suffix_content_type", `{"userId":1}`, "application/merge-patch+json", ""},
+		{"empty", "  \n", "", "body must not be empty"},
+		{"unknown_field", `{"userId":1,"year":1999}`, "", `body contains unknown field "year"`},
+		{"trailing_data", `{"userId":1} {"userId":2}`, "", "body must only contain a single JSON value"},
+		{"syntax_error", "{\n  \"userId\": 1,\n  title: \"a\"\n}", "", "body contains badly-formed JSON at line 3, column 3"},
+		{"incomplete", `{"userId":1`, "", "body contains incomplete JSON ending at line 1, column 11"},
+		{"wrong_type", `{"userId":"one"}`, "", `body contains an invalid value for field "userId": must be an integer`},
+		{"not_an_object", `[1]`, "", "body must be an object"},
+		{"unsupported_media_type", `{}`, "text/plain", errUnsupportedMediaType.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			var v input
+			err := readJSON(httptest.NewRecorder(), r, &v)
+			if tt.expect == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.expect {
+				t.Errorf("got %v want %q", err, tt.expect)
+			}
+		})
+	}
+}
+
+func TestReadJSONMaxBytes(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"title":"`+strings.Repeat("a", 32)+`"}`))
+	r = r.WithContext(context.WithValue(r.Context(), maxBodyBytesContextKey, int64(16)))
+
+	var v struct {
+		Title string `json:"title"`
+	}
+	err := readJSON(httptest.NewRecorder(), r, &v)
+
+	var maxBytesError *http.MaxBytesError
+	if !errors.As(err, &maxBytesError) || maxBytesError.Limit != 16 {
+		t.Fatalf("expected a MaxBytesError with limit 16, got %v", err)
+	}
+}
+
+func TestReadJSONErrorResponse(t *testing.T) {
+	app := newTestAuthApplication(t)
+	mux := app.registerRoutes()
+	token := "Bearer " + newTestToken(t, map[string]any{"sub": "user-1", "scope": "albums:write"})
+
+	tests := []struct {
+		name        string
+		body        string
+		contentType string
+		status      int
+	}{
+		{"too_large", `{"userId":1,"title":"` + strings.Repeat("a", 16<<10) + `"}`, "application/json", http.StatusRequestEntityTooLarge},
+		{"unsupported_media_type", `userId=1`, "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"unknown_field", `{"userId":1,"title":"a","year":1}`, "application/json", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", token)
+			r.Header.Set("Content-Type", tt.contentType)
+			mux.ServeHTTP(rr, r)
+
+			if rr.Code != tt.status {
+				t.Errorf("got status %v want %v: %s", rr.Code, tt.status, rr.Body)
+			}
+		})
+	}
+}
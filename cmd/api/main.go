@@ -2,37 +2,199 @@ package main
 
 import (
 	"log/slog"
+	"net"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 
 	"gofetch.timwalker.dev/internal/apiclient"
 	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/database/memory"
 	"gofetch.timwalker.dev/internal/env"
+	"gofetch.timwalker.dev/internal/jobs"
+	"gofetch.timwalker.dev/internal/metrics"
 
 	_ "github.com/joho/godotenv/autoload"
 )
 
 func main() {
 	cfg := config{
-		port: env.GetInt("PORT", 4000),
-		env:  env.GetString("ENV", "local"),
+		port:           env.GetInt("PORT", 4000),
+		env:            env.GetString("ENV", "local"),
+		problemJSON:    env.GetBool("PROBLEM_JSON", false),
+		idempotencyTTL: env.GetDuration("IDEMPOTENCY_TTL", 24*time.Hour),
+		timeouts: timeoutConfig{
+			request:   env.GetDuration("REQUEST_TIMEOUT", 5*time.Second),
+			fibonacci: env.GetDuration("FIBONACCI_TIMEOUT", 2*time.Second),
+		},
+		fibonacciConcurrency: concurrencyConfig{
+			limit:         env.GetInt("FIBONACCI_CONCURRENCY", runtime.GOMAXPROCS(0)),
+			minLimit:      env.GetInt("FIBONACCI_CONCURRENCY_MIN", 1),
+			maxLimit:      env.GetInt("FIBONACCI_CONCURRENCY_MAX", 4*runtime.GOMAXPROCS(0)),
+			queue:         env.GetInt("FIBONACCI_QUEUE", runtime.GOMAXPROCS(0)),
+			queueTimeout:  env.GetDuration("FIBONACCI_QUEUE_TIMEOUT", 100*time.Millisecond),
+			adaptive:      env.GetBool("FIBONACCI_CONCURRENCY_ADAPTIVE", true),
+			targetLatency: env.GetDuration("FIBONACCI_TARGET_LATENCY", 500*time.Millisecond),
+		},
+		fibonacciCacheTTL: env.GetDuration("FIBONACCI_CACHE_TTL", 24*time.Hour),
+		jobs: jobsConfig{
+			workers:         env.GetInt("JOB_WORKERS", runtime.GOMAXPROCS(0)),
+			queue:           env.GetInt("JOB_QUEUE", 100),
+			ttl:             env.GetDuration("JOB_TTL", time.Hour),
+			cleanupInterval: env.GetDuration("JOB_CLEANUP_INTERVAL", time.Minute),
+			staleAfter:      env.GetDuration("JOB_STALE_AFTER", time.Minute),
+		},
+		counterStream: streamConfig{
+			maxClients:   env.GetInt("COUNTER_STREAM_MAX_CLIENTS", 1000),
+			heartbeat:    env.GetDuration("COUNTER_STREAM_HEARTBEAT", 15*time.Second),
+			writeTimeout: env.GetDuration("COUNTER_STREAM_WRITE_TIMEOUT", 5*time.Second),
+		},
+		ws: streamConfig{
+			maxClients:   env.GetInt("WS_MAX_CLIENTS", 1000),
+			heartbeat:    env.GetDuration("WS_PING_INTERVAL", 30*time.Second),
+			writeTimeout: env.GetDuration("WS_WRITE_TIMEOUT", 5*time.Second),
+		},
+		wsAllowedOrigins: strings.Fields(strings.ReplaceAll(env.GetString("WS_ALLOWED_ORIGINS", ""), ",", " ")),
+		rateLimit: rateLimitConfig{
+			enabled: env.GetBool("RATE_LIMIT_ENABLED", true),
+			policy: database.RateLimitPolicy{
+				Limit:  env.GetInt("RATE_LIMIT_REQUESTS", 60),
+				Window: env.GetDuration("RATE_LIMIT_WINDOW", time.Minute),
+			},
+			keyBy:      env.GetString("RATE_LIMIT_KEY", "ip"),
+			trustProxy: env.GetBool("RATE_LIMIT_TRUST_PROXY", false),
+		},
+		auth: authConfig{
+			required:   env.GetBool("AUTH_REQUIRED", true),
+			hmacSecret: env.GetString("JWT_HMAC_SECRET", ""),
+			jwksFile:   env.GetString("JWT_JWKS_FILE", ""),
+			jwksURL:    env.GetString("JWT_JWKS_URL", ""),
+			issuer:     env.GetString("JWT_ISSUER", ""),
+			audience:   env.GetString("JWT_AUDIENCE", ""),
+			leeway:     env.GetDuration("JWT_LEEWAY", 30*time.Second),
+		},
+		adminAddr: env.GetString("ADMIN_ADDR", ""),
+		redis: database.Config{
+			URL:              env.GetString("REDIS_URL", ""),
+			Addrs:            strings.Fields(strings.ReplaceAll(env.GetString("REDIS_ADDRS", ""), ",", " ")),
+			Username:         env.GetString("REDIS_USERNAME", ""),
+			Password:         env.GetString("REDIS_PASSWORD", ""),
+			DB:               env.GetInt("REDIS_DATABASE", 0),
+			MasterName:       env.GetString("REDIS_MASTER_NAME", ""),
+			SentinelUsername: env.GetString("REDIS_SENTINEL_USERNAME", ""),
+			SentinelPassword: env.GetString("REDIS_SENTINEL_PASSWORD", ""),
+			Cluster:          env.GetBool("REDIS_CLUSTER", false),
+			TLS: database.TLSConfig{
+				Enabled:            env.GetBool("REDIS_TLS", false),
+				CAFile:             env.GetString("REDIS_TLS_CA_FILE", ""),
+				CertFile:           env.GetString("REDIS_TLS_CERT_FILE", ""),
+				KeyFile:            env.GetString("REDIS_TLS_KEY_FILE", ""),
+				ServerName:         env.GetString("REDIS_TLS_SERVER_NAME", ""),
+				InsecureSkipVerify: env.GetBool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+			},
+			PoolSize:     env.GetInt("REDIS_POOL_SIZE", 0),
+			MinIdleConns: env.GetInt("REDIS_MIN_IDLE_CONNS", 0),
+			DialTimeout:  env.GetDuration("REDIS_DIAL_TIMEOUT", 0),
+			ReadTimeout:  env.GetDuration("REDIS_READ_TIMEOUT", 0),
+			WriteTimeout: env.GetDuration("REDIS_WRITE_TIMEOUT", 0),
+			PoolTimeout:  env.GetDuration("REDIS_POOL_TIMEOUT", 0),
+			MaxRetries:   env.GetInt("REDIS_MAX_RETRIES", 0),
+			KeyNamespace: env.GetString("REDIS_KEY_NAMESPACE", ""),
+		},
+		leaderElectionTTL: env.GetDuration("LEADER_ELECTION_TTL", 15*time.Second),
+	}
+	// REDIS_ADDRESS and REDIS_PORT predate REDIS_URL and REDIS_ADDRS.
+	if cfg.redis.URL == "" && len(cfg.redis.Addrs) == 0 {
+		cfg.redis.Addrs = []string{net.JoinHostPort(env.GetString("REDIS_ADDRESS", "localhost"), env.GetString("REDIS_PORT", "6379"))}
+	}
+	if cfg.redis.KeyNamespace == "" {
+		cfg.redis.KeyNamespace = cfg.env
 	}
 
+	logLevel := new(slog.LevelVar)
 	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		AddSource: true,
+		Level:     logLevel,
 	})).With("pid", os.Getpid(), "name", "gofetch")
 	slog.SetDefault(logger)
 
+	if err := logLevel.UnmarshalText([]byte(env.GetString("LOG_LEVEL", "info"))); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	algorithm, err := database.ParseRateLimitAlgorithm(env.GetString("RATE_LIMIT_ALGORITHM", string(database.SlidingWindow)))
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	cfg.rateLimit.policy.Algorithm = algorithm
+
+	verifier, err := newVerifier(cfg.auth)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if verifier == nil {
+		logger.Warn("no JWT signing keys configured, bearer authentication will reject all tokens")
+	}
+	if !cfg.auth.required {
+		logger.Warn("AUTH_REQUIRED is false, write routes accept anonymous requests without checking scopes")
+	}
+
 	apiClient, err := apiclient.NewClient(env.GetString("API_BASE_URL", "http://localhost:4444"), nil)
 	if err != nil {
 		logger.Error(err.Error())
 	}
 
+	var db database.Service
+	switch driver := env.GetString("DATABASE_DRIVER", "redis"); driver {
+	case "redis":
+		db, err = database.New(cfg.redis)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	case "memory":
+		logger.Warn("using the in-memory database, data is lost on restart and not shared between replicas")
+		db = memory.New()
+	default:
+		logger.Error("unknown DATABASE_DRIVER, want redis or memory", "driver", driver)
+		os.Exit(1)
+	}
+	registry := metrics.NewRegistry()
+	registerBuildInfo(registry)
+
+	jobPool := jobs.New(db.Jobs(), logger, jobs.Config{
+		Workers: cfg.jobs.workers,
+		Queue:   cfg.jobs.queue,
+		Metrics: registry,
+		PubSub:  db.PubSub(),
+	})
 	app := &application{
-		config:    cfg,
-		logger:    logger,
-		apiClient: apiClient,
-		db:        database.New(),
+		config:            cfg,
+		logger:            logger,
+		logLevel:          logLevel,
+		apiClient:         apiClient,
+		db:                db,
+		rateLimitFallback: database.NewMemoryRateLimiter(),
+		verifier:          verifier,
+		apiKeyUsage:       newAPIKeyUsage(),
+		metrics:           registry,
+		jobs:              jobPool,
+		counterBroker:     newCounterBroker(db.PubSub(), logger, cfg.counterStream.maxClients, registry),
 	}
+	app.wsHub = newWSHub(db.PubSub(), logger, cfg.ws, app.wsTopicAllowed, registry)
+
+	app.health = app.newHealthRegistry()
+	app.cleanupElector = app.newCleanupElector()
+	jobPool.Register("fibonacci", app.fibonacciJob)
+	jobPool.Start()
+
+	app.startRecordingAPIKeyUsage(10 * time.Second)
+	go app.counterBroker.run()
+	app.cleanupElector.Start()
 
 	mux := app.registerRoutes()
 
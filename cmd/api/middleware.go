@@ -72,3 +72,14 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// maxBodyBytes sets the request body limit readJSON enforces for the wrapped
+// routes, in place of defaultMaxBodyBytes.
+func (app *application) maxBodyBytes(n int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), maxBodyBytesContextKey, n)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
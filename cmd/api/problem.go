@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// problemTypeBaseURI prefixes the type URI of every problem this service
+// returns. Each type is described at GET /problems/{name}.
+const problemTypeBaseURI = "https://gofetch.timwalker.dev/problems/"
+
+// problemType is a registered RFC 9457 problem type.
+type problemType struct {
+	Name        string `json:"name"`
+	URI         string `json:"type"`
+	Title       string `json:"title"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+func newProblemType(name, title string, status int, description string) problemType {
+	return problemType{Name: name, URI: problemTypeBaseURI + name, Title: title, Status: status, Description: description}
+}
+
+var (
+	problemInternal                 = newProblemType("internal-error", "Internal Server Error", http.StatusInternalServerError, "The server encountered an unexpected condition.")
+	problemBadRequest               = newProblemType("bad-request", "Bad Request", http.StatusBadRequest, "The request could not be understood, e.g. malformed JSON.")
+	problemValidation               = newProblemType("validation-error", "Validation Failed", http.StatusUnprocessableEntity, "One or more fields are invalid. See the errors member for details.")
+	problemUnauthorized             = newProblemType("unauthorized", "Unauthorized", http.StatusUnauthorized, "Valid credentials are required.")
+	problemForbidden                = newProblemType("forbidden", "Forbidden", http.StatusForbidden, "The credentials do not grant access to this resource.")
+	problemNotFound                 = newProblemType("not-found", "Not Found", http.StatusNotFound, "The requested resource does not exist.")
+	problemRateLimited              = newProblemType("rate-limited", "Too Many Requests", http.StatusTooManyRequests, "The client exceeded its rate limit. Retry after the Retry-After delay.")
+	problemEditConflict             = newProblemType("edit-conflict", "Conflict", http.StatusConflict, "The resource was modified by a concurrent request.")
+	problemJobFinished              = newProblemType("job-finished", "Job Finished", http.StatusConflict, "The job has already finished and can no longer be canceled.")
+	problemIdempotencyKeyInProgress = newProblemType("idempotency-key-in-progress", "Request In Progress", http.StatusConflict, "A request with the same Idempotency-Key is still being processed. Retry after the Retry-After delay.")
+	problemIdempotencyKeyReused     = newProblemType("idempotency-key-reused", "Idempotency-Key Reused", http.StatusUnprocessableEntity, "The Idempotency-Key was first used with a different request payload.")
+	problemPreconditionFailed       = newProblemType("precondition-failed", "Precondition Failed", http.StatusPreconditionFailed, "The If-Match precondition does not match the current ETag of the resource.")
+	problemRequestTooLarge          = newProblemType("request-too-large", "Content Too Large", http.StatusRequestEntityTooLarge, "The request body exceeds the limit for this route.")
+	problemUnsupportedMediaType     = newProblemType("unsupported-media-type", "Unsupported Media Type", http.StatusUnsupportedMediaType, "The request body must be sent as application/json.")
+	problemServiceUnavailable       = newProblemType("service-unavailable", "Service Unavailable", http.StatusServiceUnavailable, "The server could not complete the request in time. Retry later.")
+	problemDatabaseUnavailable      = newProblemType("database-unavailable", "Database Unavailable", http.StatusServiceUnavailable, "The database cannot be reached. Retry after the Retry-After delay.")
+	problemGatewayTimeout           = newProblemType("gateway-timeout", "Gateway Timeout", http.StatusGatewayTimeout, "A service this API depends on did not respond in time.")
+	problemOverloaded               = newProblemType("overloaded", "Service Overloaded", http.StatusServiceUnavailable, "The server is handling too many requests for this route. Retry after the Retry-After delay.")
+	problemUpstreamFailure          = newProblemType("upstream-error", "Upstream Error", http.StatusBadGateway, "A service this API depends on failed.")
+	problemUpgradeRequired          = newProblemType("upgrade-required", "Upgrade Required", http.StatusUpgradeRequired, "The route only serves WebSocket connections. Send an RFC 6455 opening handshake.")
+	problemUpstreamUnavailable      = newProblemType("upstream-unavailable", "Upstream Unavailable", http.StatusServiceUnavailable, "A service this API depends on is failing, so requests to it are paused. Retry after the Retry-After delay.")
+)
+
+// problemTypes is the registry of problem types by name.
+var problemTypes = map[string]problemType{}
+
+// jsonPointerIndexPattern matches slice indexes in validation field paths.
+var jsonPointerIndexPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+func init() {
+	for _, pt := range []problemType{
+		problemInternal, problemBadRequest, problemValidation, problemUnauthorized,
+		problemForbidden, problemNotFound, problemEditConflict, problemJobFinished,
+		problemIdempotencyKeyInProgress, problemIdempotencyKeyReused, problemPreconditionFailed,
+		problemRateLimited, problemRequestTooLarge, problemUnsupportedMediaType,
+		problemServiceUnavailable, problemOverloaded, problemGatewayTimeout,
+		problemUpstreamFailure, problemUpstreamUnavailable, problemUpgradeRequired,
+		problemDatabaseUnavailable,
+	} {
+		problemTypes[pt.Name] = pt
+	}
+}
+
+// problem is an RFC 9457 problem details object.
+type problem struct {
+	Type     string
+	Title    string
+	Status   int
+	Detail   string
+	Instance string
+	// Extensions are serialized as additional top-level members.
+	Extensions map[string]any
+}
+
+func (p problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(p.Extensions)+5)
+	maps.Copy(members, p.Extensions)
+	members["type"] = p.Type
+	members["title"] = p.Title
+	members["status"] = p.Status
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+	return json.Marshal(members)
+}
+
+// validationProblemError is an entry in the errors extension of a
+// validation problem.
+type validationProblemError struct {
+	Pointer string `json:"pointer"`
+	Detail  string `json:"detail"`
+}
+
+// errorResponse writes an error response for pt. It uses problem+json when
+// enabled for all responses or requested by the client, and the
+// {"error": "..."} envelope otherwise. Field errors are included for
+// validation failures.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, pt problemType, detail string, fields map[string]string) {
+	w.Header().Add("Vary", "Accept")
+
+	if !app.wantsProblemJSON(r) {
+		if fields != nil {
+			writeJSONFieldErrors(w, status, detail, fields)
+			return
+		}
+		writeJSONError(w, status, detail)
+		return
+	}
+
+	p := problem{
+		Type:       pt.URI,
+		Title:      pt.Title,
+		Status:     status,
+		Detail:     detail,
+		Instance:   r.URL.Path,
+		Extensions: map[string]any{},
+	}
+	if id := app.getCorrelationID(r.Context()); id != "" {
+		p.Extensions["correlationId"] = id
+	}
+	if fields != nil {
+		p.Extensions["errors"] = validationProblemErrors(fields)
+	}
+
+	writeProblemJSON(w, p)
+}
+
+// wantsProblemJSON reports whether problem+json is enabled for every response
+// or accepted by the client.
+func (app *application) wantsProblemJSON(r *http.Request) bool {
+	if app.config.problemJSON {
+		return true
+	}
+
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil || mediaType != "application/problem+json" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// validationProblemErrors converts field paths such as "tracks[0].title"
+// to JSON pointers into the request body, sorted by pointer.
+func validationProblemErrors(fields map[string]string) []validationProblemError {
+	errs := make([]validationProblemError, 0, len(fields))
+	for field, msg := range fields {
+		pointer := jsonPointerIndexPattern.ReplaceAllString(field, ".$1")
+		pointer = strings.ReplaceAll(pointer, "~", "~0")
+		pointer = strings.ReplaceAll(pointer, "/", "~1")
+		pointer = "#/" + strings.ReplaceAll(pointer, ".", "/")
+		if field == "body" {
+			pointer = "#"
+		}
+		errs = append(errs, validationProblemError{Pointer: pointer, Detail: msg})
+	}
+	slices.SortFunc(errs, func(a, b validationProblemError) int {
+		return strings.Compare(a.Pointer, b.Pointer)
+	})
+	return errs
+}
+
+// listProblemTypesHandler describes every registered problem type.
+func (app *application) listProblemTypesHandler(w http.ResponseWriter, r *http.Request) {
+	types := slices.SortedFunc(maps.Values(problemTypes), func(a, b problemType) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	if err := writeJSONData(w, http.StatusOK, types); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// getProblemTypeHandler describes the problem type a type URI refers to.
+func (app *application) getProblemTypeHandler(w http.ResponseWriter, r *http.Request) {
+	pt, ok := problemTypes[r.PathValue("name")]
+	if !ok {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if err := writeJSONData(w, http.StatusOK, pt); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
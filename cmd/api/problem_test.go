@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorResponseFormats(t *testing.T) {
+	app := newTestAuthApplication(t)
+	mux := app.registerRoutes()
+	token := "Bearer " + newTestToken(t, map[string]any{"sub": "user-1", "scope": "albums:write"})
+
+	t.Run("legacy_envelope_by_default", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type: got %q want %q", got, "application/json")
+		}
+		var body map[string]any
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body["error"] != "Not Found" {
+			t.Errorf("unexpected body: %v", body)
+		}
+	})
+
+	t.Run("problem_when_accepted", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/missing", nil)
+		r.Header.Set("Accept", "application/json, application/problem+json;q=0.9")
+		mux.ServeHTTP(rr, r)
+
+		if got := rr.Header().Get("Content-Type"); got != "application/problem+json" {
+			t.Errorf("Content-Type: got %q want %q", got, "application/problem+json")
+		}
+		var body map[string]any
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body["type"] != problemNotFound.URI || body["status"] != float64(http.StatusNotFound) || body["instance"] != "/missing" {
+			t.Errorf("unexpected problem: %v", body)
+		}
+		if body["correlationId"] == nil || body["correlationId"] != rr.Header().Get(correlationIDHeaderKey) {
+			t.Errorf("correlationId: got %v want %q", body["correlationId"], rr.Header().Get(correlationIDHeaderKey))
+		}
+	})
+
+	t.Run("q_zero_is_not_accepted", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/missing", nil)
+		r.Header.Set("Accept", "application/problem+json;q=0")
+		mux.ServeHTTP(rr, r)
+
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type: got %q want %q", got, "application/json")
+		}
+	})
+
+	t.Run("validation_errors_as_pointers", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/albums", strings.NewReader(`{"userId":1,"title":""}`))
+		r.Header.Set("Authorization", token)
+		r.Header.Set("Accept", "application/problem+json")
+		mux.ServeHTTP(rr, r)
+
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+		}
+		var body struct {
+			Type   string                   `json:"type"`
+			Errors []validationProblemError `json:"errors"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Type != problemValidation.URI {
+			t.Errorf("type: got %q want %q", body.Type, problemValidation.URI)
+		}
+		if len(body.Errors) != 1 || body.Errors[0].Pointer != "#/title" {
+			t.Errorf("unexpected errors: %+v", body.Errors)
+		}
+	})
+
+	t.Run("problem_for_all_when_configured", func(t *testing.T) {
+		app.config.problemJSON = true
+		defer func() { app.config.problemJSON = false }()
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+		if got := rr.Header().Get("Content-Type"); got != "application/problem+json" {
+			t.Errorf("Content-Type: got %q want %q", got, "application/problem+json")
+		}
+	})
+
+	t.Run("problem_type_documented", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/problems/validation-error", nil))
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %v want %v", rr.Code, http.StatusOK)
+		}
+	})
+}
+
+func TestValidationProblemErrors(t *testing.T) {
+	errs := validationProblemErrors(map[string]string{
+		"tracks[1].title": "is required",
+		"body":            "something is wrong",
+		"a/b":             "escaped",
+	})
+
+	want := []string{"#", "#/a~1b", "#/tracks/1/title"}
+	for i, e := range errs {
+		if e.Pointer != want[i] {
+			t.Errorf("pointer %d: got %q want %q", i, e.Pointer, want[i])
+		}
+	}
+}
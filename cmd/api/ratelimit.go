@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+// rateLimiter records requests against a key and reports whether they are
+// within a policy. database.Service and database.MemoryRateLimiter both
+// satisfy it.
+type rateLimiter interface {
+	RateLimit(ctx context.Context, key string, policy database.RateLimitPolicy) (database.RateLimitResult, error)
+}
+
+// rateLimitKeyFunc extracts the client identity requests are counted against.
+type rateLimitKeyFunc func(r *http.Request) string
+
+// rateLimit middleware limits requests per client using the provided policy.
+// The scope namespaces the counters so routes do not share a quota.
+// Requests are counted in Redis, falling back to the in-memory limiter when
+// Redis is unavailable.
+func (app *application) rateLimit(scope string, policy database.RateLimitPolicy, keyFunc rateLimitKeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !app.config.rateLimit.enabled {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := scope + ":" + keyFunc(r)
+
+			result, err := app.db.RateLimit(r.Context(), key, policy)
+			if err != nil {
+				app.logger.Warn("rate limit store failed, using in-memory fallback", "error", err.Error(), string(correlationIDContextKey), app.getCorrelationID(r.Context()))
+
+				if app.rateLimitFallback == nil {
+					app.databaseErrorResponse(w, r, err)
+					return
+				}
+				result, err = app.rateLimitFallback.RateLimit(r.Context(), key, policy)
+				if err != nil {
+					app.internalServerError(w, r, err)
+					return
+				}
+			}
+
+			setRateLimitHeaders(w.Header(), policy, result)
+
+			if !result.Allowed {
+				app.rateLimitExceededResponse(w, r, result.RetryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// setRateLimitHeaders sets the RateLimit header fields from the IETF
+// ratelimit-headers draft.
+func setRateLimitHeaders(h http.Header, policy database.RateLimitPolicy, result database.RateLimitResult) {
+	h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, seconds(policy.Window)))
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimitKeyFunc returns the configured client key extractor.
+func (app *application) rateLimitKeyFunc() rateLimitKeyFunc {
+	switch app.config.rateLimit.keyBy {
+	case "apikey":
+		return app.rateLimitByAPIKey
+	default:
+		return app.rateLimitByIP
+	}
+}
+
+// rateLimitByIP keys requests by the client IP address.
+func (app *application) rateLimitByIP(r *http.Request) string {
+	return "ip:" + app.clientIP(r)
+}
+
+// rateLimitByAPIKey keys requests by a hash of the client API key, falling
+// back to the client IP address for anonymous requests.
+func (app *application) rateLimitByAPIKey(r *http.Request) string {
+	key := apiKeyFromRequest(r)
+	if key == "" {
+		return app.rateLimitByIP(r)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return "key:" + hex.EncodeToString(sum[:16])
+}
+
+// clientIP returns the IP address of the client. Forwarding headers are only
+// trusted when the server runs behind a proxy that sets them.
+func (app *application) clientIP(r *http.Request) string {
+	if app.config.rateLimit.trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			ip, _, _ := strings.Cut(fwd, ",")
+			return strings.TrimSpace(ip)
+		}
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// apiKeyFromRequest returns the API key from the "X-API-Key" header or an
+// "Authorization: ApiKey <key>" header.
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "ApiKey") {
+		return strings.TrimSpace(key)
+	}
+	return ""
+}
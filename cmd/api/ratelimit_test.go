@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+// failingDB fails every rate limit check to exercise the fallback.
+type failingDB struct {
+	MockDB
+}
+
+func (f *failingDB) RateLimit(ctx context.Context, key string, policy database.RateLimitPolicy) (database.RateLimitResult, error) {
+	return database.RateLimitResult{}, errors.New("redis unavailable")
+}
+
+func TestRateLimit(t *testing.T) {
+	policy := database.RateLimitPolicy{Algorithm: database.FixedWindow, Limit: 2, Window: time.Minute}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("rejects_requests_over_the_limit", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.config.rateLimit.enabled = true
+		handler := app.rateLimit("test", policy, app.rateLimitByIP)(next)
+
+		for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != want {
+				t.Errorf("request %d: got status %v want %v", i, rr.Code, want)
+			}
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if got := rr.Header().Get("Retry-After"); got == "" {
+			t.Error("Expected Retry-After header on rejected request")
+		}
+		if got := rr.Header().Get("RateLimit-Remaining"); got != "0" {
+			t.Errorf("RateLimit-Remaining: got %q want %q", got, "0")
+		}
+		if got := rr.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+			t.Errorf("RateLimit-Policy: got %q want %q", got, "2;w=60")
+		}
+	})
+
+	t.Run("counts_clients_separately", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.config.rateLimit.enabled = true
+		handler := app.rateLimit("test", policy, app.rateLimitByAPIKey)(next)
+
+		for _, key := range []string{"a", "a", "b"} {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-API-Key", key)
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("key %q: got status %v want %v", key, rr.Code, http.StatusOK)
+			}
+		}
+	})
+
+	t.Run("falls_back_to_memory_when_store_fails", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.db = &failingDB{}
+		app.rateLimitFallback = database.NewMemoryRateLimiter()
+		app.config.rateLimit.enabled = true
+		handler := app.rateLimit("test", policy, app.rateLimitByIP)(next)
+
+		for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rr.Code != want {
+				t.Errorf("request %d: got status %v want %v", i, rr.Code, want)
+			}
+		}
+	})
+
+	t.Run("disabled_passes_through", func(t *testing.T) {
+		app := newTestApplication(t)
+		handler := app.rateLimit("test", policy, app.rateLimitByIP)(next)
+
+		for range 3 {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rr.Code != http.StatusOK {
+				t.Errorf("got status %v want %v", rr.Code, http.StatusOK)
+			}
+		}
+	})
+}
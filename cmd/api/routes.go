@@ -7,15 +7,61 @@ import (
 func (app *application) registerRoutes() http.Handler {
 	mux := http.NewServeMux()
 
+	rateLimitKey := app.rateLimitKeyFunc()
+	policy := app.config.rateLimit.policy
+	albumBodyLimit := app.maxBodyBytes(16 << 10)
+	requestTimeout := app.timeout(app.config.timeouts.request, http.StatusServiceUnavailable)
+	upstreamTimeout := app.timeout(app.config.timeouts.request, http.StatusGatewayTimeout)
+	fibonacciTimeout := app.timeout(app.config.timeouts.fibonacci, http.StatusServiceUnavailable)
+	fibonacciConcurrency := app.concurrencyLimit("fibonacci", app.config.fibonacciConcurrency)
+	counterWrite := func(h http.HandlerFunc) http.Handler {
+		return requestTimeout(app.requireScopes("counters:write")(app.rateLimit("counters", policy, rateLimitKey)(h)))
+	}
+
 	mux.HandleFunc("GET /health", app.healthCheckHandler)
 	mux.HandleFunc("HEAD /health", app.healthCheckHandler)
+	mux.HandleFunc("GET /version", app.versionHandler)
+	mux.HandleFunc("GET /livez", app.livezHandler)
+	mux.HandleFunc("GET /readyz", app.readyzHandler)
+	mux.HandleFunc("GET /startupz", app.startupzHandler)
+	mux.Handle("GET /metrics", app.metrics.Handler())
 	mux.HandleFunc("GET /errors/{code}", app.checkErrorResponseHandler)
-	mux.HandleFunc("GET /apiclient/albums", app.getAlbumsFromApiClientHandler)
-	mux.HandleFunc("POST /albums", app.createAlbumHandler)
-	mux.HandleFunc("GET /counter", app.viewCounterHandler)
-	mux.HandleFunc("GET /fibonacci/{num}", app.fibonacciHandler)
+	mux.Handle("GET /problems", app.etag(http.HandlerFunc(app.listProblemTypesHandler)))
+	mux.Handle("GET /problems/{name}", app.etag(http.HandlerFunc(app.getProblemTypeHandler)))
+	mux.Handle("GET /apiclient/albums", upstreamTimeout(app.rateLimit("apiclient", policy, rateLimitKey)(http.HandlerFunc(app.getAlbumsFromApiClientHandler))))
+	mux.Handle("GET /albums", requestTimeout(app.etag(http.HandlerFunc(app.listAlbumsHandler))))
+	mux.Handle("GET /albums/{id}", requestTimeout(http.HandlerFunc(app.getAlbumHandler)))
+	mux.Handle("POST /albums", requestTimeout(app.requireScopes("albums:write")(albumBodyLimit(app.idempotent(http.HandlerFunc(app.createAlbumHandler))))))
+	mux.Handle("PUT /albums/{id}", requestTimeout(app.requireScopes("albums:write")(albumBodyLimit(http.HandlerFunc(app.updateAlbumHandler)))))
+	mux.Handle("PATCH /albums/{id}", requestTimeout(app.requireScopes("albums:write")(albumBodyLimit(http.HandlerFunc(app.patchAlbumHandler)))))
+	mux.Handle("DELETE /albums/{id}", requestTimeout(app.requireScopes("albums:write")(http.HandlerFunc(app.deleteAlbumHandler))))
+	mux.Handle("GET /counter", requestTimeout(http.HandlerFunc(app.viewCounterHandler)))
+	mux.Handle("GET /counters/{name}", requestTimeout(http.HandlerFunc(app.getCounterHandler)))
+	mux.Handle("POST /counters/{name}/incr", counterWrite(app.incrCounterHandler))
+	mux.Handle("POST /counters/{name}/decr", counterWrite(app.decrCounterHandler))
+	mux.Handle("POST /counters/{name}/expire", counterWrite(app.expireCounterHandler))
+	mux.Handle("POST /counters/{name}/reset", counterWrite(app.resetCounterHandler))
+	// The timeout and etag middleware buffer responses, so streams and
+	// WebSocket upgrades skip them.
+	mux.HandleFunc("GET /counter/stream", app.counterStreamHandler)
+	mux.Handle("GET /ws", app.requireScopes()(http.HandlerFunc(app.wsHandler)))
+	mux.Handle("POST /apikeys", requestTimeout(app.requireUser(http.HandlerFunc(app.createAPIKeyHandler))))
+	mux.Handle("GET /apikeys", requestTimeout(app.requireUser(http.HandlerFunc(app.listAPIKeysHandler))))
+	mux.Handle("GET /apikeys/{id}", requestTimeout(app.requireUser(http.HandlerFunc(app.getAPIKeyHandler))))
+	mux.Handle("POST /apikeys/{id}/rotate", requestTimeout(app.requireUser(http.HandlerFunc(app.rotateAPIKeyHandler))))
+	mux.Handle("DELETE /apikeys/{id}", requestTimeout(app.requireUser(http.HandlerFunc(app.revokeAPIKeyHandler))))
+	mux.Handle("POST /jobs/fibonacci", requestTimeout(app.requireScopes("jobs:write")(app.rateLimit("jobs", policy, rateLimitKey)(http.HandlerFunc(app.createFibonacciJobHandler)))))
+	mux.Handle("GET /jobs/{id}", requestTimeout(app.requireScopes("jobs:read")(http.HandlerFunc(app.getJobHandler))))
+	mux.Handle("DELETE /jobs/{id}", requestTimeout(app.requireScopes("jobs:write")(http.HandlerFunc(app.cancelJobHandler))))
+	mux.Handle("GET /fibonacci/{num}", fibonacciTimeout(app.rateLimit("fibonacci", policy, rateLimitKey)(fibonacciConcurrency(http.HandlerFunc(app.fibonacciHandler)))))
+	// pprof profiles stream for their duration, so the admin routes have no
+	// handler timeout, and the pprof handlers clear the write deadline set
+	// by the WriteTimeout of this listener.
+	if app.config.adminAddr == "" {
+		mux.Handle("/admin/", app.requireRoles("admin")(app.adminRoutes()))
+	}
 	// Unmatched route patters receive a 404
 	mux.HandleFunc("/", app.notFoundResponse)
 
-	return app.recoverPanic(app.correlationIDMiddleware(mux))
+	return app.recoverPanic(app.correlationIDMiddleware(app.compress(mux)))
 }
@@ -1,28 +1,139 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"gofetch.timwalker.dev/internal/apiclient"
+	"gofetch.timwalker.dev/internal/auth"
 	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/health"
+	"gofetch.timwalker.dev/internal/jobs"
+	"gofetch.timwalker.dev/internal/metrics"
+	"gofetch.timwalker.dev/internal/version"
 )
 
 type config struct {
 	port int
 	env  string
+	// problemJSON writes every error as application/problem+json instead of
+	// only when the client asks for it.
+	problemJSON bool
+	// idempotencyTTL is how long responses are kept for Idempotency-Key replays.
+	idempotencyTTL time.Duration
+	timeouts       timeoutConfig
+	// fibonacciConcurrency limits the CPU-heavy /fibonacci route.
+	fibonacciConcurrency concurrencyConfig
+	// fibonacciCacheTTL is how long computed Fibonacci numbers are cached.
+	fibonacciCacheTTL time.Duration
+	jobs              jobsConfig
+	counterStream     streamConfig
+	// ws limits /ws connections; heartbeat is the ping interval.
+	ws streamConfig
+	// wsAllowedOrigins are the cross-origin pages that may open /ws.
+	wsAllowedOrigins []string
+	rateLimit        rateLimitConfig
+	auth             authConfig
+	// adminAddr, if set, is the private address the /admin routes are
+	// served on without authentication. Otherwise they are served on the
+	// public port to callers with the admin role.
+	adminAddr string
+	redis     database.Config
+	// leaderElectionTTL is how long a replica leads background work, such
+	// as job cleanup, without renewing, and so how long another replica
+	// waits to take over from one that crashed.
+	leaderElectionTTL time.Duration
+}
+
+// timeoutConfig holds the handler deadlines of route groups. They should be
+// shorter than the server WriteTimeout so clients receive an error response
+// instead of a dropped connection.
+type timeoutConfig struct {
+	request   time.Duration
+	fibonacci time.Duration
+}
+
+// jobsConfig sizes the asynchronous job worker pool.
+type jobsConfig struct {
+	workers int
+	queue   int
+	// ttl is how long finished jobs are kept, checked every cleanupInterval.
+	ttl             time.Duration
+	cleanupInterval time.Duration
+	// staleAfter is how long a running job may go without a heartbeat
+	// before it is failed, or zero to leave running jobs alone.
+	staleAfter time.Duration
+}
+
+// streamConfig limits Server-Sent Events streams.
+type streamConfig struct {
+	maxClients int
+	// heartbeat is the interval of comments sent to idle streams.
+	heartbeat time.Duration
+	// writeTimeout is how long a client may take to accept each event.
+	writeTimeout time.Duration
+}
+
+type rateLimitConfig struct {
+	enabled    bool
+	policy     database.RateLimitPolicy
+	keyBy      string // "ip" or "apikey"
+	trustProxy bool
+}
+
+type authConfig struct {
+	// required makes write routes reject anonymous requests. Otherwise
+	// they stay open to anonymous clients, as before authentication was
+	// added, and only requests carrying credentials are checked.
+	required   bool
+	hmacSecret string
+	jwksFile   string
+	jwksURL    string
+	issuer     string
+	audience   string
+	leeway     time.Duration
 }
 
 type application struct {
-	config    config
-	logger    *slog.Logger
-	apiClient *apiclient.APIClient
-	db        database.Service
+	config            config
+	logger            *slog.Logger
+	logLevel          *slog.LevelVar
+	apiClient         *apiclient.APIClient
+	db                database.Service
+	rateLimitFallback rateLimiter
+	verifier          *auth.Verifier
+	apiKeyUsage       *apiKeyUsage
+	// stopRecordingAPIKeyUsage is set by startRecordingAPIKeyUsage.
+	stopRecordingAPIKeyUsage func(ctx context.Context) error
+	metrics                  *metrics.Registry
+	jobs                     *jobs.Pool
+	cleanupElector           *database.Elector
+	counterBroker            *counterBroker
+	wsHub                    *wsHub
+	health                   *health.Registry
+	// listening is set once the server accepts connections, and
+	// startupComplete once /startupz has passed.
+	listening       atomic.Bool
+	startupComplete atomic.Bool
 }
 
+// shutdownTimeout bounds how long in-flight requests and running jobs are
+// given to finish after SIGINT or SIGTERM.
+const shutdownTimeout = 30 * time.Second
+
+// adminWriteTimeout is the write timeout of the admin listener. pprof rejects
+// profiles and traces that would run past it.
+const adminWriteTimeout = 2 * time.Minute
+
 func (app *application) serve(mux http.Handler) error {
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),
@@ -33,13 +144,94 @@ func (app *application) serve(mux http.Handler) error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 	}
 
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
+	// Streams never go idle, so Shutdown would wait for them until it
+	// times out, and it does not track hijacked WebSocket connections.
+	srv.RegisterOnShutdown(app.counterBroker.close)
+	srv.RegisterOnShutdown(app.wsHub.close)
+
+	var adminSrv *http.Server
+	if app.config.adminAddr != "" {
+		adminSrv = &http.Server{
+			Addr:         app.config.adminAddr,
+			Handler:      app.recoverPanic(app.correlationIDMiddleware(app.adminRoutes())),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: adminWriteTimeout,
+			ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		}
+	}
+
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		// Stop taking requests first so no jobs are submitted to a pool
+		// that is shutting down.
+		err := srv.Shutdown(ctx)
+		if adminSrv != nil {
+			if adminErr := adminSrv.Shutdown(ctx); err == nil {
+				err = adminErr
+			}
+		}
+		// Requests have finished, so no more usage is recorded.
+		if app.stopRecordingAPIKeyUsage != nil {
+			if usageErr := app.stopRecordingAPIKeyUsage(ctx); err == nil {
+				err = usageErr
+			}
+		}
+		if jobsErr := app.jobs.Shutdown(ctx); err == nil {
+			err = jobsErr
+		}
+		// Release leadership so another replica takes over without waiting
+		// for it to expire.
+		if electorErr := app.cleanupElector.Shutdown(ctx); err == nil {
+			err = electorErr
+		}
+		shutdownError <- err
+	}()
+
+	info := version.Get()
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env,
+		"version", info.Version, "commit", info.Commit, "dirty", info.Dirty, "buildTime", info.BuildTime, "goVersion", info.GoVersion)
+
+	if adminSrv != nil {
+		adminLn, err := net.Listen("tcp", adminSrv.Addr)
+		if err != nil {
+			return err
+		}
+		app.logger.Info("starting admin server", "addr", adminSrv.Addr)
+		go func() {
+			err := adminSrv.Serve(adminLn)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("admin server failed", "addr", adminSrv.Addr, "error", err.Error())
+			}
+		}()
+	}
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	app.listening.Store(true)
 
-	err := srv.ListenAndServe()
+	err = srv.Serve(ln)
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
 
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
 	app.logger.Info("stopped server", "addr", srv.Addr)
 
 	return nil
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sseRetry is the reconnection delay sent to EventSource clients.
+const sseRetry = 3 * time.Second
+
+// sseWriter writes a Server-Sent Events stream. Every write gets its own
+// deadline in place of the server WriteTimeout, which would otherwise end
+// the stream, so a stalled client is dropped without limiting healthy ones.
+type sseWriter struct {
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	writeTimeout time.Duration
+}
+
+// newSSEWriter sends the headers of an event stream and the retry delay.
+func newSSEWriter(w http.ResponseWriter, writeTimeout time.Duration) (*sseWriter, error) {
+	s := &sseWriter{w: w, rc: http.NewResponseController(w), writeTimeout: writeTimeout}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	// Stops nginx from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	return s, s.write(fmt.Sprintf("retry: %d\n\n", sseRetry.Milliseconds()))
+}
+
+// event writes an event. Data with newlines is split over several data
+// fields, which the client joins back together.
+func (s *sseWriter) event(id, name, data string) error {
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	if name != "" {
+		fmt.Fprintf(&b, "event: %s\n", name)
+	}
+	for line := range strings.SplitSeq(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// comment writes a comment, which clients ignore. It keeps idle connections
+// from being closed by proxies and detects disconnected clients.
+func (s *sseWriter) comment(text string) error {
+	return s.write(": " + text + "\n\n")
+}
+
+func (s *sseWriter) write(msg string) error {
+	err := s.rc.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := s.w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
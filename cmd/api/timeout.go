@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// timeout middleware runs the handler with a context deadline of d. If the
+// deadline passes first, the client receives an error response with status,
+// 503 when the handler itself is slow or 504 when it waits on an upstream
+// service, and anything the handler writes afterwards is discarded. Handlers
+// should stop work when their request context is done.
+//
+// Responses are buffered until the handler returns, so timeout must not wrap
+// streaming routes.
+func (app *application) timeout(d time.Duration, status int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutResponseWriter{header: w.Header().Clone(), status: http.StatusOK}
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+
+			go func() {
+				defer func() {
+					if err := recover(); err != nil {
+						panicked <- fmt.Errorf("%v\n%s", err, debug.Stack())
+					}
+				}()
+				next.ServeHTTP(tw, r)
+				close(done)
+			}()
+
+			select {
+			case err := <-panicked:
+				// Re-panic on the request goroutine so recoverPanic handles it.
+				panic(err)
+
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+
+				// The handler started from a clone of the headers, so the
+				// clone replaces them, including headers it deleted.
+				header := w.Header()
+				clear(header)
+				maps.Copy(header, tw.header)
+				w.WriteHeader(tw.status)
+				w.Write(tw.body.Bytes())
+
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+
+				if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					// The client went away; there is no one to respond to.
+					return
+				}
+				app.timeoutResponse(w, r, d, status)
+			}
+		})
+	}
+}
+
+// timeoutResponseWriter buffers a response so it can be discarded when the
+// handler times out. Writes after the timeout return http.ErrHandlerTimeout.
+type timeoutResponseWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	timedOut    bool
+}
+
+func (tw *timeoutResponseWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutResponseWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.writeHeaderLocked(status)
+}
+
+func (tw *timeoutResponseWriter) writeHeaderLocked(status int) {
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.status = status
+	tw.wroteHeader = true
+}
+
+func (tw *timeoutResponseWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	return tw.body.Write(p)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	app := newTestApplication(t)
+
+	t.Run("passes_through_fast_responses", func(t *testing.T) {
+		handler := app.timeout(time.Second, http.StatusServiceUnavailable)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test", "yes")
+			writeJSON(w, http.StatusCreated, "ok")
+		}))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rr.Code != http.StatusCreated || rr.Header().Get("X-Test") != "yes" || rr.Body.String() != "\"ok\"\n" {
+			t.Errorf("unexpected response: %v %v %q", rr.Code, rr.Header(), rr.Body)
+		}
+	})
+
+	t.Run("keeps_deleted_headers_deleted", func(t *testing.T) {
+		handler := app.timeout(time.Second, http.StatusServiceUnavailable)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Del("X-Outer")
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		rr := httptest.NewRecorder()
+		rr.Header().Set("X-Outer", "set before the handler")
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rr.Code != http.StatusNoContent || rr.Header().Get("X-Outer") != "" {
+			t.Errorf("unexpected response: %v %v", rr.Code, rr.Header())
+		}
+	})
+
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			lateWrite := make(chan error)
+			handler := app.timeout(10*time.Millisecond, status)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				time.Sleep(10 * time.Millisecond)
+				w.Header().Set("X-Late", "yes")
+				_, err := w.Write([]byte("late"))
+				lateWrite <- err
+			}))
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if err := <-lateWrite; err != http.ErrHandlerTimeout {
+				t.Errorf("late write: got %v want %v", err, http.ErrHandlerTimeout)
+			}
+			if rr.Code != status {
+				t.Errorf("got status %v want %v", rr.Code, status)
+			}
+			if rr.Header().Get("X-Late") != "" {
+				t.Error("late header reached the response")
+			}
+
+			var body map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body["error"] == "" {
+				t.Errorf("expected an error envelope, got %v", body)
+			}
+		})
+	}
+
+	t.Run("propagates_panics", func(t *testing.T) {
+		handler := app.recoverPanic(app.timeout(time.Second, http.StatusServiceUnavailable)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusInternalServerError)
+		}
+	})
+}
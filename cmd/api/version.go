@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"gofetch.timwalker.dev/internal/metrics"
+	"gofetch.timwalker.dev/internal/version"
+)
+
+// versionHandler returns the build metadata of the running binary.
+func (app *application) versionHandler(w http.ResponseWriter, r *http.Request) {
+	if err := writeJSON(w, http.StatusOK, version.Get()); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// registerBuildInfo exports the build metadata as the labels of a constant
+// gofetch_build_info gauge.
+func registerBuildInfo(reg *metrics.Registry) {
+	info := version.Get()
+	reg.Gauge("gofetch_build_info", "Build metadata of the running binary.", "version", "commit", "dirty", "go_version", "build_time").
+		Set(1, info.Version, info.Commit, strconv.FormatBool(info.Dirty), info.GoVersion, info.BuildTime)
+}
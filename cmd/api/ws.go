@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gofetch.timwalker.dev/internal/auth"
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/metrics"
+	"gofetch.timwalker.dev/internal/websocket"
+)
+
+const (
+	// wsReadLimit bounds client messages, which are small JSON requests.
+	wsReadLimit = 4 << 10
+	// wsSendBuffer is how many messages may wait for a client before it is
+	// disconnected as too slow.
+	wsSendBuffer = 32
+	// wsMaxTopics is how many topics a client may subscribe to.
+	wsMaxTopics = 32
+)
+
+// wsRequest is a message from a client: {"type":"subscribe","topic":"counter"}
+// or {"type":"unsubscribe","topic":"counter"}.
+type wsRequest struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic"`
+}
+
+// wsResponse is a message to a client. Type is subscribed, unsubscribed,
+// error, or message for data published on a topic.
+type wsResponse struct {
+	Type  string          `json:"type"`
+	Topic string          `json:"topic,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// wsTopicAllowed reports whether a client with claims may subscribe to
+// topic, which is also the name of its channel: counter for counter values,
+// or jobs:<id> for the events of a job the client may access.
+func (app *application) wsTopicAllowed(ctx context.Context, claims *auth.Claims, topic string) bool {
+	if topic == database.CounterChannel {
+		return true
+	}
+	id, ok := strings.CutPrefix(topic, "jobs:")
+	if !ok || uuid.Validate(id) != nil {
+		return false
+	}
+	job, err := app.db.Jobs().Get(ctx, id)
+	return err == nil && app.canAccessJob(claims, job)
+}
+
+// wsHandler upgrades the request to a WebSocket connection served by the
+// hub until the client disconnects.
+func (app *application) wsHandler(w http.ResponseWriter, r *http.Request) {
+	client, err := app.wsHub.join(app.getClaims(r.Context()))
+	if err != nil {
+		app.overloadedResponse(w, r, "ws")
+		return
+	}
+
+	conn, err := websocket.Upgrade(w, r, &websocket.UpgradeOptions{
+		CheckOrigin: app.checkWebSocketOrigin,
+		ReadLimit:   wsReadLimit,
+	})
+	if err != nil {
+		app.wsHub.leave(client)
+		var herr *websocket.HandshakeError
+		if !errors.As(err, &herr) {
+			app.internalServerError(w, r, err)
+			return
+		}
+		switch herr.Status {
+		case http.StatusForbidden:
+			app.forbiddenResponse(w, r, err)
+		case http.StatusUpgradeRequired:
+			app.upgradeRequiredResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	app.wsHub.serve(client, conn)
+}
+
+// checkWebSocketOrigin accepts requests without an Origin, same-origin
+// requests and those from the configured origins.
+func (app *application) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || slices.Contains(app.config.wsAllowedOrigins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// wsHub serves /ws clients, which subscribe to topics backed by PubSub
+// channels. The hub holds one subscription per topic with local
+// subscribers, so a message published by any replica reaches every client.
+type wsHub struct {
+	pubsub     database.PubSub
+	logger     *slog.Logger
+	cfg        streamConfig
+	allowTopic func(ctx context.Context, claims *auth.Claims, topic string) bool
+
+	mu      sync.Mutex
+	clients map[*wsClient]struct{}
+	topics  map[string]*wsTopic
+	closed  bool
+}
+
+// wsTopic is a subscription shared by the clients of a topic.
+type wsTopic struct {
+	sub     database.Subscription
+	clients map[*wsClient]struct{}
+}
+
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+	// done is closed when the client leaves, stopping its writer.
+	done chan struct{}
+	// topics is guarded by the hub's mutex.
+	topics map[string]struct{}
+	// claims authenticated the upgrade request, or are nil for anonymous
+	// clients.
+	claims *auth.Claims
+}
+
+func newWSHub(pubsub database.PubSub, logger *slog.Logger, cfg streamConfig, allowTopic func(context.Context, *auth.Claims, string) bool, reg *metrics.Registry) *wsHub {
+	h := &wsHub{
+		pubsub:     pubsub,
+		logger:     logger,
+		cfg:        cfg,
+		allowTopic: allowTopic,
+		clients:    make(map[*wsClient]struct{}),
+		topics:     make(map[string]*wsTopic),
+	}
+
+	reg.Gauge("gofetch_ws_clients", "Connected WebSocket clients.").
+		Func(func() float64 {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return float64(len(h.clients))
+		})
+	reg.Gauge("gofetch_ws_topics", "Topics with WebSocket subscribers on this replica.").
+		Func(func() float64 {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return float64(len(h.topics))
+		})
+	return h
+}
+
+// join reserves a place for a client with claims before the handshake. It
+// returns errTooManyStreams at the connection cap and errStreamsClosed once
+// the hub is closed.
+func (h *wsHub) join(claims *auth.Claims) (*wsClient, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, errStreamsClosed
+	}
+	if len(h.clients) >= max(h.cfg.maxClients, 1) {
+		return nil, errTooManyStreams
+	}
+	c := &wsClient{
+		send:   make(chan []byte, wsSendBuffer),
+		done:   make(chan struct{}),
+		topics: make(map[string]struct{}),
+		claims: claims,
+	}
+	h.clients[c] = struct{}{}
+	return c, nil
+}
+
+// leave removes a client and its subscriptions.
+func (h *wsHub) leave(c *wsClient) {
+	h.mu.Lock()
+	if _, ok := h.clients[c]; !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.clients, c)
+	close(c.done)
+	var unused []database.Subscription
+	for topic := range c.topics {
+		if sub := h.removeLocked(c, topic); sub != nil {
+			unused = append(unused, sub)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, sub := range unused {
+		sub.Close()
+	}
+}
+
+// serve runs a connected client until it disconnects.
+func (h *wsHub) serve(c *wsClient, conn *websocket.Conn) {
+	defer h.leave(c)
+
+	h.mu.Lock()
+	c.conn = conn
+	closed := h.closed
+	h.mu.Unlock()
+	if closed {
+		conn.Close(websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+
+	go h.write(c)
+
+	// A client that answers no ping for two intervals is gone.
+	pongWait := 2 * h.cfg.heartbeat
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func([]byte) { conn.SetReadDeadline(time.Now().Add(pongWait)) })
+
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				h.logger.Debug("websocket closed", "remote", conn.RemoteAddr().String(), "error", err.Error())
+			}
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		var req wsRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			h.reply(c, wsResponse{Type: "error", Error: "messages must be JSON objects with a type and topic"})
+			continue
+		}
+		switch req.Type {
+		case "subscribe":
+			if err := h.subscribe(c, req.Topic); err != nil {
+				h.reply(c, wsResponse{Type: "error", Topic: req.Topic, Error: err.Error()})
+				continue
+			}
+			h.reply(c, wsResponse{Type: "subscribed", Topic: req.Topic})
+		case "unsubscribe":
+			h.unsubscribe(c, req.Topic)
+			h.reply(c, wsResponse{Type: "unsubscribed", Topic: req.Topic})
+		default:
+			h.reply(c, wsResponse{Type: "error", Error: "type must be subscribe or unsubscribe"})
+		}
+	}
+}
+
+// write sends queued messages and pings to a client until it leaves.
+func (h *wsHub) write(c *wsClient) {
+	ping := time.NewTicker(h.cfg.heartbeat)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(h.cfg.writeTimeout))
+			err = c.conn.WriteMessage(websocket.TextMessage, msg)
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(h.cfg.writeTimeout))
+			err = c.conn.Ping(nil)
+		}
+		if err != nil {
+			// Unblocks the reader, which removes the client.
+			c.conn.CloseNow()
+			return
+		}
+	}
+}
+
+// reply queues a response to a client.
+func (h *wsHub) reply(c *wsClient, resp wsResponse) {
+	msg, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.enqueueLocked(c, msg)
+}
+
+// enqueueLocked queues msg for a client, disconnecting clients too slow to
+// keep up rather than holding up the others.
+func (h *wsHub) enqueueLocked(c *wsClient, msg []byte) {
+	select {
+	case c.send <- msg:
+	default:
+		go c.conn.Close(websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+var (
+	errTopicNotAllowed = errors.New("unknown topic")
+	errTooManyTopics   = errors.New("too many subscriptions")
+)
+
+// subscribe adds a client to a topic, subscribing to its channel if the
+// client is the first on this replica.
+func (h *wsHub) subscribe(c *wsClient, topic string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	allowed := h.allowTopic(ctx, c.claims, topic)
+	cancel()
+	if !allowed {
+		return errTopicNotAllowed
+	}
+
+	h.mu.Lock()
+	if _, ok := c.topics[topic]; ok {
+		h.mu.Unlock()
+		return nil
+	}
+	if len(c.topics) >= wsMaxTopics {
+		h.mu.Unlock()
+		return errTooManyTopics
+	}
+	if t, ok := h.topics[topic]; ok {
+		t.clients[c] = struct{}{}
+		c.topics[topic] = struct{}{}
+		h.mu.Unlock()
+		return nil
+	}
+	h.mu.Unlock()
+
+	// Subscribed without the lock, so a slow Redis does not stall the hub.
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	sub, err := h.pubsub.Subscribe(ctx, topic)
+	cancel()
+	if err != nil {
+		h.logger.Warn("websocket topic subscription failed", "topic", topic, "error", err.Error())
+		return errors.New("subscription failed, retry later")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t, ok := h.topics[topic]
+	if ok {
+		// Another client subscribed meanwhile.
+		go sub.Close()
+	} else {
+		t = &wsTopic{sub: sub, clients: make(map[*wsClient]struct{})}
+		h.topics[topic] = t
+		go h.forward(topic, sub)
+	}
+	if _, ok := h.clients[c]; !ok {
+		// The client left meanwhile.
+		if len(t.clients) == 0 {
+			delete(h.topics, topic)
+			go t.sub.Close()
+		}
+		return nil
+	}
+	t.clients[c] = struct{}{}
+	c.topics[topic] = struct{}{}
+	return nil
+}
+
+func (h *wsHub) unsubscribe(c *wsClient, topic string) {
+	h.mu.Lock()
+	sub := h.removeLocked(c, topic)
+	h.mu.Unlock()
+	if sub != nil {
+		sub.Close()
+	}
+}
+
+// removeLocked removes a client from a topic, returning the topic's
+// subscription for the caller to close if no client is left.
+func (h *wsHub) removeLocked(c *wsClient, topic string) database.Subscription {
+	delete(c.topics, topic)
+	t, ok := h.topics[topic]
+	if !ok {
+		return nil
+	}
+	delete(t.clients, c)
+	if len(t.clients) > 0 {
+		return nil
+	}
+	delete(h.topics, topic)
+	return t.sub
+}
+
+// forward sends the messages of a topic's subscription to its clients until
+// the subscription is closed.
+func (h *wsHub) forward(topic string, sub database.Subscription) {
+	for msg := range sub.Messages() {
+		data := json.RawMessage(msg.Payload)
+		if !json.Valid(data) {
+			data, _ = json.Marshal(string(msg.Payload))
+		}
+		out, err := json.Marshal(wsResponse{Type: "message", Topic: topic, Data: data})
+		if err != nil {
+			continue
+		}
+
+		h.mu.Lock()
+		if t, ok := h.topics[topic]; ok && t.sub == sub {
+			for c := range t.clients {
+				h.enqueueLocked(c, out)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// close disconnects every client with CloseGoingAway. It is registered with
+// http.Server.RegisterOnShutdown, since Shutdown does not track hijacked
+// connections.
+func (h *wsHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for c := range h.clients {
+		if c.conn != nil {
+			go c.conn.Close(websocket.CloseGoingAway, "server shutting down")
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/jobs"
+	"gofetch.timwalker.dev/internal/websocket"
+)
+
+func dialWS(t *testing.T, srv *httptest.Server, header http.Header) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+func sendWS(t *testing.T, conn *websocket.Conn, req wsRequest) {
+	t.Helper()
+	msg, _ := json.Marshal(req)
+	if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readWS(t *testing.T, conn *websocket.Conn) wsResponse {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp wsResponse
+	if err := json.Unmarshal(msg, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestWebSocket(t *testing.T) {
+	app := newTestApplication(t)
+	srv := httptest.NewServer(app.registerRoutes())
+	defer srv.Close()
+
+	noClients := func() bool {
+		app.wsHub.mu.Lock()
+		defer app.wsHub.mu.Unlock()
+		return len(app.wsHub.clients) == 0
+	}
+
+	t.Run("counter", func(t *testing.T) {
+		conn := dialWS(t, srv, nil)
+		sendWS(t, conn, wsRequest{Type: "subscribe", Topic: "counter"})
+		if resp := readWS(t, conn); resp.Type != "subscribed" || resp.Topic != "counter" {
+			t.Fatalf("unexpected response %+v", resp)
+		}
+
+		resp, err := http.Get(srv.URL + "/counter")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		msg := readWS(t, conn)
+		if msg.Type != "message" || msg.Topic != "counter" || string(msg.Data) != "1" {
+			t.Errorf("unexpected message %+v", msg)
+		}
+
+		sendWS(t, conn, wsRequest{Type: "unsubscribe", Topic: "counter"})
+		if resp := readWS(t, conn); resp.Type != "unsubscribed" {
+			t.Fatalf("unexpected response %+v", resp)
+		}
+		waitFor(t, func() bool { return app.db.(*MockDB).NumSub("counter") == 1 })
+	})
+
+	t.Run("job", func(t *testing.T) {
+		conn := dialWS(t, srv, nil)
+		id := uuid.NewString()
+		if err := app.db.Jobs().Create(context.Background(), &database.Job{ID: id, Type: "fibonacci"}); err != nil {
+			t.Fatal(err)
+		}
+		sendWS(t, conn, wsRequest{Type: "subscribe", Topic: jobs.Channel(id)})
+		if resp := readWS(t, conn); resp.Type != "subscribed" {
+			t.Fatalf("unexpected response %+v", resp)
+		}
+
+		event, _ := json.Marshal(jobs.Event{ID: id, Status: "succeeded", Progress: 1})
+		app.db.PubSub().Publish(context.Background(), jobs.Channel(id), event)
+
+		var got jobs.Event
+		msg := readWS(t, conn)
+		if err := json.Unmarshal(msg.Data, &got); err != nil || got.ID != id || got.Status != "succeeded" {
+			t.Errorf("unexpected message %+v", msg)
+		}
+	})
+
+	t.Run("invalid_requests", func(t *testing.T) {
+		conn := dialWS(t, srv, nil)
+		for _, req := range []wsRequest{
+			{Type: "subscribe", Topic: "jobs:not-a-uuid"},
+			{Type: "subscribe", Topic: jobs.Channel(uuid.NewString())},
+			{Type: "subscribe", Topic: "albums"},
+			{Type: "publish", Topic: "counter"},
+		} {
+			sendWS(t, conn, req)
+			if resp := readWS(t, conn); resp.Type != "error" || resp.Error == "" {
+				t.Errorf("%+v: unexpected response %+v", req, resp)
+			}
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+		if resp := readWS(t, conn); resp.Type != "error" {
+			t.Errorf("unexpected response %+v", resp)
+		}
+	})
+
+	t.Run("not_an_upgrade", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/ws")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUpgradeRequired || resp.Header.Get("Upgrade") != "websocket" {
+			t.Errorf("got status %v, Upgrade %q", resp.StatusCode, resp.Header.Get("Upgrade"))
+		}
+	})
+
+	t.Run("origin", func(t *testing.T) {
+		_, resp, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws",
+			http.Header{"Origin": {"https://evil.example"}})
+		if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expected a forbidden response, got %v", err)
+		}
+	})
+
+	t.Run("max_clients", func(t *testing.T) {
+		// Clients of earlier tests leave once their connections are closed.
+		waitFor(t, noClients)
+		for range app.config.ws.maxClients {
+			dialWS(t, srv, nil)
+		}
+		_, resp, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("expected an overloaded response, got %v", err)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		waitFor(t, noClients)
+		conn := dialWS(t, srv, nil)
+		sendWS(t, conn, wsRequest{Type: "subscribe", Topic: "counter"})
+		readWS(t, conn)
+
+		app.wsHub.close()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("expected CloseGoingAway, got %v", err)
+		}
+		waitFor(t, func() bool { return app.db.(*MockDB).NumSub("counter") == 1 })
+	})
+}
+
+func TestWebSocketJobOwnership(t *testing.T) {
+	app := newTestAuthApplication(t)
+	app.config.auth.required = false
+	srv := httptest.NewServer(app.registerRoutes())
+	defer srv.Close()
+
+	id := uuid.NewString()
+	if err := app.db.Jobs().Create(context.Background(), &database.Job{ID: id, Type: "fibonacci", Owner: "user-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		header   http.Header
+		expected string
+	}{
+		{"anonymous", nil, "error"},
+		{"other", http.Header{"Authorization": {"Bearer " + newTestToken(t, map[string]any{"sub": "user-2"})}}, "error"},
+		{"owner", http.Header{"Authorization": {"Bearer " + newTestToken(t, map[string]any{"sub": "user-1"})}}, "subscribed"},
+	} {
+		conn := dialWS(t, srv, tt.header)
+		sendWS(t, conn, wsRequest{Type: "subscribe", Topic: jobs.Channel(id)})
+		if resp := readWS(t, conn); resp.Type != tt.expected {
+			t.Errorf("%s: unexpected response %+v", tt.name, resp)
+		}
+	}
+}
@@ -7,7 +7,8 @@ tool honnef.co/go/tools/cmd/staticcheck
 require (
 	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
-	github.com/redis/go-redis/v9 v9.8.0
+	github.com/klauspost/compress v1.18.0
+	github.com/redis/go-redis/v9 v9.9.0
 )
 
 require (
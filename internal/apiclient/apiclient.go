@@ -18,9 +18,16 @@ var httpClient = &http.Client{
 	Timeout: 30 * time.Second, // Default timeout
 }
 
+// Defaults of the circuit breaker created by NewClient.
+const (
+	defaultBreakerThreshold   = 5
+	defaultBreakerOpenTimeout = 30 * time.Second
+)
+
 type APIClient struct {
 	baseURL    *url.URL
 	httpClient *http.Client
+	breaker    *CircuitBreaker
 }
 
 // NewClient creates a new instance of the Open API client.
@@ -40,9 +47,36 @@ func NewClient(baseUrl string, client *http.Client) (*APIClient, error) {
 	return &APIClient{
 		baseURL:    baseURL,
 		httpClient: client,
+		breaker:    NewCircuitBreaker(defaultBreakerThreshold, defaultBreakerOpenTimeout),
 	}, nil
 }
 
+// Breaker returns the circuit breaker guarding requests to the host.
+func (c *APIClient) Breaker() *CircuitBreaker {
+	return c.breaker
+}
+
+// Ping reports whether the host is reachable by requesting the base URL. Any
+// response below 500 counts as reachable. Ping bypasses the circuit breaker
+// so health checks can tell when the host recovers.
+func (c *APIClient) Ping(ctx context.Context) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", c.baseURL.Host, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s responded with status %d", c.baseURL.Host, resp.StatusCode)
+	}
+	return nil
+}
+
 // newRequest creates an API request. A relative URL path can be provided in
 // path, in which case it is resolved relative to the baseURL of the Client.
 // Relative paths should always be specified without a preceding slash.
@@ -92,19 +126,33 @@ func (c *APIClient) newRequest(ctx context.Context, method, path string, body an
 // interface, the raw response body will be written to v, without attempting to
 // first decode it.
 func (c *APIClient) do(req *http.Request, v any) (*http.Response, error) {
+	// Fail fast while the upstream is known to be failing.
+	if err := c.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
 	// Execute the request using the configured http client.
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		// If the context was canceled, return that error.
 		select {
 		case <-req.Context().Done():
+			c.breaker.Cancel()
 			return nil, req.Context().Err()
 		default:
 		}
+		c.breaker.Failure()
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer resp.Body.Close() // Ensure the response body is closed.
 
+	// Server errors count against the upstream; client errors do not.
+	if resp.StatusCode >= 500 {
+		c.breaker.Failure()
+	} else {
+		c.breaker.Success()
+	}
+
 	// Check for non-success status codes.
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		// Attempt to read the error body for more context, but don't fail if reading fails.
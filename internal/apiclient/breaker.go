@@ -0,0 +1,116 @@
+package apiclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of sending a request while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("apiclient: circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreaker.
+type BreakerState string
+
+const (
+	// BreakerClosed lets every request through.
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen rejects requests until the open timeout passes.
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single trial request through; its outcome
+	// closes or reopens the breaker.
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// CircuitBreaker stops sending requests to an upstream after consecutive
+// failures, so a failing upstream is not hammered and callers fail fast.
+type CircuitBreaker struct {
+	threshold   int
+	openTimeout time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	// trial is set while the half-open trial request is in flight.
+	trial bool
+}
+
+// NewCircuitBreaker returns a closed breaker that opens after threshold
+// consecutive failures and allows a trial request after openTimeout.
+func NewCircuitBreaker(threshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold:   max(threshold, 1),
+		openTimeout: openTimeout,
+		now:         time.Now,
+		state:       BreakerClosed,
+	}
+}
+
+// State returns the current state, moving an open breaker to half-open once
+// its timeout has passed.
+func (b *CircuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked()
+}
+
+func (b *CircuitBreaker) stateLocked() BreakerState {
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.openTimeout {
+		b.state = BreakerHalfOpen
+		b.trial = false
+	}
+	return b.state
+}
+
+// Allow reports whether a request may be sent. Callers that are allowed must
+// report the outcome with Success or Failure.
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.stateLocked() {
+	case BreakerOpen:
+		return ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+	}
+	return nil
+}
+
+// Success records a successful request, closing the breaker.
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure records a failed request, opening the breaker after threshold
+// consecutive failures or a failed trial request.
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		b.trial = false
+	}
+}
+
+// Cancel records a request whose outcome says nothing about the upstream,
+// such as one canceled by the caller, freeing a half-open trial slot.
+func (b *CircuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
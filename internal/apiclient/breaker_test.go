@@ -0,0 +1,90 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+
+	b.Allow()
+	b.Failure()
+	if b.State() != BreakerClosed {
+		t.Fatalf("expected closed after 1 failure, got %s", b.State())
+	}
+	b.Allow()
+	b.Failure()
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) || b.State() != BreakerOpen {
+		t.Fatalf("expected open after 2 failures, got %s %v", b.State(), err)
+	}
+
+	now = now.Add(time.Minute)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("expected half-open after the timeout, got %s", b.State())
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a trial request, got %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected one trial request at a time, got %v", err)
+	}
+
+	b.Failure()
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected a failed trial to reopen, got %s", b.State())
+	}
+
+	now = now.Add(time.Minute)
+	b.Allow()
+	b.Success()
+	if b.State() != BreakerClosed {
+		t.Fatalf("expected a successful trial to close, got %s", b.State())
+	}
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	calls := 0
+	server, client := setupTestServer(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer server.Close()
+
+	for range defaultBreakerThreshold {
+		client.Get(context.Background(), "/", nil)
+	}
+	if _, err := client.Get(context.Background(), "/", nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if calls != defaultBreakerThreshold {
+		t.Errorf("expected %d upstream calls, got %d", defaultBreakerThreshold, calls)
+	}
+	if client.Breaker().State() != BreakerOpen {
+		t.Errorf("expected the breaker to be open, got %s", client.Breaker().State())
+	}
+}
+
+func TestClient_Ping(t *testing.T) {
+	status := http.StatusNotFound
+	server, client := setupTestServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	})
+
+	if err := client.Ping(context.Background()); err != nil {
+		t.Errorf("expected a 404 to be reachable, got %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("expected an error for a 503")
+	}
+	server.Close()
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("expected an error for a closed server")
+	}
+}
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// KeySet is a static set of public keys indexed by key ID.
+type KeySet map[string]crypto.PublicKey
+
+// Key returns the key for kid. A token without a kid matches the only key in
+// a single-key set.
+func (ks KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := ks[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(ks) == 1 {
+		for _, key := range ks {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: kid %q", ErrUnknownKey, kid)
+}
+
+// ParseJWKS parses a JSON Web Key Set document. Keys not used for
+// signatures or of unsupported types are skipped.
+func ParseJWKS(data []byte) (KeySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	ks := make(KeySet, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwk %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			ks[k.Kid] = key
+		}
+	}
+	return ks, nil
+}
+
+// LoadJWKSFile reads and parses a JWKS from a local file.
+func LoadJWKSFile(path string) (KeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinate length")
+		}
+		// ecdh validates that the point is on the curve.
+		point := append([]byte{4}, append(x, y...)...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+
+	default:
+		return nil, nil
+	}
+}
+
+// RemoteKeySet fetches a JWKS from a URL and caches it. The set is refreshed
+// after the refresh interval, or sooner when a token references an unknown
+// key ID, at most once per minRefresh to stop bad tokens forcing fetches.
+// Concurrent callers share one fetch, which runs outside the lock and is not
+// canceled when a caller gives up.
+type RemoteKeySet struct {
+	url        string
+	client     *http.Client
+	refresh    time.Duration
+	minRefresh time.Duration
+
+	mu        sync.Mutex
+	keys      KeySet
+	fetched   time.Time
+	attempted time.Time
+	inflight  *jwksFetch
+}
+
+// jwksFetch is a fetch in progress; err is set before done is closed.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// jwksFetchTimeout bounds each fetch, which is detached from the context of
+// the request that started it.
+const jwksFetchTimeout = 10 * time.Second
+
+// NewRemoteKeySet creates a key set for the JWKS at url. Keys are fetched on
+// first use.
+func NewRemoteKeySet(url string, client *http.Client, refresh time.Duration) *RemoteKeySet {
+	if client == nil {
+		client = &http.Client{Timeout: jwksFetchTimeout}
+	}
+	return &RemoteKeySet{
+		url:        url,
+		client:     client,
+		refresh:    refresh,
+		minRefresh: time.Minute,
+	}
+}
+
+// Key returns the key for kid, fetching the JWKS when needed.
+func (r *RemoteKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	r.mu.Lock()
+	keys := r.keys
+	stale := time.Since(r.fetched) > r.refresh && time.Since(r.attempted) > r.minRefresh
+	r.mu.Unlock()
+
+	if keys == nil || stale {
+		// A failed refresh keeps serving the previously fetched keys.
+		if err := r.refreshKeys(ctx); err != nil && keys == nil {
+			return nil, err
+		}
+		keys = r.currentKeys()
+	}
+
+	key, err := keys.Key(ctx, kid)
+	if errors.Is(err, ErrUnknownKey) && r.canRefresh() {
+		if err := r.refreshKeys(ctx); err != nil {
+			return nil, err
+		}
+		return r.currentKeys().Key(ctx, kid)
+	}
+	return key, err
+}
+
+func (r *RemoteKeySet) currentKeys() KeySet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.keys
+}
+
+func (r *RemoteKeySet) canRefresh() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Since(r.attempted) > r.minRefresh
+}
+
+// refreshKeys starts a fetch unless one is in progress, and waits for it or
+// for ctx to be done.
+func (r *RemoteKeySet) refreshKeys(ctx context.Context) error {
+	r.mu.Lock()
+	f := r.inflight
+	if f == nil {
+		f = &jwksFetch{done: make(chan struct{})}
+		r.inflight = f
+		r.attempted = time.Now()
+		go r.fetch(f)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fetch fetches the JWKS and swaps in its keys, then completes f.
+func (r *RemoteKeySet) fetch(f *jwksFetch) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	keys, err := r.get(ctx)
+
+	r.mu.Lock()
+	if err == nil {
+		r.keys = keys
+		r.fetched = time.Now()
+	}
+	r.inflight = nil
+	r.mu.Unlock()
+
+	f.err = err
+	close(f.done)
+}
+
+func (r *RemoteKeySet) get(ctx context.Context) (KeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	return ParseJWKS(data)
+}
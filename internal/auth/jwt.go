@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken       = errors.New("malformed token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrInvalidSignature     = errors.New("invalid token signature")
+	ErrUnknownKey           = errors.New("unknown signing key")
+	ErrTokenExpired         = errors.New("token is expired")
+	ErrTokenNotYetValid     = errors.New("token is not valid yet")
+	ErrInvalidIssuer        = errors.New("invalid token issuer")
+	ErrInvalidAudience      = errors.New("invalid token audience")
+)
+
+// Claims are the registered JWT claims plus the roles and scopes used for
+// authorization.
+type Claims struct {
+	Subject   string
+	Issuer    string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	Roles     []string
+	Scopes    []string
+	// Raw holds every claim in the token payload.
+	Raw map[string]any
+}
+
+// HasRole reports whether the claims include role.
+func (c *Claims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// HasScope reports whether the claims include scope.
+func (c *Claims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes, scope)
+}
+
+// KeySource resolves the public key for a key ID, e.g. from a JWKS.
+type KeySource interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// Config configures token verification. HMACSecret enables HS256 and Keys
+// enables RS256 and ES256; at least one is required.
+type Config struct {
+	HMACSecret []byte
+	Keys       KeySource
+	// Issuer and Audience are checked against the iss and aud claims when set.
+	Issuer   string
+	Audience string
+	// Leeway allows for clock skew when checking exp and nbf.
+	Leeway time.Duration
+}
+
+// Verifier validates signed JWTs.
+type Verifier struct {
+	cfg Config
+	now func() time.Time
+}
+
+// NewVerifier creates a Verifier from cfg.
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if len(cfg.HMACSecret) == 0 && cfg.Keys == nil {
+		return nil, errors.New("auth: an HMAC secret or key source is required")
+	}
+	return &Verifier{cfg: cfg, now: time.Now}, nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// Verify checks the token signature and the exp, nbf, iss and aud claims and
+// returns the token claims.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrMalformedToken, err)
+	}
+
+	if err := v.verifySignature(ctx, h, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, err
+	}
+
+	claims, err := parseClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// verifySignature checks the signature using the key type required by the
+// algorithm, so a token cannot pick a weaker algorithm than the key allows.
+func (v *Verifier) verifySignature(ctx context.Context, h header, signingInput string, signature []byte) error {
+	switch h.Alg {
+	case "HS256":
+		if len(v.cfg.HMACSecret) == 0 {
+			return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, h.Alg)
+		}
+		mac := hmac.New(sha256.New, v.cfg.HMACSecret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrInvalidSignature
+		}
+		return nil
+
+	case "RS256", "ES256":
+		if v.cfg.Keys == nil {
+			return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, h.Alg)
+		}
+		key, err := v.cfg.Keys.Key(ctx, h.Kid)
+		if err != nil {
+			return err
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if h.Alg != "RS256" {
+				return fmt.Errorf("%w: %s with RSA key", ErrUnsupportedAlgorithm, h.Alg)
+			}
+			if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+				return ErrInvalidSignature
+			}
+			return nil
+		case *ecdsa.PublicKey:
+			if h.Alg != "ES256" {
+				return fmt.Errorf("%w: %s with EC key", ErrUnsupportedAlgorithm, h.Alg)
+			}
+			if len(signature) != 64 {
+				return ErrInvalidSignature
+			}
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			if !ecdsa.Verify(key, digest[:], r, s) {
+				return ErrInvalidSignature
+			}
+			return nil
+		default:
+			return fmt.Errorf("%w: unsupported key type %T", ErrUnknownKey, key)
+		}
+
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, h.Alg)
+	}
+}
+
+func (v *Verifier) validate(c *Claims) error {
+	now := v.now()
+
+	if c.ExpiresAt.IsZero() || !now.Before(c.ExpiresAt.Add(v.cfg.Leeway)) {
+		return ErrTokenExpired
+	}
+	if !c.NotBefore.IsZero() && now.Add(v.cfg.Leeway).Before(c.NotBefore) {
+		return ErrTokenNotYetValid
+	}
+	if v.cfg.Issuer != "" && c.Issuer != v.cfg.Issuer {
+		return ErrInvalidIssuer
+	}
+	if v.cfg.Audience != "" && !slices.Contains(c.Audience, v.cfg.Audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+	return nil
+}
+
+// parseClaims maps the raw payload onto Claims. The aud claim may be a
+// string or an array, and scopes may be a space-delimited "scope" string or
+// an "scp" array.
+func parseClaims(raw map[string]any) (*Claims, error) {
+	c := &Claims{Raw: raw}
+	var err error
+
+	c.Subject, _ = raw["sub"].(string)
+	c.Issuer, _ = raw["iss"].(string)
+
+	if c.Audience, err = stringList(raw["aud"]); err != nil {
+		return nil, fmt.Errorf("%w: aud: %v", ErrMalformedToken, err)
+	}
+	if c.Roles, err = stringList(raw["roles"]); err != nil {
+		return nil, fmt.Errorf("%w: roles: %v", ErrMalformedToken, err)
+	}
+	if scope, ok := raw["scope"].(string); ok {
+		c.Scopes = strings.Fields(scope)
+	} else if c.Scopes, err = stringList(raw["scp"]); err != nil {
+		return nil, fmt.Errorf("%w: scp: %v", ErrMalformedToken, err)
+	}
+
+	for name, dst := range map[string]*time.Time{"exp": &c.ExpiresAt, "nbf": &c.NotBefore, "iat": &c.IssuedAt} {
+		switch n := raw[name].(type) {
+		case nil:
+		case float64:
+			*dst = time.Unix(int64(n), 0)
+		default:
+			return nil, fmt.Errorf("%w: %s must be a number", ErrMalformedToken, name)
+		}
+	}
+
+	return c, nil
+}
+
+func stringList(v any) ([]string, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{v}, nil
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string, got %T", item)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("expected string or array, got %T", v)
+	}
+}
@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// sign creates a compact JWT signed with key for alg.
+func sign(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+
+	h, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	p, _ := json.Marshal(claims)
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(p)
+	digest := sha256.Sum256([]byte(input))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	default:
+		t.Fatalf("unsupported key type %T", key)
+	}
+
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub":   "user-1",
+		"iss":   "https://issuer.test",
+		"aud":   []string{"gofetch", "other"},
+		"exp":   testNow.Add(time.Hour).Unix(),
+		"nbf":   testNow.Add(-time.Minute).Unix(),
+		"roles": []string{"admin"},
+		"scope": "albums:read albums:write",
+	}
+}
+
+func newTestVerifier(t *testing.T, cfg Config) *Verifier {
+	t.Helper()
+	cfg.Issuer = "https://issuer.test"
+	cfg.Audience = "gofetch"
+	v, err := NewVerifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("test-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := KeySet{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}
+	v := newTestVerifier(t, Config{HMACSecret: secret, Keys: keys})
+
+	t.Run("valid_tokens", func(t *testing.T) {
+		tokens := map[string]string{
+			"HS256": sign(t, "HS256", "", secret, validClaims()),
+			"RS256": sign(t, "RS256", "rsa", rsaKey, validClaims()),
+			"ES256": sign(t, "ES256", "ec", ecKey, validClaims()),
+		}
+		for alg, token := range tokens {
+			claims, err := v.Verify(context.Background(), token)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", alg, err)
+				continue
+			}
+			if claims.Subject != "user-1" {
+				t.Errorf("%s: subject got %q want %q", alg, claims.Subject, "user-1")
+			}
+			if !claims.HasRole("admin") || !claims.HasScope("albums:write") {
+				t.Errorf("%s: missing roles or scopes: %+v", alg, claims)
+			}
+		}
+	})
+
+	tests := []struct {
+		name   string
+		token  func() string
+		expect error
+	}{
+		{"expired", func() string {
+			c := validClaims()
+			c["exp"] = testNow.Add(-time.Minute).Unix()
+			return sign(t, "HS256", "", secret, c)
+		}, ErrTokenExpired},
+		{"missing_exp", func() string {
+			c := validClaims()
+			delete(c, "exp")
+			return sign(t, "HS256", "", secret, c)
+		}, ErrTokenExpired},
+		{"not_yet_valid", func() string {
+			c := validClaims()
+			c["nbf"] = testNow.Add(time.Minute).Unix()
+			return sign(t, "HS256", "", secret, c)
+		}, ErrTokenNotYetValid},
+		{"wrong_issuer", func() string {
+			c := validClaims()
+			c["iss"] = "https://evil.test"
+			return sign(t, "HS256", "", secret, c)
+		}, ErrInvalidIssuer},
+		{"wrong_audience", func() string {
+			c := validClaims()
+			c["aud"] = "someone-else"
+			return sign(t, "HS256", "", secret, c)
+		}, ErrInvalidAudience},
+		{"bad_hmac_signature", func() string {
+			return sign(t, "HS256", "", []byte("wrong"), validClaims())
+		}, ErrInvalidSignature},
+		{"unknown_kid", func() string {
+			return sign(t, "RS256", "missing", rsaKey, validClaims())
+		}, ErrUnknownKey},
+		{"algorithm_does_not_match_key", func() string {
+			return sign(t, "ES256", "rsa", ecKey, validClaims())
+		}, ErrUnsupportedAlgorithm},
+		{"alg_none", func() string {
+			h := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+			p, _ := json.Marshal(validClaims())
+			return h + "." + base64.RawURLEncoding.EncodeToString(p) + "."
+		}, ErrUnsupportedAlgorithm},
+		{"malformed", func() string { return "not-a-token" }, ErrMalformedToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(context.Background(), tt.token())
+			if !errors.Is(err, tt.expect) {
+				t.Errorf("got error %v want %v", err, tt.expect)
+			}
+		})
+	}
+
+	t.Run("leeway_allows_clock_skew", func(t *testing.T) {
+		v := newTestVerifier(t, Config{HMACSecret: secret, Leeway: 2 * time.Minute})
+		c := validClaims()
+		c["exp"] = testNow.Add(-time.Minute).Unix()
+		if _, err := v.Verify(context.Background(), sign(t, "HS256", "", secret, c)); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+	doc := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"r1","use":"sig","n":%q,"e":%q},
+		{"kty":"EC","kid":"e1","crv":"P-256","x":%q,"y":%q},
+		{"kty":"RSA","kid":"enc","use":"enc","n":%q,"e":"AQAB"},
+		{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"AA"}
+	]}`,
+		b64(rsaKey.N.Bytes()), b64(big.NewInt(int64(rsaKey.E)).Bytes()),
+		b64(ecKey.X.FillBytes(make([]byte, 32))), b64(ecKey.Y.FillBytes(make([]byte, 32))),
+		b64(rsaKey.N.Bytes()),
+	)
+
+	ks, err := ParseJWKS([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks) != 2 {
+		t.Fatalf("expected 2 signing keys, got %d", len(ks))
+	}
+
+	v := newTestVerifier(t, Config{Keys: ks})
+	for kid, key := range map[string]any{"r1": rsaKey, "e1": ecKey} {
+		alg := "RS256"
+		if kid == "e1" {
+			alg = "ES256"
+		}
+		if _, err := v.Verify(context.Background(), sign(t, alg, kid, key, validClaims())); err != nil {
+			t.Errorf("%s: unexpected error: %v", kid, err)
+		}
+	}
+
+	t.Run("rejects_point_off_curve", func(t *testing.T) {
+		doc := fmt.Sprintf(`{"keys":[{"kty":"EC","kid":"bad","crv":"P-256","x":%q,"y":%q}]}`,
+			b64(make([]byte, 32)), b64(make([]byte, 32)))
+		if _, err := ParseJWKS([]byte(doc)); err == nil {
+			t.Error("expected an error for an invalid EC point")
+		}
+	})
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprintf(w, `{"keys":[{"kty":"EC","kid":"e1","crv":"P-256","x":%q,"y":%q}]}`,
+			base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+			base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))))
+	}))
+	defer server.Close()
+
+	ks := NewRemoteKeySet(server.URL, nil, time.Hour)
+	v := newTestVerifier(t, Config{Keys: ks})
+
+	for range 2 {
+		if _, err := v.Verify(context.Background(), sign(t, "ES256", "e1", ecKey, validClaims())); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, err := v.Verify(context.Background(), sign(t, "ES256", "unknown", ecKey, validClaims())); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("got error %v want %v", err, ErrUnknownKey)
+	}
+	if fetches != 1 {
+		t.Errorf("expected keys to be fetched once, got %d", fetches)
+	}
+}
+
+func TestRemoteKeySetSharedFetch(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		fmt.Fprintf(w, `{"keys":[{"kty":"EC","kid":"e1","crv":"P-256","x":%q,"y":%q}]}`,
+			base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+			base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))))
+	}))
+	defer server.Close()
+
+	ks := NewRemoteKeySet(server.URL, nil, time.Hour)
+
+	// A caller giving up does not cancel the fetch for the others.
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := ks.Key(ctx, "e1")
+		canceled <- err
+	}()
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-canceled; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled caller: got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ks.Key(context.Background(), "e1")
+			errs <- err
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected one shared fetch, got %d", n)
+	}
+}
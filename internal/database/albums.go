@@ -0,0 +1,288 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Album is a photo album owned by a user. Version starts at 1 and is
+// incremented by every update.
+type Album struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"userId" validate:"min=1"`
+	Title     string    `json:"title" validate:"required,max=200"`
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// AlbumFilter narrows and pages the albums returned by List. Zero values
+// match every album and return them all.
+type AlbumFilter struct {
+	UserID int
+	// Desc orders albums by descending instead of ascending ID.
+	Desc bool
+	// AfterID, if not zero, starts the list after the album with that ID;
+	// otherwise the list starts at Offset.
+	AfterID int
+	Offset  int
+	// Limit is the most albums returned, or zero for no limit.
+	Limit int
+}
+
+// AlbumRepository stores albums. Implementations assign IDs on Create and
+// return ErrNotFound for missing albums. Create and Update set the Version
+// and UpdatedAt of the album.
+type AlbumRepository interface {
+	Create(ctx context.Context, album *Album) error
+	Get(ctx context.Context, id int) (*Album, error)
+	// List returns a page of the albums matching filter ordered by ID, and
+	// the number of albums matching filter.UserID across all pages.
+	List(ctx context.Context, filter AlbumFilter) ([]*Album, int, error)
+	// Update stores album if its Version is still the stored version, and
+	// returns ErrVersionConflict otherwise.
+	Update(ctx context.Context, album *Album) error
+	// Delete removes the album if version is still the stored version, and
+	// returns ErrVersionConflict otherwise.
+	Delete(ctx context.Context, id, version int) error
+}
+
+// redisAlbumRepository stores each album as a hash, indexed by ID in a
+// sorted set and by owner in a sorted set per userId. Every key shares the
+// {albums} hash tag so scripts can update them atomically in Redis Cluster.
+type redisAlbumRepository struct {
+	db redis.UniversalClient
+}
+
+const (
+	albumsIndex          = "{albums}:ids"
+	albumsNextID         = "{albums}:next_id"
+	albumKeyPrefix       = "{albums}:album:"
+	albumUserIndexPrefix = "{albums}:user:"
+)
+
+func albumKey(id int) string         { return albumKeyPrefix + strconv.Itoa(id) }
+func albumUserKey(userID int) string { return albumUserIndexPrefix + strconv.Itoa(userID) }
+
+// updateAlbumScript replaces the album fields if the stored version matches,
+// increments the version, and moves the album between owner indexes when the
+// userId changes. The owner is read before the script runs so that both
+// owner indexes can be declared in KEYS; a different stored owner means the
+// album was updated since, so it is reported as a version conflict.
+// KEYS: album key, index of the owner read, index of the new owner. ARGV:
+// id, userId, title, owner read, expected version, updatedAt.
+// Returns 0 when the album does not exist, -1 on a version conflict, and the
+// new version otherwise.
+var updateAlbumScript = redis.NewScript(`
+local current = redis.call("HMGET", KEYS[1], "userId", "version")
+local old = current[1]
+if not old then
+	return 0
+end
+if old ~= ARGV[4] or tonumber(current[2]) ~= tonumber(ARGV[5]) then
+	return -1
+end
+local version = redis.call("HINCRBY", KEYS[1], "version", 1)
+redis.call("HSET", KEYS[1], "id", ARGV[1], "userId", ARGV[2], "title", ARGV[3], "updatedAt", ARGV[6])
+if old ~= ARGV[2] then
+	redis.call("ZREM", KEYS[2], ARGV[1])
+	redis.call("ZADD", KEYS[3], ARGV[1], ARGV[1])
+end
+return version
+`)
+
+// deleteAlbumScript removes the album and its index entries if the stored
+// version matches. As in updateAlbumScript, a stored owner other than the one
+// read before the script ran means the album was updated since.
+// KEYS: album key, albums index, owner index. ARGV: id, owner read, expected
+// version.
+// Returns 0 when the album does not exist, -1 on a version conflict, and 1
+// otherwise.
+var deleteAlbumScript = redis.NewScript(`
+local current = redis.call("HMGET", KEYS[1], "userId", "version")
+local owner = current[1]
+if not owner then
+	return 0
+end
+if owner ~= ARGV[2] or tonumber(current[2]) ~= tonumber(ARGV[3]) then
+	return -1
+end
+redis.call("DEL", KEYS[1])
+redis.call("ZREM", KEYS[2], ARGV[1])
+redis.call("ZREM", KEYS[3], ARGV[1])
+return 1
+`)
+
+// albumOwner returns the stored userId of the album, or ErrNotFound.
+func (r *redisAlbumRepository) albumOwner(ctx context.Context, id int) (int, error) {
+	owner, err := r.db.HGet(ctx, albumKey(id), "userId").Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrNotFound
+	}
+	return owner, err
+}
+
+// Albums returns the Redis backed album repository.
+func (s *service) Albums() AlbumRepository {
+	return &redisAlbumRepository{db: s.db}
+}
+
+func (r *redisAlbumRepository) Create(ctx context.Context, album *Album) error {
+	id, err := r.db.Incr(ctx, albumsNextID).Result()
+	if err != nil {
+		return err
+	}
+	album.ID = int(id)
+	album.Version = 1
+	album.UpdatedAt = time.Now().UTC()
+
+	member := redis.Z{Score: float64(album.ID), Member: album.ID}
+	_, err = r.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, albumKey(album.ID), "id", album.ID, "userId", album.UserID, "title", album.Title,
+			"version", album.Version, "updatedAt", album.UpdatedAt.Format(time.RFC3339Nano))
+		pipe.ZAdd(ctx, albumsIndex, member)
+		pipe.ZAdd(ctx, albumUserKey(album.UserID), member)
+		return nil
+	})
+	return err
+}
+
+func (r *redisAlbumRepository) Get(ctx context.Context, id int) (*Album, error) {
+	fields, err := r.db.HGetAll(ctx, albumKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrNotFound
+	}
+	return decodeAlbum(fields)
+}
+
+func (r *redisAlbumRepository) List(ctx context.Context, filter AlbumFilter) ([]*Album, int, error) {
+	index := albumsIndex
+	if filter.UserID != 0 {
+		index = albumUserKey(filter.UserID)
+	}
+
+	// The index scores are the IDs, so pages are ranges of scores.
+	args := redis.ZRangeArgs{Key: index, Start: "-inf", Stop: "+inf", ByScore: true, Rev: filter.Desc, Count: -1}
+	switch {
+	case filter.AfterID != 0 && filter.Desc:
+		args.Stop = "(" + strconv.Itoa(filter.AfterID)
+	case filter.AfterID != 0:
+		args.Start = "(" + strconv.Itoa(filter.AfterID)
+	default:
+		args.Offset = int64(filter.Offset)
+	}
+	if filter.Limit > 0 {
+		args.Count = int64(filter.Limit)
+	}
+
+	var idsCmd *redis.StringSliceCmd
+	var totalCmd *redis.IntCmd
+	_, err := r.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		idsCmd = pipe.ZRangeArgs(ctx, args)
+		totalCmd = pipe.ZCard(ctx, index)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := idsCmd.Val()
+	if len(ids) == 0 {
+		return []*Album{}, int(totalCmd.Val()), nil
+	}
+
+	cmds, err := r.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			pipe.HGetAll(ctx, albumKeyPrefix+id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	albums := make([]*Album, 0, len(cmds))
+	for _, cmd := range cmds {
+		fields := cmd.(*redis.MapStringStringCmd).Val()
+		if len(fields) == 0 {
+			continue
+		}
+		album, err := decodeAlbum(fields)
+		if err != nil {
+			return nil, 0, err
+		}
+		albums = append(albums, album)
+	}
+	return albums, int(totalCmd.Val()), nil
+}
+
+func (r *redisAlbumRepository) Update(ctx context.Context, album *Album) error {
+	owner, err := r.albumOwner(ctx, album.ID)
+	if err != nil {
+		return err
+	}
+
+	updatedAt := time.Now().UTC()
+	version, err := updateAlbumScript.Run(ctx, r.db,
+		[]string{albumKey(album.ID), albumUserKey(owner), albumUserKey(album.UserID)},
+		album.ID, album.UserID, album.Title, owner, album.Version,
+		updatedAt.Format(time.RFC3339Nano)).Int()
+	if err != nil {
+		return err
+	}
+	switch version {
+	case 0:
+		return ErrNotFound
+	case -1:
+		return ErrVersionConflict
+	}
+
+	album.Version = version
+	album.UpdatedAt = updatedAt
+	return nil
+}
+
+func (r *redisAlbumRepository) Delete(ctx context.Context, id, version int) error {
+	owner, err := r.albumOwner(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := deleteAlbumScript.Run(ctx, r.db, []string{albumKey(id), albumsIndex, albumUserKey(owner)},
+		id, owner, version).Int()
+	if err != nil {
+		return err
+	}
+	switch deleted {
+	case 0:
+		return ErrNotFound
+	case -1:
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func decodeAlbum(fields map[string]string) (*Album, error) {
+	id, err := strconv.Atoi(fields["id"])
+	if err != nil {
+		return nil, fmt.Errorf("album: invalid id: %w", err)
+	}
+	userID, err := strconv.Atoi(fields["userId"])
+	if err != nil {
+		return nil, fmt.Errorf("album %d: invalid userId: %w", id, err)
+	}
+	album := &Album{ID: id, UserID: userID, Title: fields["title"]}
+	if album.Version, err = strconv.Atoi(fields["version"]); err != nil {
+		return nil, fmt.Errorf("album %d: invalid version: %w", id, err)
+	}
+	if album.UpdatedAt, err = time.Parse(time.RFC3339Nano, fields["updatedAt"]); err != nil {
+		return nil, fmt.Errorf("album %d: invalid updatedAt: %w", id, err)
+	}
+	return album, nil
+}
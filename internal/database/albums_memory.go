@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryAlbumRepository is an in-memory AlbumRepository for tests and local
+// development.
+type MemoryAlbumRepository struct {
+	mu     sync.RWMutex
+	albums map[int]Album
+	nextID int
+}
+
+// NewMemoryAlbumRepository returns an empty in-memory repository.
+func NewMemoryAlbumRepository() *MemoryAlbumRepository {
+	return &MemoryAlbumRepository{albums: make(map[int]Album)}
+}
+
+func (m *MemoryAlbumRepository) Create(ctx context.Context, album *Album) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	album.ID = m.nextID
+	album.Version = 1
+	album.UpdatedAt = time.Now().UTC()
+	m.albums[album.ID] = *album
+	return nil
+}
+
+func (m *MemoryAlbumRepository) Get(ctx context.Context, id int) (*Album, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	album, ok := m.albums[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &album, nil
+}
+
+func (m *MemoryAlbumRepository) List(ctx context.Context, filter AlbumFilter) ([]*Album, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	albums := make([]*Album, 0, len(m.albums))
+	for _, album := range m.albums {
+		if filter.UserID != 0 && album.UserID != filter.UserID {
+			continue
+		}
+		albums = append(albums, &album)
+	}
+	slices.SortFunc(albums, func(a, b *Album) int {
+		if filter.Desc {
+			return b.ID - a.ID
+		}
+		return a.ID - b.ID
+	})
+	total := len(albums)
+
+	start := min(filter.Offset, total)
+	if filter.AfterID != 0 {
+		start = slices.IndexFunc(albums, func(a *Album) bool {
+			return filter.Desc && a.ID < filter.AfterID || !filter.Desc && a.ID > filter.AfterID
+		})
+		if start < 0 {
+			start = total
+		}
+	}
+	albums = albums[start:]
+	if filter.Limit > 0 && len(albums) > filter.Limit {
+		albums = albums[:filter.Limit]
+	}
+	return albums, total, nil
+}
+
+func (m *MemoryAlbumRepository) Update(ctx context.Context, album *Album) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.albums[album.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Version != album.Version {
+		return ErrVersionConflict
+	}
+
+	album.Version++
+	album.UpdatedAt = time.Now().UTC()
+	m.albums[album.ID] = *album
+	return nil
+}
+
+func (m *MemoryAlbumRepository) Delete(ctx context.Context, id, version int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.albums[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Version != version {
+		return ErrVersionConflict
+	}
+	delete(m.albums, id)
+	return nil
+}
@@ -0,0 +1,304 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// apiKeyPrefix marks plaintext keys issued by this service so they are easy
+// to identify in logs and secret scanners.
+const apiKeyPrefix = "gf"
+
+// APIKey is the stored metadata for an API key. The plaintext key is never
+// stored, only its SHA-256 hash and a short lookup prefix.
+type APIKey struct {
+	ID         string    `json:"id"`
+	Prefix     string    `json:"prefix"`
+	Hash       string    `json:"-"`
+	Owner      string    `json:"owner"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt,omitzero"`
+	ExpiresAt  time.Time `json:"expiresAt,omitzero"`
+}
+
+// Expired reports whether the key has an expiry that has passed.
+func (k *APIKey) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt)
+}
+
+// APIKeyRepository stores API key metadata.
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *APIKey) error
+	Get(ctx context.Context, id string) (*APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+	// List returns the keys for owner, oldest first.
+	List(ctx context.Context, owner string) ([]*APIKey, error)
+	// ListAll returns every key, oldest first.
+	ListAll(ctx context.Context) ([]*APIKey, error)
+	// Rotate replaces the prefix and hash of the key with id. It returns
+	// ErrVersionConflict if the key was rotated concurrently.
+	Rotate(ctx context.Context, id, prefix, hash string) (*APIKey, error)
+	Delete(ctx context.Context, id string) error
+	TouchLastUsed(ctx context.Context, id string, t time.Time) error
+}
+
+// GenerateAPIKey returns a new plaintext key of the form gf_<prefix>_<secret>
+// along with its lookup prefix and hash.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	p := make([]byte, 6)
+	s := make([]byte, 32)
+	if _, err := rand.Read(p); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(s); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(p)
+	key = apiKeyPrefix + "_" + prefix + "_" + base64.RawURLEncoding.EncodeToString(s)
+	return key, prefix, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex encoded SHA-256 hash of a plaintext key.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseAPIKeyPrefix extracts the lookup prefix from a plaintext key.
+func ParseAPIKeyPrefix(key string) (string, bool) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// redisAPIKeyRepository stores each key as a hash, with a pointer from its
+// prefix for lookups and sorted set indexes of IDs by creation time, overall
+// and per owner. Every key shares the {apikeys} hash tag so transactions
+// work in Redis Cluster.
+type redisAPIKeyRepository struct {
+	db redis.UniversalClient
+}
+
+const apiKeysIndex = "{apikeys}:ids"
+
+func apiKeyKey(id string) string           { return "{apikeys}:key:" + id }
+func apiKeyPrefixKey(prefix string) string { return "{apikeys}:prefix:" + prefix }
+func apiKeyOwnerKey(owner string) string   { return "{apikeys}:owner:" + owner }
+
+// APIKeys returns the Redis backed API key repository.
+func (s *service) APIKeys() APIKeyRepository {
+	return &redisAPIKeyRepository{db: s.db}
+}
+
+func (r *redisAPIKeyRepository) Create(ctx context.Context, key *APIKey) error {
+	created, err := r.db.SetNX(ctx, apiKeyPrefixKey(key.Prefix), key.ID, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return fmt.Errorf("api key prefix %q already exists", key.Prefix)
+	}
+
+	score := float64(key.CreatedAt.UnixMilli())
+	_, err = r.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, apiKeyKey(key.ID), encodeAPIKey(key))
+		pipe.ZAdd(ctx, apiKeysIndex, redis.Z{Score: score, Member: key.ID})
+		pipe.ZAdd(ctx, apiKeyOwnerKey(key.Owner), redis.Z{Score: score, Member: key.ID})
+		return nil
+	})
+	return err
+}
+
+func (r *redisAPIKeyRepository) Get(ctx context.Context, id string) (*APIKey, error) {
+	fields, err := r.db.HGetAll(ctx, apiKeyKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrNotFound
+	}
+	return decodeAPIKey(fields)
+}
+
+func (r *redisAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	id, err := r.db.Get(ctx, apiKeyPrefixKey(prefix)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, id)
+}
+
+func (r *redisAPIKeyRepository) List(ctx context.Context, owner string) ([]*APIKey, error) {
+	return r.list(ctx, apiKeyOwnerKey(owner))
+}
+
+func (r *redisAPIKeyRepository) ListAll(ctx context.Context) ([]*APIKey, error) {
+	return r.list(ctx, apiKeysIndex)
+}
+
+// list returns the keys whose IDs are in the index sorted set.
+func (r *redisAPIKeyRepository) list(ctx context.Context, index string) ([]*APIKey, error) {
+	ids, err := r.db.ZRange(ctx, index, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cmds, err := r.db.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			pipe.HGetAll(ctx, apiKeyKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*APIKey, 0, len(cmds))
+	for _, cmd := range cmds {
+		fields := cmd.(*redis.MapStringStringCmd).Val()
+		if len(fields) == 0 {
+			continue
+		}
+		key, err := decodeAPIKey(fields)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// rotateAPIKeyScript points a new prefix at the key and replaces its prefix
+// and hash, removing the old prefix, if the key still has the prefix read
+// before the script ran. That prefix is read first so its key can be
+// declared in KEYS.
+// KEYS: key hash, new prefix, old prefix. ARGV: id, old prefix, new prefix,
+// hash.
+// Returns 0 when the key does not exist, -1 when it was rotated since it was
+// read, -2 when the new prefix is taken, and 1 otherwise.
+var rotateAPIKeyScript = redis.NewScript(`
+local current = redis.call("HGET", KEYS[1], "prefix")
+if not current then
+	return 0
+end
+if current ~= ARGV[2] then
+	return -1
+end
+if redis.call("SETNX", KEYS[2], ARGV[1]) == 0 then
+	return -2
+end
+redis.call("HSET", KEYS[1], "prefix", ARGV[3], "hash", ARGV[4])
+redis.call("DEL", KEYS[3])
+return 1
+`)
+
+func (r *redisAPIKeyRepository) Rotate(ctx context.Context, id, prefix, hash string) (*APIKey, error) {
+	key, err := r.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := rotateAPIKeyScript.Run(ctx, r.db,
+		[]string{apiKeyKey(id), apiKeyPrefixKey(prefix), apiKeyPrefixKey(key.Prefix)},
+		id, key.Prefix, prefix, hash).Int()
+	if err != nil {
+		return nil, err
+	}
+	switch res {
+	case 0:
+		return nil, ErrNotFound
+	case -1:
+		return nil, ErrVersionConflict
+	case -2:
+		return nil, fmt.Errorf("api key prefix %q already exists", prefix)
+	}
+
+	key.Prefix, key.Hash = prefix, hash
+	return key, nil
+}
+
+func (r *redisAPIKeyRepository) Delete(ctx context.Context, id string) error {
+	key, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, apiKeyKey(id), apiKeyPrefixKey(key.Prefix))
+		pipe.ZRem(ctx, apiKeysIndex, id)
+		pipe.ZRem(ctx, apiKeyOwnerKey(key.Owner), id)
+		return nil
+	})
+	return err
+}
+
+// touchAPIKeyScript sets lastUsedAt only on keys that still exist, so a
+// revoked key is not recreated.
+var touchAPIKeyScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("HSET", KEYS[1], "lastUsedAt", ARGV[1])
+end
+return 0
+`)
+
+func (r *redisAPIKeyRepository) TouchLastUsed(ctx context.Context, id string, t time.Time) error {
+	return touchAPIKeyScript.Run(ctx, r.db, []string{apiKeyKey(id)}, t.UnixMilli()).Err()
+}
+
+func encodeAPIKey(k *APIKey) map[string]any {
+	fields := map[string]any{
+		"id":        k.ID,
+		"prefix":    k.Prefix,
+		"hash":      k.Hash,
+		"owner":     k.Owner,
+		"scopes":    strings.Join(k.Scopes, " "),
+		"createdAt": k.CreatedAt.UnixMilli(),
+	}
+	if !k.LastUsedAt.IsZero() {
+		fields["lastUsedAt"] = k.LastUsedAt.UnixMilli()
+	}
+	if !k.ExpiresAt.IsZero() {
+		fields["expiresAt"] = k.ExpiresAt.UnixMilli()
+	}
+	return fields
+}
+
+func decodeAPIKey(fields map[string]string) (*APIKey, error) {
+	k := &APIKey{
+		ID:     fields["id"],
+		Prefix: fields["prefix"],
+		Hash:   fields["hash"],
+		Owner:  fields["owner"],
+		Scopes: strings.Fields(fields["scopes"]),
+	}
+
+	for name, dst := range map[string]*time.Time{"createdAt": &k.CreatedAt, "lastUsedAt": &k.LastUsedAt, "expiresAt": &k.ExpiresAt} {
+		v, ok := fields[name]
+		if !ok {
+			continue
+		}
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("api key %s: invalid %s: %w", k.ID, name, err)
+		}
+		*dst = time.UnixMilli(ms).UTC()
+	}
+	return k, nil
+}
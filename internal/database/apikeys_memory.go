@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryAPIKeyRepository is an in-memory APIKeyRepository for tests and
+// local development.
+type MemoryAPIKeyRepository struct {
+	mu       sync.RWMutex
+	keys     map[string]*APIKey
+	prefixes map[string]string
+}
+
+// NewMemoryAPIKeyRepository returns an empty in-memory repository.
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{
+		keys:     make(map[string]*APIKey),
+		prefixes: make(map[string]string),
+	}
+}
+
+func (m *MemoryAPIKeyRepository) Create(ctx context.Context, key *APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.prefixes[key.Prefix]; ok {
+		return fmt.Errorf("api key prefix %q already exists", key.Prefix)
+	}
+	m.keys[key.ID] = cloneAPIKey(key)
+	m.prefixes[key.Prefix] = key.ID
+	return nil
+}
+
+func (m *MemoryAPIKeyRepository) Get(ctx context.Context, id string) (*APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneAPIKey(key), nil
+}
+
+func (m *MemoryAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	m.mu.RLock()
+	id, ok := m.prefixes[prefix]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return m.Get(ctx, id)
+}
+
+func (m *MemoryAPIKeyRepository) List(ctx context.Context, owner string) ([]*APIKey, error) {
+	return m.list(func(key *APIKey) bool { return key.Owner == owner }), nil
+}
+
+func (m *MemoryAPIKeyRepository) ListAll(ctx context.Context) ([]*APIKey, error) {
+	return m.list(func(*APIKey) bool { return true }), nil
+}
+
+// list returns copies of the keys matching keep, oldest first.
+func (m *MemoryAPIKeyRepository) list(keep func(*APIKey) bool) []*APIKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]*APIKey, 0, len(m.keys))
+	for _, key := range m.keys {
+		if keep(key) {
+			keys = append(keys, cloneAPIKey(key))
+		}
+	}
+	slices.SortFunc(keys, func(a, b *APIKey) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return keys
+}
+
+func (m *MemoryAPIKeyRepository) Rotate(ctx context.Context, id, prefix, hash string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if _, ok := m.prefixes[prefix]; ok {
+		return nil, fmt.Errorf("api key prefix %q already exists", prefix)
+	}
+
+	delete(m.prefixes, key.Prefix)
+	m.prefixes[prefix] = id
+	key.Prefix, key.Hash = prefix, hash
+
+	return cloneAPIKey(key), nil
+}
+
+func (m *MemoryAPIKeyRepository) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.prefixes, key.Prefix)
+	delete(m.keys, id)
+	return nil
+}
+
+func (m *MemoryAPIKeyRepository) TouchLastUsed(ctx context.Context, id string, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.keys[id]; ok {
+		key.LastUsedAt = t
+	}
+	return nil
+}
+
+// cloneAPIKey copies key so callers cannot mutate stored records.
+func cloneAPIKey(key *APIKey) *APIKey {
+	copied := *key
+	copied.Scopes = slices.Clone(key.Scopes)
+	return &copied
+}
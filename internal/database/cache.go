@@ -0,0 +1,42 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores computed values that can be recreated, such as Fibonacci
+// numbers. Get returns ErrNotFound for missing and expired keys.
+type Cache interface {
+	Get(ctx context.Context, key string) (string, error)
+	// Set stores value under key for ttl; a ttl of 0 keeps it until evicted.
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+}
+
+// redisCache stores each value as a string under cacheKeyPrefix, relying on
+// Redis expiry and eviction.
+type redisCache struct {
+	db redis.UniversalClient
+}
+
+const cacheKeyPrefix = "cache:"
+
+// Cache returns the Redis backed cache.
+func (s *service) Cache() Cache {
+	return &redisCache{db: s.db}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) (string, error) {
+	value, err := c.db.Get(ctx, cacheKeyPrefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return value, err
+}
+
+func (c *redisCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return c.db.Set(ctx, cacheKeyPrefix+key, value, ttl).Err()
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryCache is an in-memory Cache for tests and local development.
+type MemoryCache struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]memoryCacheEntry
+}
+
+type memoryCacheEntry struct {
+	value   string
+	expires time.Time // zero for no expiry
+}
+
+// NewMemoryCache returns an empty in-memory cache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{now: time.Now, entries: make(map[string]memoryCacheEntry)}
+}
+
+func (m *MemoryCache) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if !entry.expires.IsZero() && !m.now().Before(entry.expires) {
+		delete(m.entries, key)
+		return "", ErrNotFound
+	}
+	return entry.value, nil
+}
+
+func (m *MemoryCache) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		entry.expires = m.now().Add(ttl)
+	}
+	m.entries[key] = entry
+	return nil
+}
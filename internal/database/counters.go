@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrCounterOverflow is returned when an update would take a counter past
+// the range of int64.
+var ErrCounterOverflow = errors.New("database: counter overflow")
+
+// Counter is a named integer counter.
+type Counter struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+	// ExpiresAt is when the counter is deleted, zero if it has no TTL.
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// CounterOptions configures a counter update.
+type CounterOptions struct {
+	// TTL, if positive, expires the counter TTL after the update.
+	TTL time.Duration
+	// Window, if positive, makes a fixed-window counter: an update that
+	// finds the counter without an expiry sets it to expire after Window, so
+	// it starts again from zero in the next window. Ignored when TTL is set.
+	Window time.Duration
+}
+
+// CounterStore keeps named counters. Counters that do not exist, including
+// expired ones, have the value zero; updates keep the existing expiry unless
+// the options set one.
+type CounterStore interface {
+	Get(ctx context.Context, name string) (*Counter, error)
+	// Incr adds by, which is negative to decrement, and returns the counter.
+	Incr(ctx context.Context, name string, by int64, opts CounterOptions) (*Counter, error)
+	// Expire sets the TTL of an existing counter, removing it when ttl is 0.
+	// It returns ErrNotFound when the counter does not exist.
+	Expire(ctx context.Context, name string, ttl time.Duration) (*Counter, error)
+	// Reset deletes the counter, returning it to zero.
+	Reset(ctx context.Context, name string) error
+}
+
+// redisCounterStore stores each counter as an integer string under
+// <namespace>:counters:<name>, with the counter TTL as the key expiry.
+type redisCounterStore struct {
+	db     redis.UniversalClient
+	prefix string
+}
+
+// Counters returns the Redis backed counter store.
+func (s *service) Counters() CounterStore {
+	return &redisCounterStore{db: s.db, prefix: s.keyNamespace + ":counters:"}
+}
+
+// counterIncrScript adds ARGV[1] to the counter and applies the TTL in
+// ARGV[2] or, for counters without an expiry, the window in ARGV[3], both in
+// milliseconds.
+// Returns {value, pttl}.
+var counterIncrScript = redis.NewScript(`
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+elseif window > 0 and redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], window)
+end
+return {value, redis.call("PTTL", KEYS[1])}
+`)
+
+// counterExpireScript sets the TTL of an existing counter to ARGV[1]
+// milliseconds, or removes it when ARGV[1] is 0.
+// Returns {value, pttl}, or nil when the counter does not exist.
+var counterExpireScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+	return false
+end
+if tonumber(ARGV[1]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+else
+	redis.call("PERSIST", KEYS[1])
+end
+return {tonumber(value), redis.call("PTTL", KEYS[1])}
+`)
+
+func (c *redisCounterStore) Get(ctx context.Context, name string) (*Counter, error) {
+	var value *redis.StringCmd
+	var pttl *redis.DurationCmd
+	_, err := c.db.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		value = pipe.Get(ctx, c.prefix+name)
+		pttl = pipe.PTTL(ctx, c.prefix+name)
+		return nil
+	})
+	if errors.Is(err, redis.Nil) {
+		return &Counter{Name: name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := value.Int64()
+	if err != nil {
+		return nil, err
+	}
+	return newCounter(name, n, pttl.Val()), nil
+}
+
+func (c *redisCounterStore) Incr(ctx context.Context, name string, by int64, opts CounterOptions) (*Counter, error) {
+	vals, err := counterIncrScript.Run(ctx, c.db, []string{c.prefix + name},
+		by, opts.TTL.Milliseconds(), opts.Window.Milliseconds()).Int64Slice()
+	if err != nil {
+		if strings.Contains(err.Error(), "overflow") {
+			return nil, ErrCounterOverflow
+		}
+		return nil, err
+	}
+	return newCounter(name, vals[0], time.Duration(vals[1])*time.Millisecond), nil
+}
+
+func (c *redisCounterStore) Expire(ctx context.Context, name string, ttl time.Duration) (*Counter, error) {
+	vals, err := counterExpireScript.Run(ctx, c.db, []string{c.prefix + name}, ttl.Milliseconds()).Int64Slice()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newCounter(name, vals[0], time.Duration(vals[1])*time.Millisecond), nil
+}
+
+func (c *redisCounterStore) Reset(ctx context.Context, name string) error {
+	return c.db.Del(ctx, c.prefix+name).Err()
+}
+
+// newCounter returns a counter with the remaining TTL reported by Redis,
+// which is negative for keys without an expiry.
+func newCounter(name string, value int64, ttl time.Duration) *Counter {
+	counter := &Counter{Name: name, Value: value}
+	if ttl > 0 {
+		counter.ExpiresAt = time.Now().Add(ttl)
+	}
+	return counter
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryCounterStore is an in-memory CounterStore for tests and local
+// development.
+type MemoryCounterStore struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	counters map[string]memoryCounter
+}
+
+type memoryCounter struct {
+	value   int64
+	expires time.Time // zero for no expiry
+}
+
+// NewMemoryCounterStore returns an empty in-memory counter store.
+func NewMemoryCounterStore() *MemoryCounterStore {
+	return &MemoryCounterStore{now: time.Now, counters: make(map[string]memoryCounter)}
+}
+
+// getLocked returns the counter, deleting it if it has expired.
+func (m *MemoryCounterStore) getLocked(name string) (memoryCounter, bool) {
+	c, ok := m.counters[name]
+	if ok && !c.expires.IsZero() && !m.now().Before(c.expires) {
+		delete(m.counters, name)
+		return memoryCounter{}, false
+	}
+	return c, ok
+}
+
+func (m *MemoryCounterStore) Get(ctx context.Context, name string) (*Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, _ := m.getLocked(name)
+	return &Counter{Name: name, Value: c.value, ExpiresAt: c.expires}, nil
+}
+
+func (m *MemoryCounterStore) Incr(ctx context.Context, name string, by int64, opts CounterOptions) (*Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, _ := m.getLocked(name)
+	if (by > 0 && c.value > math.MaxInt64-by) || (by < 0 && c.value < math.MinInt64-by) {
+		return nil, ErrCounterOverflow
+	}
+	c.value += by
+	switch {
+	case opts.TTL > 0:
+		c.expires = m.now().Add(opts.TTL)
+	case opts.Window > 0 && c.expires.IsZero():
+		c.expires = m.now().Add(opts.Window)
+	}
+	m.counters[name] = c
+	return &Counter{Name: name, Value: c.value, ExpiresAt: c.expires}, nil
+}
+
+func (m *MemoryCounterStore) Expire(ctx context.Context, name string, ttl time.Duration) (*Counter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.getLocked(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c.expires = time.Time{}
+	if ttl > 0 {
+		c.expires = m.now().Add(ttl)
+	}
+	m.counters[name] = c
+	return &Counter{Name: name, Value: c.value, ExpiresAt: c.expires}, nil
+}
+
+func (m *MemoryCounterStore) Reset(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.counters, name)
+	return nil
+}
@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMemoryCounterStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemoryCounterStore()
+	m.now = func() time.Time { return now }
+
+	value := func(name string) int64 {
+		t.Helper()
+		c, err := m.Get(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Value
+	}
+
+	t.Run("ttl", func(t *testing.T) {
+		m.Incr(ctx, "ttl", 5, CounterOptions{TTL: time.Minute})
+		// Updates without options keep the expiry.
+		c, _ := m.Incr(ctx, "ttl", -2, CounterOptions{})
+		if c.Value != 3 || !c.ExpiresAt.Equal(now.Add(time.Minute)) {
+			t.Errorf("unexpected counter %+v", c)
+		}
+		now = now.Add(time.Minute)
+		if got := value("ttl"); got != 0 {
+			t.Errorf("expired counter is %d", got)
+		}
+		if _, err := m.Expire(ctx, "ttl", time.Minute); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("window", func(t *testing.T) {
+		opts := CounterOptions{Window: time.Minute}
+		m.Incr(ctx, "window", 1, opts)
+		now = now.Add(30 * time.Second)
+		if c, _ := m.Incr(ctx, "window", 1, opts); c.Value != 2 || !c.ExpiresAt.Equal(now.Add(30*time.Second)) {
+			t.Errorf("unexpected counter %+v", c)
+		}
+		now = now.Add(30 * time.Second)
+		if c, _ := m.Incr(ctx, "window", 1, opts); c.Value != 1 || !c.ExpiresAt.Equal(now.Add(time.Minute)) {
+			t.Errorf("unexpected counter in the next window %+v", c)
+		}
+	})
+
+	t.Run("expire_and_reset", func(t *testing.T) {
+		m.Incr(ctx, "persist", 1, CounterOptions{TTL: time.Second})
+		if c, err := m.Expire(ctx, "persist", 0); err != nil || !c.ExpiresAt.IsZero() {
+			t.Fatalf("unexpected counter %+v %v", c, err)
+		}
+		now = now.Add(time.Hour)
+		if got := value("persist"); got != 1 {
+			t.Errorf("persisted counter is %d", got)
+		}
+		m.Reset(ctx, "persist")
+		if got := value("persist"); got != 0 {
+			t.Errorf("reset counter is %d", got)
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		m.Incr(ctx, "max", math.MaxInt64, CounterOptions{})
+		if _, err := m.Incr(ctx, "max", 1, CounterOptions{}); !errors.Is(err, ErrCounterOverflow) {
+			t.Errorf("expected ErrCounterOverflow, got %v", err)
+		}
+		if got := value("max"); got != math.MaxInt64 {
+			t.Errorf("counter changed to %d", got)
+		}
+	})
+}
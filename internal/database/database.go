@@ -2,62 +2,84 @@ package database
 
 import (
 	"context"
-	"fmt"
-	"time"
+	"errors"
+	"strconv"
 
 	"github.com/redis/go-redis/v9"
-	"gofetch.timwalker.dev/internal/env"
-
-	_ "github.com/joho/godotenv/autoload"
 )
 
+// Service is the Redis backed store. Methods fail with ErrTimeout when
+// Redis does not respond in time and with ErrUnavailable when it cannot be
+// reached, wrapping the cause.
 type Service interface {
-	IsHealthy() bool
-	IncrementCounter() int
+	// Ping checks the connection to Redis.
+	Ping(ctx context.Context) error
+	// IncrementCounter increments the counter, publishes the new value on
+	// CounterChannel and returns it.
+	IncrementCounter(ctx context.Context) (int, error)
+	// CounterValue returns the counter without incrementing it.
+	CounterValue(ctx context.Context) (int, error)
+	RateLimit(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error)
+	APIKeys() APIKeyRepository
+	Albums() AlbumRepository
+	Idempotency() IdempotencyStore
+	Jobs() JobRepository
+	Cache() Cache
+	Counters() CounterStore
+	PubSub() PubSub
+	Locks() Locker
 }
 
+// CounterChannel is the pub/sub channel of counter values, published as
+// decimal strings.
+const CounterChannel = "counter"
+
+const counterKey = "counter"
+
 type service struct {
-	db *redis.Client
+	db           redis.UniversalClient
+	keyNamespace string
 }
 
-var (
-	address  = env.GetString("REDIS_ADDRESS", "localhost")
-	port     = env.GetString("REDIS_PORT", "6379")
-	password = env.GetString("REDIS_PASSWORD", "")
-	database = env.GetInt("REDIS_DATABASE", 0)
-)
+// New returns the Redis backed service for cfg. It does not connect, so an
+// unreachable server only fails the first command; it returns an error when
+// cfg is invalid.
+func New(cfg Config) (Service, error) {
+	opts, err := cfg.options()
+	if err != nil {
+		return nil, err
+	}
 
-func New() Service {
-	fullAddress := fmt.Sprintf("%s:%s", address, port)
+	rdb := redis.NewUniversalClient(opts)
+	rdb.AddHook(errorHook{})
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     fullAddress,
-		Password: password,
-		DB:       database,
-	})
+	keyNamespace := cfg.KeyNamespace
+	if keyNamespace == "" {
+		keyNamespace = "local"
+	}
+	return &service{db: rdb, keyNamespace: keyNamespace}, nil
+}
 
-	s := &service{db: rdb}
+// IncrementCounter returns the new count even if publishing it fails, since
+// subscribers only miss an update.
+func (s *service) IncrementCounter(ctx context.Context) (int, error) {
+	count, err := s.db.Incr(ctx, counterKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	s.db.Publish(ctx, CounterChannel, strconv.FormatInt(count, 10))
 
-	return s
+	return int(count), nil
 }
 
-func (s *service) IncrementCounter() int {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second) // Default is now 5s
-	defer cancel()
-	count, _ := s.db.Incr(ctx, "counter").Result()
-
-	return int(count)
+func (s *service) CounterValue(ctx context.Context) (int, error) {
+	count, err := s.db.Get(ctx, counterKey).Int()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
 }
 
-// isHealthy returns the health status by pinging the Redis server.
-func (s *service) IsHealthy() bool {
-	// ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second) // Default is now 5s
-	// defer cancel()
-	//
-	// pong, err := s.db.Ping(ctx).Result()
-	// if err != nil {
-	// 	log.Fatalf("db down: %v", err)
-	// }
-	// return strings.ToUpper(pong) == "PONG"
-	return true
+func (s *service) Ping(ctx context.Context) error {
+	return s.db.Ping(ctx).Err()
 }
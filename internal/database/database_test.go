@@ -0,0 +1,22 @@
+package database_test
+
+import (
+	"cmp"
+	"os"
+	"testing"
+
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/database/databasetest"
+)
+
+// TestRedisService runs the contract tests against the Redis server at
+// REDIS_TEST_ADDRESS, default localhost:6379. It empties database 15, which
+// should not hold data anyone needs.
+func TestRedisService(t *testing.T) {
+	addr := cmp.Or(os.Getenv("REDIS_TEST_ADDRESS"), "localhost:6379")
+	database.NewTestService(t, addr, 15)
+
+	databasetest.Run(t, func(t *testing.T) database.Service {
+		return database.NewTestService(t, addr, 15)
+	})
+}
@@ -0,0 +1,499 @@
+// Package databasetest is a contract test suite for implementations of
+// database.Service, run against both Redis and the in-memory service so they
+// stay interchangeable.
+package databasetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gofetch.timwalker.dev/internal/database"
+)
+
+// Run runs the contract tests. newService returns an empty service for each
+// test.
+func Run(t *testing.T, newService func(t *testing.T) database.Service) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s database.Service)
+	}{
+		{"counter", testCounter},
+		{"counters", testCounters},
+		{"pubsub", testPubSub},
+		{"cache", testCache},
+		{"albums", testAlbums},
+		{"api_keys", testAPIKeys},
+		{"idempotency", testIdempotency},
+		{"jobs", testJobs},
+		{"rate_limit", testRateLimit},
+		{"locks", testLocks},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newService(t))
+		})
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive(t *testing.T, sub database.Subscription) database.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.Messages():
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+	}
+	return database.Message{}
+}
+
+func testCounter(t *testing.T, s database.Service) {
+	ctx := context.Background()
+	check(t, s.Ping(ctx))
+
+	sub, err := s.PubSub().Subscribe(ctx, database.CounterChannel)
+	check(t, err)
+	defer sub.Close()
+
+	for want := 1; want <= 2; want++ {
+		count, err := s.IncrementCounter(ctx)
+		check(t, err)
+		if count != want {
+			t.Errorf("IncrementCounter: got %d want %d", count, want)
+		}
+		if msg := receive(t, sub); string(msg.Payload) != strconv.Itoa(want) {
+			t.Errorf("published %q, want %d", msg.Payload, want)
+		}
+	}
+	if count, err := s.CounterValue(ctx); err != nil || count != 2 {
+		t.Errorf("CounterValue: got %d, %v", count, err)
+	}
+}
+
+func testCounters(t *testing.T, s database.Service) {
+	ctx := context.Background()
+	counters := s.Counters()
+
+	c, err := counters.Get(ctx, "missing")
+	check(t, err)
+	if c.Name != "missing" || c.Value != 0 || !c.ExpiresAt.IsZero() {
+		t.Errorf("missing counter: %+v", c)
+	}
+
+	counters.Incr(ctx, "visits", 5, database.CounterOptions{})
+	c, err = counters.Incr(ctx, "visits", -2, database.CounterOptions{})
+	check(t, err)
+	if c.Value != 3 || !c.ExpiresAt.IsZero() {
+		t.Errorf("Incr: %+v", c)
+	}
+
+	c, err = counters.Expire(ctx, "visits", time.Hour)
+	check(t, err)
+	if c.Value != 3 || time.Until(c.ExpiresAt) <= 59*time.Minute {
+		t.Errorf("Expire: %+v", c)
+	}
+	// Updates keep the expiry, and a window does not replace it.
+	c, err = counters.Incr(ctx, "visits", 1, database.CounterOptions{Window: time.Minute})
+	check(t, err)
+	if time.Until(c.ExpiresAt) <= 59*time.Minute {
+		t.Errorf("Incr moved the expiry: %+v", c)
+	}
+	c, err = counters.Expire(ctx, "visits", 0)
+	check(t, err)
+	if !c.ExpiresAt.IsZero() {
+		t.Errorf("Expire(0) kept the expiry: %+v", c)
+	}
+
+	check(t, counters.Reset(ctx, "visits"))
+	if c, _ := counters.Get(ctx, "visits"); c.Value != 0 {
+		t.Errorf("Reset: %+v", c)
+	}
+	if _, err := counters.Expire(ctx, "visits", time.Hour); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Expire of a missing counter: expected ErrNotFound, got %v", err)
+	}
+
+	c, err = counters.Incr(ctx, "window", 1, database.CounterOptions{Window: 100 * time.Millisecond})
+	check(t, err)
+	if c.ExpiresAt.IsZero() {
+		t.Errorf("window counter without expiry: %+v", c)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if c, _ := counters.Incr(ctx, "window", 1, database.CounterOptions{Window: time.Minute}); c.Value != 1 {
+		t.Errorf("window counter did not restart: %+v", c)
+	}
+
+	counters.Incr(ctx, "max", math.MaxInt64, database.CounterOptions{})
+	if _, err := counters.Incr(ctx, "max", 1, database.CounterOptions{}); !errors.Is(err, database.ErrCounterOverflow) {
+		t.Errorf("expected ErrCounterOverflow, got %v", err)
+	}
+}
+
+func testPubSub(t *testing.T, s database.Service) {
+	ctx := context.Background()
+	ps := s.PubSub()
+
+	sub, err := ps.Subscribe(ctx, "a", "b")
+	check(t, err)
+
+	check(t, ps.Publish(ctx, "c", []byte("ignored")))
+	check(t, ps.Publish(ctx, "b", []byte("to b")))
+	if msg := receive(t, sub); msg.Channel != "b" || string(msg.Payload) != "to b" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+
+	check(t, sub.Close())
+	select {
+	case _, ok := <-sub.Messages():
+		if ok {
+			t.Error("message received after Close")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Messages not closed by Close")
+	}
+}
+
+func testCache(t *testing.T, s database.Service) {
+	ctx := context.Background()
+	cache := s.Cache()
+
+	if _, err := cache.Get(ctx, "missing"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	check(t, cache.Set(ctx, "kept", "1", 0))
+	check(t, cache.Set(ctx, "expiring", "2", 100*time.Millisecond))
+	if v, err := cache.Get(ctx, "expiring"); err != nil || v != "2" {
+		t.Errorf("Get: got %q, %v", v, err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := cache.Get(ctx, "expiring"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("expired entry: expected ErrNotFound, got %v", err)
+	}
+	if v, err := cache.Get(ctx, "kept"); err != nil || v != "1" {
+		t.Errorf("Get: got %q, %v", v, err)
+	}
+}
+
+func testAlbums(t *testing.T, s database.Service) {
+	ctx := context.Background()
+	albums := s.Albums()
+
+	first := &database.Album{UserID: 1, Title: "first"}
+	check(t, albums.Create(ctx, first))
+	check(t, albums.Create(ctx, &database.Album{UserID: 2, Title: "second"}))
+	if first.ID == 0 || first.Version != 1 || first.UpdatedAt.IsZero() {
+		t.Errorf("Create: %+v", first)
+	}
+
+	got, err := albums.Get(ctx, first.ID)
+	check(t, err)
+	if got.Title != "first" || got.Version != 1 {
+		t.Errorf("Get: %+v", got)
+	}
+
+	list, total, err := albums.List(ctx, database.AlbumFilter{})
+	check(t, err)
+	if len(list) != 2 || list[0].ID != first.ID || total != 2 {
+		t.Errorf("List: %+v, total %d", list, total)
+	}
+	list, total, err = albums.List(ctx, database.AlbumFilter{UserID: 2})
+	check(t, err)
+	if len(list) != 1 || list[0].Title != "second" || total != 1 {
+		t.Errorf("List by user: %+v, total %d", list, total)
+	}
+
+	// Pages are taken from the index in ID order.
+	check(t, albums.Create(ctx, &database.Album{UserID: 1, Title: "third"}))
+	for _, tt := range []struct {
+		filter database.AlbumFilter
+		want   []int
+	}{
+		{database.AlbumFilter{Limit: 2}, []int{first.ID, first.ID + 1}},
+		{database.AlbumFilter{Offset: 1, Limit: 1}, []int{first.ID + 1}},
+		{database.AlbumFilter{AfterID: first.ID, Limit: 5}, []int{first.ID + 1, first.ID + 2}},
+		{database.AlbumFilter{Desc: true, Limit: 2}, []int{first.ID + 2, first.ID + 1}},
+		{database.AlbumFilter{Desc: true, AfterID: first.ID + 1}, []int{first.ID}},
+		{database.AlbumFilter{UserID: 1, AfterID: first.ID}, []int{first.ID + 2}},
+	} {
+		list, total, err := albums.List(ctx, tt.filter)
+		check(t, err)
+		var got []int
+		for _, album := range list {
+			got = append(got, album.ID)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("List(%+v): got IDs %v want %v", tt.filter, got, tt.want)
+		}
+		if wantTotal := map[int]int{0: 3, 1: 2}[tt.filter.UserID]; total != wantTotal {
+			t.Errorf("List(%+v): got total %d want %d", tt.filter, total, wantTotal)
+		}
+	}
+	check(t, albums.Delete(ctx, first.ID+2, 1))
+
+	got.Title = "renamed"
+	check(t, albums.Update(ctx, got))
+	if got.Version != 2 {
+		t.Errorf("Update: %+v", got)
+	}
+	stale := *first
+	stale.Title = "stale"
+	if err := albums.Update(ctx, &stale); !errors.Is(err, database.ErrVersionConflict) {
+		t.Errorf("stale Update: expected ErrVersionConflict, got %v", err)
+	}
+
+	// Changing the owner moves the album between owner indexes.
+	got.UserID = 2
+	check(t, albums.Update(ctx, got))
+	if list, _, err := albums.List(ctx, database.AlbumFilter{UserID: 1}); err != nil || len(list) != 0 {
+		t.Errorf("List of the previous owner: %+v, %v", list, err)
+	}
+	if list, _, err := albums.List(ctx, database.AlbumFilter{UserID: 2}); err != nil || len(list) != 2 {
+		t.Errorf("List of the new owner: %+v, %v", list, err)
+	}
+
+	if err := albums.Delete(ctx, first.ID, got.Version-1); !errors.Is(err, database.ErrVersionConflict) {
+		t.Errorf("stale Delete: expected ErrVersionConflict, got %v", err)
+	}
+	check(t, albums.Delete(ctx, first.ID, got.Version))
+	if list, _, err := albums.List(ctx, database.AlbumFilter{UserID: 2}); err != nil || len(list) != 1 {
+		t.Errorf("List after Delete: %+v, %v", list, err)
+	}
+	if _, err := albums.Get(ctx, first.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get after Delete: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testAPIKeys(t *testing.T, s database.Service) {
+	ctx := context.Background()
+	keys := s.APIKeys()
+
+	_, prefix, hash, err := database.GenerateAPIKey()
+	check(t, err)
+	key := &database.APIKey{
+		ID:        uuid.NewString(),
+		Prefix:    prefix,
+		Hash:      hash,
+		Owner:     "user-1",
+		Scopes:    []string{"albums:read"},
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	check(t, keys.Create(ctx, key))
+
+	got, err := keys.GetByPrefix(ctx, prefix)
+	check(t, err)
+	if got.ID != key.ID || got.Hash != hash || len(got.Scopes) != 1 {
+		t.Errorf("GetByPrefix: %+v", got)
+	}
+	if list, err := keys.List(ctx, "user-2"); err != nil || len(list) != 0 {
+		t.Errorf("List for another owner: %+v, %v", list, err)
+	}
+	if list, err := keys.List(ctx, "user-1"); err != nil || len(list) != 1 {
+		t.Errorf("List: %+v, %v", list, err)
+	}
+	if list, err := keys.List(ctx, ""); err != nil || len(list) != 0 {
+		t.Errorf("List with no owner: %+v, %v", list, err)
+	}
+	if list, err := keys.ListAll(ctx); err != nil || len(list) != 1 {
+		t.Errorf("ListAll: %+v, %v", list, err)
+	}
+
+	used := time.Now().UTC().Truncate(time.Second)
+	check(t, keys.TouchLastUsed(ctx, key.ID, used))
+	if got, _ := keys.Get(ctx, key.ID); !got.LastUsedAt.Equal(used) {
+		t.Errorf("TouchLastUsed: %+v", got)
+	}
+
+	_, newPrefix, newHash, _ := database.GenerateAPIKey()
+	rotated, err := keys.Rotate(ctx, key.ID, newPrefix, newHash)
+	check(t, err)
+	if rotated.Prefix != newPrefix || rotated.Hash != newHash {
+		t.Errorf("Rotate: %+v", rotated)
+	}
+	if _, err := keys.GetByPrefix(ctx, prefix); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("old prefix after Rotate: expected ErrNotFound, got %v", err)
+	}
+
+	check(t, keys.Delete(ctx, key.ID))
+	if _, err := keys.Get(ctx, key.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get after Delete: expected ErrNotFound, got %v", err)
+	}
+}
+
+func testIdempotency(t *testing.T, s database.Service) {
+	ctx := context.Background()
+	store := s.Idempotency()
+
+	token, existing, err := store.Lock(ctx, "key", "fp", time.Minute)
+	check(t, err)
+	if token == "" || existing != nil {
+		t.Fatalf("Lock: got %q, %+v", token, existing)
+	}
+	_, existing, err = store.Lock(ctx, "key", "other", time.Minute)
+	check(t, err)
+	if existing == nil || existing.Completed || existing.Fingerprint != "fp" {
+		t.Errorf("Lock of a claimed key: %+v", existing)
+	}
+
+	record := &database.IdempotencyRecord{Status: 201, Header: map[string][]string{"Location": {"/albums/1"}}, Body: []byte("{}")}
+	check(t, store.Complete(ctx, "key", token, record, time.Minute))
+	_, existing, err = store.Lock(ctx, "key", "fp", time.Minute)
+	check(t, err)
+	if existing == nil || !existing.Completed || existing.Status != 201 || string(existing.Body) != "{}" || existing.Header["Location"][0] != "/albums/1" {
+		t.Errorf("Lock of a completed key: %+v", existing)
+	}
+
+	token, _, err = store.Lock(ctx, "released", "fp", time.Minute)
+	check(t, err)
+	check(t, store.Release(ctx, "released", token))
+	if token, existing, err := store.Lock(ctx, "released", "fp", time.Minute); err != nil || token == "" || existing != nil {
+		t.Errorf("Lock after Release: got %q, %+v, %v", token, existing, err)
+	}
+}
+
+func testJobs(t *testing.T, s database.Service) {
+	ctx := context.Background()
+	repo := s.Jobs()
+
+	job := &database.Job{ID: uuid.NewString(), Type: "echo", Owner: "u1", Input: json.RawMessage(`{"n":1}`)}
+	check(t, repo.Create(ctx, job))
+	if job.Status != database.JobQueued || job.CreatedAt.IsZero() {
+		t.Errorf("Create: %+v", job)
+	}
+	if got, _ := repo.Get(ctx, job.ID); got.Owner != "u1" {
+		t.Errorf("Get: owner %q, want u1", got.Owner)
+	}
+
+	started, err := repo.Start(ctx, job.ID)
+	check(t, err)
+	if started.Status != database.JobRunning || started.StartedAt == nil {
+		t.Errorf("Start: %+v", started)
+	}
+	check(t, repo.SetProgress(ctx, job.ID, 0.5))
+	if got, _ := repo.Get(ctx, job.ID); got.Progress != 0.5 {
+		t.Errorf("SetProgress: %+v", got)
+	}
+
+	finished, err := repo.Finish(ctx, job.ID, database.JobSucceeded, json.RawMessage(`"done"`), "")
+	check(t, err)
+	if finished.Status != database.JobSucceeded || string(finished.Result) != `"done"` || finished.FinishedAt == nil {
+		t.Errorf("Finish: %+v", finished)
+	}
+	if _, err := repo.Finish(ctx, job.ID, database.JobCanceled, nil, ""); !errors.Is(err, database.ErrJobFinished) {
+		t.Errorf("second Finish: expected ErrJobFinished, got %v", err)
+	}
+	if err := repo.SetProgress(ctx, job.ID, 0.9); !errors.Is(err, database.ErrJobFinished) {
+		t.Errorf("SetProgress of a finished job: expected ErrJobFinished, got %v", err)
+	}
+
+	if n, err := repo.DeleteFinished(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("DeleteFinished of recent jobs: got %d, %v", n, err)
+	}
+	if n, err := repo.DeleteFinished(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("DeleteFinished: got %d, %v", n, err)
+	}
+	if _, err := repo.Get(ctx, job.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("Get after DeleteFinished: expected ErrNotFound, got %v", err)
+	}
+
+	stale := &database.Job{ID: uuid.NewString(), Type: "echo", Input: json.RawMessage(`{}`)}
+	check(t, repo.Create(ctx, stale))
+	_, err = repo.Start(ctx, stale.ID)
+	check(t, err)
+	check(t, repo.Heartbeat(ctx, stale.ID))
+	if failed, err := repo.FailStale(ctx, time.Now().Add(-time.Hour), "lost"); err != nil || len(failed) != 0 {
+		t.Errorf("FailStale of a live job: got %+v, %v", failed, err)
+	}
+	failed, err := repo.FailStale(ctx, time.Now().Add(time.Second), "lost")
+	check(t, err)
+	if len(failed) != 1 || failed[0].ID != stale.ID || failed[0].Status != database.JobFailed || failed[0].Error != "lost" || failed[0].FinishedAt == nil {
+		t.Errorf("FailStale: %+v", failed)
+	}
+	if err := repo.Heartbeat(ctx, stale.ID); !errors.Is(err, database.ErrJobFinished) {
+		t.Errorf("Heartbeat of a failed job: expected ErrJobFinished, got %v", err)
+	}
+	if failed, err := repo.FailStale(ctx, time.Now().Add(time.Second), "lost"); err != nil || len(failed) != 0 {
+		t.Errorf("second FailStale: got %+v, %v", failed, err)
+	}
+	if n, err := repo.DeleteFinished(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("DeleteFinished of a failed stale job: got %d, %v", n, err)
+	}
+}
+
+func testRateLimit(t *testing.T, s database.Service) {
+	ctx := context.Background()
+
+	for _, alg := range []database.RateLimitAlgorithm{database.FixedWindow, database.SlidingWindow, database.TokenBucket} {
+		policy := database.RateLimitPolicy{Algorithm: alg, Limit: 2, Window: time.Minute}
+		for i := range 3 {
+			res, err := s.RateLimit(ctx, "client", policy)
+			check(t, err)
+			if want := i < 2; res.Allowed != want {
+				t.Errorf("%s request %d: allowed %v want %v", alg, i, res.Allowed, want)
+			}
+			if i == 2 && res.RetryAfter <= 0 {
+				t.Errorf("%s: denied without RetryAfter: %+v", alg, res)
+			}
+		}
+	}
+}
+
+func testLocks(t *testing.T, s database.Service) {
+	ctx := context.Background()
+	locker := s.Locks()
+
+	lock, err := locker.Acquire(ctx, "cleanup", time.Minute)
+	check(t, err)
+	if lock.Name != "cleanup" || lock.Token <= 0 {
+		t.Errorf("Acquire: %+v", lock)
+	}
+	if _, err := locker.Acquire(ctx, "cleanup", time.Minute); !errors.Is(err, database.ErrLockHeld) {
+		t.Errorf("Acquire of a held lock: expected ErrLockHeld, got %v", err)
+	}
+	if other, err := locker.Acquire(ctx, "other", time.Minute); err != nil {
+		t.Errorf("Acquire of another lock: %v", err)
+	} else {
+		check(t, locker.Release(ctx, other))
+	}
+	check(t, locker.Renew(ctx, lock, time.Minute))
+	check(t, locker.Release(ctx, lock))
+	if err := locker.Release(ctx, lock); !errors.Is(err, database.ErrLockLost) {
+		t.Errorf("second Release: expected ErrLockLost, got %v", err)
+	}
+
+	next, err := locker.Acquire(ctx, "cleanup", 50*time.Millisecond)
+	check(t, err)
+	if next.Token <= lock.Token {
+		t.Errorf("Acquire after Release: token %d not above %d", next.Token, lock.Token)
+	}
+	// An expired lock can be taken, and its previous holder can no longer
+	// renew or release it.
+	time.Sleep(100 * time.Millisecond)
+	last, err := locker.Acquire(ctx, "cleanup", time.Minute)
+	check(t, err)
+	if last.Token <= next.Token {
+		t.Errorf("Acquire after expiry: token %d not above %d", last.Token, next.Token)
+	}
+	if err := locker.Renew(ctx, next, time.Minute); !errors.Is(err, database.ErrLockLost) {
+		t.Errorf("Renew of an expired lock: expected ErrLockLost, got %v", err)
+	}
+	if err := locker.Release(ctx, next); !errors.Is(err, database.ErrLockLost) {
+		t.Errorf("Release of an expired lock: expected ErrLockLost, got %v", err)
+	}
+	check(t, locker.Release(ctx, last))
+}
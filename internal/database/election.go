@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ElectionConfig configures an Elector.
+type ElectionConfig struct {
+	// Name is the lock campaigned for. Replicas electing a leader for the
+	// same work use the same name.
+	Name string
+	// TTL is how long leadership lasts without renewal, and so how long a
+	// leader that crashed delays the next one. Defaults to 15 seconds.
+	TTL time.Duration
+	// RetryInterval is how often followers try to become leader, a third of
+	// TTL when zero.
+	RetryInterval time.Duration
+	// OnElected is called in a new goroutine when this replica becomes
+	// leader, with the fencing token of its lock. ctx is done when
+	// leadership ends, and the lock is released once OnElected returns.
+	OnElected func(ctx context.Context, token int64)
+	// OnDemoted, if set, is called after leadership ends, with an error
+	// wrapping ErrLockLost if it was lost rather than given up on Shutdown.
+	OnDemoted func(err error)
+}
+
+// Elector elects one leader among the replicas campaigning for the same
+// name, by holding a lock renewed for as long as the leader runs.
+type Elector struct {
+	locker Locker
+	cfg    ElectionConfig
+	leader atomic.Bool
+
+	// stop ends the campaign and any leadership on Shutdown; done is closed
+	// once the lock is released.
+	ctx  context.Context
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// NewElector returns an Elector campaigning with locks from locker. Call
+// Start to begin campaigning.
+func NewElector(locker Locker, cfg ElectionConfig) *Elector {
+	if cfg.TTL <= 0 {
+		cfg.TTL = 15 * time.Second
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = cfg.TTL / 3
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	return &Elector{locker: locker, cfg: cfg, ctx: ctx, stop: stop, done: make(chan struct{})}
+}
+
+// Start begins campaigning. Failures to reach the locker are retried.
+func (e *Elector) Start() {
+	go func() {
+		defer close(e.done)
+
+		ticker := time.NewTicker(e.cfg.RetryInterval)
+		defer ticker.Stop()
+		for {
+			lock, err := e.locker.Acquire(e.ctx, e.cfg.Name, e.cfg.TTL)
+			if err == nil {
+				e.lead(lock)
+			}
+
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// IsLeader reports whether this replica is the leader.
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Shutdown stops campaigning and ends leadership, then waits for OnElected
+// to return and the lock to be released, or for ctx to be done.
+func (e *Elector) Shutdown(ctx context.Context) error {
+	e.stop()
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lead runs OnElected while keeping lock alive, until the lock is lost or
+// the elector is shut down.
+func (e *Elector) lead(lock *Lock) {
+	e.leader.Store(true)
+	ctx, cancel := context.WithCancel(e.ctx)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.cfg.OnElected(ctx, lock.Token)
+	}()
+
+	err := KeepAlive(ctx, e.locker, lock, e.cfg.TTL)
+	cancel()
+	wg.Wait()
+	e.leader.Store(false)
+
+	if !errors.Is(err, ErrLockLost) {
+		// Release even though the elector is shutting down, so another
+		// replica takes over without waiting for the lock to expire.
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(e.ctx), e.cfg.TTL)
+		defer cancel()
+		if releaseErr := e.locker.Release(releaseCtx, lock); errors.Is(releaseErr, ErrLockLost) {
+			err = releaseErr
+		} else {
+			err = nil
+		}
+	}
+	if e.cfg.OnDemoted != nil {
+		e.cfg.OnDemoted(err)
+	}
+}
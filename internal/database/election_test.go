@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyLocker fails renewals with err once it is set.
+type flakyLocker struct {
+	*MemoryLocker
+	err atomic.Pointer[error]
+}
+
+func (l *flakyLocker) Renew(ctx context.Context, lock *Lock, ttl time.Duration) error {
+	if err := l.err.Load(); err != nil {
+		return *err
+	}
+	return l.MemoryLocker.Renew(ctx, lock, ttl)
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	ctx := context.Background()
+	locker := &flakyLocker{MemoryLocker: NewMemoryLocker()}
+	lock, err := locker.Acquire(ctx, "cleanup", 60*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Renewals keep the lock past its TTL.
+	stopCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := KeepAlive(stopCtx, locker, lock, 60*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("KeepAlive: got %v", err)
+	}
+	if err := locker.Renew(ctx, lock, time.Minute); err != nil {
+		t.Fatalf("lock expired despite renewals: %v", err)
+	}
+
+	// Renewals failing for longer than the TTL give up on the lock.
+	unavailable := ErrUnavailable
+	locker.err.Store(&unavailable)
+	start := time.Now()
+	if err := KeepAlive(ctx, locker, lock, 60*time.Millisecond); !errors.Is(err, ErrLockLost) || !errors.Is(err, ErrUnavailable) {
+		t.Errorf("KeepAlive with failing renewals: got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 60*time.Millisecond {
+		t.Errorf("KeepAlive gave up after %v, past the TTL", elapsed)
+	}
+
+	lost := ErrLockLost
+	locker.err.Store(&lost)
+	if err := KeepAlive(ctx, locker, lock, 60*time.Millisecond); !errors.Is(err, ErrLockLost) {
+		t.Errorf("KeepAlive of a lost lock: got %v", err)
+	}
+}
+
+func TestElector(t *testing.T) {
+	locker := &flakyLocker{MemoryLocker: NewMemoryLocker()}
+
+	type replica struct {
+		elector *Elector
+		elected atomic.Int64
+		demoted chan error
+	}
+	newReplica := func() *replica {
+		r := &replica{demoted: make(chan error, 1)}
+		r.elector = NewElector(locker, ElectionConfig{
+			Name: "cleanup",
+			TTL:  60 * time.Millisecond,
+			OnElected: func(ctx context.Context, token int64) {
+				r.elected.Store(token)
+				<-ctx.Done()
+			},
+			OnDemoted: func(err error) {
+				select {
+				case r.demoted <- err:
+				default:
+				}
+			},
+		})
+		r.elector.Start()
+		return r
+	}
+
+	a := newReplica()
+	eventually(t, "a to be elected", a.elector.IsLeader)
+	b := newReplica()
+	time.Sleep(100 * time.Millisecond)
+	if !a.elector.IsLeader() || b.elector.IsLeader() {
+		t.Fatalf("leaders: a %v, b %v", a.elector.IsLeader(), b.elector.IsLeader())
+	}
+
+	// Shutting down the leader releases the lock for b.
+	if err := a.elector.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-a.demoted; err != nil {
+		t.Errorf("OnDemoted after Shutdown: got %v", err)
+	}
+	if a.elector.IsLeader() {
+		t.Error("a is still leader after Shutdown")
+	}
+	eventually(t, "b to be elected", b.elector.IsLeader)
+	if b.elected.Load() <= a.elected.Load() {
+		t.Errorf("fencing token of b %d not above a %d", b.elected.Load(), a.elected.Load())
+	}
+
+	// Losing the lock ends leadership.
+	lost := ErrLockLost
+	locker.err.Store(&lost)
+	if err := <-b.demoted; !errors.Is(err, ErrLockLost) {
+		t.Errorf("OnDemoted after losing the lock: got %v", err)
+	}
+	if b.elector.IsLeader() {
+		t.Error("b is still leader after losing the lock")
+	}
+	if err := b.elector.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
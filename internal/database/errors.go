@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrNotFound is returned when a requested record does not exist.
+	ErrNotFound = errors.New("database: record not found")
+	// ErrVersionConflict is returned when a record was changed since the
+	// version being updated was read.
+	ErrVersionConflict = errors.New("database: record version conflict")
+	// ErrTimeout is returned, wrapping the cause, when Redis does not
+	// respond before the context deadline or the client timeouts.
+	ErrTimeout = errors.New("database: timeout")
+	// ErrUnavailable is returned, wrapping the cause, when Redis cannot be
+	// reached or drops the connection.
+	ErrUnavailable = errors.New("database: unavailable")
+)
+
+// classifyError wraps timeouts with ErrTimeout and connection failures with
+// ErrUnavailable, leaving other errors, such as redis.Nil and errors
+// replied by Redis, unchanged.
+func classifyError(err error) error {
+	switch {
+	case err == nil || errors.Is(err, redis.Nil) || errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable):
+		return err
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, redis.ErrPoolTimeout):
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	case errors.Is(err, redis.ErrClosed) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return fmt.Errorf("%w: %w", ErrTimeout, err)
+		}
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+// errorHook classifies the errors of every command, so callers can tell
+// Redis outages from other failures whichever repository they use.
+type errorHook struct{}
+
+func (errorHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (errorHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := classifyError(next(ctx, cmd))
+		if err != nil {
+			cmd.SetErr(err)
+		}
+		return err
+	}
+}
+
+func (errorHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := classifyError(next(ctx, cmds))
+		for _, cmd := range cmds {
+			if cmdErr := cmd.Err(); cmdErr != nil {
+				cmd.SetErr(classifyError(cmdErr))
+			}
+		}
+		return err
+	}
+}
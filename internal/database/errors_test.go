@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestClassifyError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want error
+	}{
+		{context.DeadlineExceeded, ErrTimeout},
+		{redis.ErrPoolTimeout, ErrTimeout},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, ErrTimeout},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, ErrUnavailable},
+		{io.EOF, ErrUnavailable},
+		{redis.ErrClosed, ErrUnavailable},
+		{redis.Nil, redis.Nil},
+		{context.Canceled, context.Canceled},
+	} {
+		got := classifyError(tt.err)
+		if !errors.Is(got, tt.want) || !errors.Is(got, tt.err) {
+			t.Errorf("classifyError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+
+	// Errors replied by Redis are left alone.
+	err := errors.New("ERR wrong number of arguments")
+	if got := classifyError(err); got != err {
+		t.Errorf("classifyError(%v) = %v", err, got)
+	}
+}
+
+func TestErrorHook(t *testing.T) {
+	// Nothing listens on port 1, so dialing fails at once.
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: time.Second})
+	rdb.AddHook(errorHook{})
+	defer rdb.Close()
+	ctx := context.Background()
+
+	if err := rdb.Ping(ctx).Err(); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Ping: expected ErrUnavailable, got %v", err)
+	}
+	_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Get(ctx, "a")
+		return nil
+	})
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Pipelined: expected ErrUnavailable, got %v", err)
+	}
+
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	if err := rdb.Get(expired, "a").Err(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Get: expected ErrTimeout, got %v", err)
+	}
+}
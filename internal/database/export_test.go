@@ -0,0 +1,29 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// NewTestService connects to the Redis server at addr and empties database
+// db for a test, skipping the test when Redis is unreachable.
+func NewTestService(t *testing.T, addr string, db int) Service {
+	t.Helper()
+	s, err := New(Config{Addrs: []string{addr}, DB: db, KeyNamespace: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdb := s.(*service).db
+	t.Cleanup(func() { rdb.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		t.Skipf("Redis is unavailable at %s: %v", addr, err)
+	}
+	if err := rdb.FlushDB(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
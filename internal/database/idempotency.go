@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// IdempotencyRecord is the state of an Idempotency-Key. A record is created
+// when a request claims the key and completed with the response once the
+// request has been handled.
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request payload the key was first used with.
+	Fingerprint string
+	Completed   bool
+	Status      int
+	Header      map[string][]string
+	Body        []byte
+}
+
+// IdempotencyStore stores Idempotency-Key records.
+type IdempotencyStore interface {
+	// Lock claims key for a request with fingerprint, holding the claim for
+	// lockTTL. It returns a token for Complete and Release, or the existing
+	// record when the key is already claimed or completed.
+	Lock(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (token string, existing *IdempotencyRecord, err error)
+	// Complete stores the response of a claimed key for ttl. It is a no-op if
+	// the claim identified by token has expired.
+	Complete(ctx context.Context, key, token string, record *IdempotencyRecord, ttl time.Duration) error
+	// Release removes an uncompleted claim so the request can be retried.
+	Release(ctx context.Context, key, token string) error
+}
+
+// newIdempotencyToken returns a random token identifying a claim.
+func newIdempotencyToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("idempotency token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// redisIdempotencyStore stores each record as a hash under idempotency:{key}.
+type redisIdempotencyStore struct {
+	db redis.UniversalClient
+}
+
+const idempotencyKeyPrefix = "idempotency:"
+
+// lockIdempotencyScript claims a key that has no record.
+// KEYS: record key. ARGV: fingerprint, token, lock TTL in milliseconds.
+// Returns 1 when claimed and 0 when a record exists.
+var lockIdempotencyScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "fingerprint", ARGV[1], "token", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+// completeIdempotencyScript stores the response of a claim still held by
+// token.
+// KEYS: record key. ARGV: token, status, header JSON, body, TTL in milliseconds.
+var completeIdempotencyScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "token") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "completed", 1, "status", ARGV[2], "header", ARGV[3], "body", ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[5])
+return 1
+`)
+
+// releaseIdempotencyScript deletes an uncompleted claim held by token.
+// KEYS: record key. ARGV: token.
+var releaseIdempotencyScript = redis.NewScript(`
+local record = redis.call("HMGET", KEYS[1], "token", "completed")
+if record[1] == ARGV[1] and not record[2] then
+	redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Idempotency returns the Redis backed Idempotency-Key store.
+func (s *service) Idempotency() IdempotencyStore {
+	return &redisIdempotencyStore{db: s.db}
+}
+
+func (r *redisIdempotencyStore) Lock(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (string, *IdempotencyRecord, error) {
+	token, err := newIdempotencyToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	claimed, err := lockIdempotencyScript.Run(ctx, r.db, []string{idempotencyKeyPrefix + key},
+		fingerprint, token, lockTTL.Milliseconds()).Int()
+	if err != nil {
+		return "", nil, err
+	}
+	if claimed == 1 {
+		return token, nil, nil
+	}
+
+	fields, err := r.db.HGetAll(ctx, idempotencyKeyPrefix+key).Result()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(fields) == 0 {
+		// The record expired since the claim failed; try again.
+		return r.Lock(ctx, key, fingerprint, lockTTL)
+	}
+	record, err := decodeIdempotencyRecord(fields)
+	return "", record, err
+}
+
+func (r *redisIdempotencyStore) Complete(ctx context.Context, key, token string, record *IdempotencyRecord, ttl time.Duration) error {
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+	return completeIdempotencyScript.Run(ctx, r.db, []string{idempotencyKeyPrefix + key},
+		token, record.Status, header, record.Body, ttl.Milliseconds()).Err()
+}
+
+func (r *redisIdempotencyStore) Release(ctx context.Context, key, token string) error {
+	return releaseIdempotencyScript.Run(ctx, r.db, []string{idempotencyKeyPrefix + key}, token).Err()
+}
+
+func decodeIdempotencyRecord(fields map[string]string) (*IdempotencyRecord, error) {
+	record := &IdempotencyRecord{
+		Fingerprint: fields["fingerprint"],
+		Completed:   fields["completed"] == "1",
+	}
+	if !record.Completed {
+		return record, nil
+	}
+
+	status, err := strconv.Atoi(fields["status"])
+	if err != nil {
+		return nil, fmt.Errorf("idempotency record: invalid status: %w", err)
+	}
+	record.Status = status
+	if err := json.Unmarshal([]byte(fields["header"]), &record.Header); err != nil {
+		return nil, fmt.Errorf("idempotency record: invalid header: %w", err)
+	}
+	record.Body = []byte(fields["body"])
+	return record, nil
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore for tests and local
+// development.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	records map[string]*memoryIdempotencyRecord
+}
+
+type memoryIdempotencyRecord struct {
+	IdempotencyRecord
+	token   string
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore returns an empty in-memory store.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		now:     time.Now,
+		records: make(map[string]*memoryIdempotencyRecord),
+	}
+}
+
+func (m *MemoryIdempotencyStore) Lock(ctx context.Context, key, fingerprint string, lockTTL time.Duration) (string, *IdempotencyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	// Expired claims and responses are swept on every Lock.
+	for k, record := range m.records {
+		if !now.Before(record.expires) {
+			delete(m.records, k)
+		}
+	}
+
+	if record, ok := m.records[key]; ok {
+		existing := record.IdempotencyRecord
+		existing.Header = maps.Clone(existing.Header)
+		existing.Body = slices.Clone(existing.Body)
+		return "", &existing, nil
+	}
+
+	token, err := newIdempotencyToken()
+	if err != nil {
+		return "", nil, err
+	}
+	m.records[key] = &memoryIdempotencyRecord{
+		IdempotencyRecord: IdempotencyRecord{Fingerprint: fingerprint},
+		token:             token,
+		expires:           now.Add(lockTTL),
+	}
+	return token, nil, nil
+}
+
+func (m *MemoryIdempotencyStore) Complete(ctx context.Context, key, token string, record *IdempotencyRecord, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.records[key]
+	if !ok || stored.token != token {
+		return nil
+	}
+
+	stored.Completed = true
+	stored.Status = record.Status
+	stored.Header = maps.Clone(record.Header)
+	stored.Body = slices.Clone(record.Body)
+	stored.expires = m.now().Add(ttl)
+	return nil
+}
+
+func (m *MemoryIdempotencyStore) Release(ctx context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.records[key]; ok && stored.token == token && !stored.Completed {
+		delete(m.records, key)
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// JobStatus is the lifecycle state of a Job. Jobs move from queued to
+// running to one of the finished states, or straight to canceled.
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+// Finished reports whether s is a final state.
+func (s JobStatus) Finished() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCanceled
+}
+
+// Job is an asynchronous computation. Progress is between 0 and 1; Result is
+// set when the job succeeds and Error when it fails. Owner is the subject
+// that submitted the job, or empty for jobs submitted without credentials.
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Owner      string          `json:"-"`
+	Status     JobStatus       `json:"status"`
+	Progress   float64         `json:"progress"`
+	Input      json.RawMessage `json:"input,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// ErrJobFinished is returned when a job can no longer change state, such as
+// canceling a job that already succeeded.
+var ErrJobFinished = errors.New("database: job already finished")
+
+// JobRepository stores jobs. State changes are atomic so any replica can
+// report or cancel a job run by another. Methods return ErrNotFound for
+// missing jobs.
+type JobRepository interface {
+	// Create stores a queued job, setting its Status and CreatedAt.
+	Create(ctx context.Context, job *Job) error
+	Get(ctx context.Context, id string) (*Job, error)
+	// Start marks a queued job running. It returns ErrJobFinished if the
+	// job was canceled while queued.
+	Start(ctx context.Context, id string) (*Job, error)
+	// SetProgress records the progress of a running job. It returns
+	// ErrJobFinished if the job was canceled.
+	SetProgress(ctx context.Context, id string, progress float64) error
+	// Heartbeat records that a running job is still being run. Start and
+	// SetProgress record one too. It returns ErrJobFinished if the job is
+	// no longer running.
+	Heartbeat(ctx context.Context, id string) error
+	// Finish moves a queued or running job to status, which must be a
+	// finished state, with its result or error. It returns ErrJobFinished
+	// if the job had already finished, including by being canceled.
+	Finish(ctx context.Context, id string, status JobStatus, result json.RawMessage, errMsg string) (*Job, error)
+	// FailStale fails the running jobs whose last heartbeat was before t
+	// with errMsg, and returns them.
+	FailStale(ctx context.Context, before time.Time, errMsg string) ([]*Job, error)
+	// DeleteFinished removes jobs that finished before t and returns how
+	// many were removed.
+	DeleteFinished(ctx context.Context, before time.Time) (int, error)
+}
+
+// redisJobRepository stores each job as a hash. Running jobs are indexed in
+// a sorted set by last heartbeat, and finished jobs in another by finish
+// time for cleanup. Every key shares the {jobs} hash
+// tag so scripts can update them atomically in Redis Cluster.
+type redisJobRepository struct {
+	db redis.UniversalClient
+}
+
+const (
+	jobsFinishedIndex = "{jobs}:finished"
+	jobsRunningIndex  = "{jobs}:running"
+	jobKeyPrefix      = "{jobs}:job:"
+)
+
+func jobKey(id string) string { return jobKeyPrefix + id }
+
+// transitionJobScript changes the status of a job if its current status is
+// one of those allowed, setting extra fields. Finished jobs are moved from
+// the running index to the finished index, and running jobs have their
+// heartbeat recorded.
+// KEYS: job key, finished index, running index. ARGV: space separated allowed
+// statuses, new status, finish time in unix milliseconds or "" when not
+// finishing, the current time in unix milliseconds, then field/value pairs.
+// Returns false when the job does not exist, and the status before the
+// transition otherwise; the transition happened if that status is allowed.
+var transitionJobScript = redis.NewScript(`
+local status = redis.call("HGET", KEYS[1], "status")
+if not status then
+	return false
+end
+if not string.find(" " .. ARGV[1] .. " ", " " .. status .. " ", 1, true) then
+	return status
+end
+redis.call("HSET", KEYS[1], "status", ARGV[2])
+if #ARGV > 4 then
+	redis.call("HSET", KEYS[1], unpack(ARGV, 5))
+end
+if ARGV[3] ~= "" then
+	redis.call("ZADD", KEYS[2], ARGV[3], KEYS[1])
+	redis.call("ZREM", KEYS[3], KEYS[1])
+elseif ARGV[2] == "running" then
+	redis.call("ZADD", KEYS[3], ARGV[4], KEYS[1])
+end
+return status
+`)
+
+// failStaleJobsScript fails the jobs given in KEYS that are still running
+// with a heartbeat up to a time. The jobs are read from the running index
+// before the script runs, so every key it changes is declared in KEYS.
+// KEYS: running index, finished index, then job keys. ARGV: heartbeat cutoff
+// and the current time in unix milliseconds, the finish time as RFC 3339,
+// then the error.
+// Returns the keys of the jobs failed.
+var failStaleJobsScript = redis.NewScript(`
+local failed = {}
+for i = 3, #KEYS do
+	local heartbeat = redis.call("ZSCORE", KEYS[1], KEYS[i])
+	if heartbeat and tonumber(heartbeat) <= tonumber(ARGV[1]) then
+		redis.call("ZREM", KEYS[1], KEYS[i])
+		if redis.call("HGET", KEYS[i], "status") == "running" then
+			redis.call("HSET", KEYS[i], "status", "failed", "finishedAt", ARGV[3], "error", ARGV[4])
+			redis.call("ZADD", KEYS[2], ARGV[2], KEYS[i])
+			table.insert(failed, KEYS[i])
+		end
+	end
+end
+return failed
+`)
+
+// deleteFinishedJobsScript deletes the jobs given in KEYS that are still
+// indexed as finished up to a time. The jobs are read from the index before
+// the script runs, so every key it deletes is declared in KEYS.
+// KEYS: finished index, then job keys. ARGV: unix milliseconds.
+// Returns the number of jobs deleted.
+var deleteFinishedJobsScript = redis.NewScript(`
+local deleted = 0
+for i = 2, #KEYS do
+	local finished = redis.call("ZSCORE", KEYS[1], KEYS[i])
+	if finished and tonumber(finished) <= tonumber(ARGV[1]) then
+		redis.call("DEL", KEYS[i])
+		redis.call("ZREM", KEYS[1], KEYS[i])
+		deleted = deleted + 1
+	end
+end
+return deleted
+`)
+
+// deleteFinishedBatch is how many jobs DeleteFinished and FailStale pass to
+// each run of their script, bounding how long Redis is blocked.
+const deleteFinishedBatch = 100
+
+// Jobs returns the Redis backed job repository.
+func (s *service) Jobs() JobRepository {
+	return &redisJobRepository{db: s.db}
+}
+
+func (r *redisJobRepository) Create(ctx context.Context, job *Job) error {
+	job.Status = JobQueued
+	job.CreatedAt = time.Now().UTC()
+
+	return r.db.HSet(ctx, jobKey(job.ID), "id", job.ID, "type", job.Type, "owner", job.Owner, "status", string(job.Status),
+		"progress", 0, "input", string(job.Input), "createdAt", job.CreatedAt.Format(time.RFC3339Nano)).Err()
+}
+
+func (r *redisJobRepository) Get(ctx context.Context, id string) (*Job, error) {
+	fields, err := r.db.HGetAll(ctx, jobKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrNotFound
+	}
+	return decodeJob(fields)
+}
+
+func (r *redisJobRepository) Start(ctx context.Context, id string) (*Job, error) {
+	now := time.Now().UTC()
+	if err := r.transition(ctx, id, []JobStatus{JobQueued}, JobRunning, "startedAt", now.Format(time.RFC3339Nano)); err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, id)
+}
+
+func (r *redisJobRepository) SetProgress(ctx context.Context, id string, progress float64) error {
+	return r.transition(ctx, id, []JobStatus{JobRunning}, JobRunning, "progress", strconv.FormatFloat(progress, 'f', -1, 64))
+}
+
+func (r *redisJobRepository) Heartbeat(ctx context.Context, id string) error {
+	return r.transition(ctx, id, []JobStatus{JobRunning}, JobRunning)
+}
+
+func (r *redisJobRepository) Finish(ctx context.Context, id string, status JobStatus, result json.RawMessage, errMsg string) (*Job, error) {
+	if !status.Finished() {
+		return nil, fmt.Errorf("database: %s is not a finished job status", status)
+	}
+
+	fields := []any{"finishedAt", time.Now().UTC().Format(time.RFC3339Nano), "result", string(result), "error", errMsg}
+	if status == JobSucceeded {
+		fields = append(fields, "progress", 1)
+	}
+	if err := r.transition(ctx, id, []JobStatus{JobQueued, JobRunning}, status, fields...); err != nil {
+		return nil, err
+	}
+	return r.Get(ctx, id)
+}
+
+func (r *redisJobRepository) DeleteFinished(ctx context.Context, before time.Time) (int, error) {
+	cutoff := strconv.FormatInt(before.UnixMilli(), 10)
+	deleted := 0
+	for {
+		keys, err := r.db.ZRangeArgs(ctx, redis.ZRangeArgs{
+			Key: jobsFinishedIndex, Start: "-inf", Stop: cutoff, ByScore: true, Count: deleteFinishedBatch,
+		}).Result()
+		if err != nil || len(keys) == 0 {
+			return deleted, err
+		}
+
+		n, err := deleteFinishedJobsScript.Run(ctx, r.db, append([]string{jobsFinishedIndex}, keys...), cutoff).Int()
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+		if n == 0 || len(keys) < deleteFinishedBatch {
+			return deleted, nil
+		}
+	}
+}
+
+func (r *redisJobRepository) FailStale(ctx context.Context, before time.Time, errMsg string) ([]*Job, error) {
+	cutoff := strconv.FormatInt(before.UnixMilli(), 10)
+	var failed []*Job
+	for {
+		keys, err := r.db.ZRangeArgs(ctx, redis.ZRangeArgs{
+			Key: jobsRunningIndex, Start: "-inf", Stop: cutoff, ByScore: true, Count: deleteFinishedBatch,
+		}).Result()
+		if err != nil || len(keys) == 0 {
+			return failed, err
+		}
+
+		now := time.Now().UTC()
+		stale, err := failStaleJobsScript.Run(ctx, r.db, append([]string{jobsRunningIndex, jobsFinishedIndex}, keys...),
+			cutoff, now.UnixMilli(), now.Format(time.RFC3339Nano), errMsg).StringSlice()
+		if err != nil {
+			return failed, err
+		}
+		for _, key := range stale {
+			job, err := r.Get(ctx, strings.TrimPrefix(key, jobKeyPrefix))
+			if err != nil {
+				return failed, err
+			}
+			failed = append(failed, job)
+		}
+		if len(keys) < deleteFinishedBatch {
+			return failed, nil
+		}
+	}
+}
+
+func (r *redisJobRepository) transition(ctx context.Context, id string, from []JobStatus, to JobStatus, fields ...any) error {
+	allowed := ""
+	for _, status := range from {
+		allowed += string(status) + " "
+	}
+	finishedAt := ""
+	if to.Finished() {
+		finishedAt = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	}
+
+	args := append([]any{allowed, string(to), finishedAt, time.Now().UnixMilli()}, fields...)
+	prev, err := transitionJobScript.Run(ctx, r.db, []string{jobKey(id), jobsFinishedIndex, jobsRunningIndex}, args...).Text()
+	if errors.Is(err, redis.Nil) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(from, JobStatus(prev)) {
+		return ErrJobFinished
+	}
+	return nil
+}
+
+func decodeJob(fields map[string]string) (*Job, error) {
+	job := &Job{
+		ID:     fields["id"],
+		Type:   fields["type"],
+		Owner:  fields["owner"],
+		Status: JobStatus(fields["status"]),
+		Error:  fields["error"],
+	}
+	if v := fields["input"]; v != "" {
+		job.Input = json.RawMessage(v)
+	}
+	if v := fields["result"]; v != "" {
+		job.Result = json.RawMessage(v)
+	}
+
+	var err error
+	if job.Progress, err = strconv.ParseFloat(fields["progress"], 64); err != nil {
+		return nil, fmt.Errorf("job %s: invalid progress: %w", job.ID, err)
+	}
+	if job.CreatedAt, err = time.Parse(time.RFC3339Nano, fields["createdAt"]); err != nil {
+		return nil, fmt.Errorf("job %s: invalid createdAt: %w", job.ID, err)
+	}
+	for name, dst := range map[string]**time.Time{"startedAt": &job.StartedAt, "finishedAt": &job.FinishedAt} {
+		v, ok := fields[name]
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: invalid %s: %w", job.ID, name, err)
+		}
+		*dst = &t
+	}
+	return job, nil
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryJobRepository is an in-memory JobRepository for tests and local
+// development.
+type MemoryJobRepository struct {
+	mu   sync.RWMutex
+	jobs map[string]Job
+	// heartbeats holds the last heartbeat of each running job.
+	heartbeats map[string]time.Time
+}
+
+// NewMemoryJobRepository returns an empty in-memory repository.
+func NewMemoryJobRepository() *MemoryJobRepository {
+	return &MemoryJobRepository{jobs: make(map[string]Job), heartbeats: make(map[string]time.Time)}
+}
+
+func (m *MemoryJobRepository) Create(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job.Status = JobQueued
+	job.CreatedAt = time.Now().UTC()
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+func (m *MemoryJobRepository) Get(ctx context.Context, id string) (*Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+func (m *MemoryJobRepository) Start(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if job.Status != JobQueued {
+		return nil, ErrJobFinished
+	}
+
+	now := time.Now().UTC()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	m.jobs[id] = job
+	m.heartbeats[id] = now
+	return &job, nil
+}
+
+func (m *MemoryJobRepository) SetProgress(ctx context.Context, id string, progress float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if job.Status != JobRunning {
+		return ErrJobFinished
+	}
+
+	job.Progress = progress
+	m.jobs[id] = job
+	m.heartbeats[id] = time.Now()
+	return nil
+}
+
+func (m *MemoryJobRepository) Heartbeat(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if job.Status != JobRunning {
+		return ErrJobFinished
+	}
+
+	m.heartbeats[id] = time.Now()
+	return nil
+}
+
+func (m *MemoryJobRepository) Finish(ctx context.Context, id string, status JobStatus, result json.RawMessage, errMsg string) (*Job, error) {
+	if !status.Finished() {
+		return nil, fmt.Errorf("database: %s is not a finished job status", status)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if job.Status.Finished() {
+		return nil, ErrJobFinished
+	}
+
+	now := time.Now().UTC()
+	job.Status = status
+	job.Result = result
+	job.Error = errMsg
+	job.FinishedAt = &now
+	if status == JobSucceeded {
+		job.Progress = 1
+	}
+	m.jobs[id] = job
+	delete(m.heartbeats, id)
+	return &job, nil
+}
+
+func (m *MemoryJobRepository) FailStale(ctx context.Context, before time.Time, errMsg string) ([]*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var failed []*Job
+	now := time.Now().UTC()
+	for id, heartbeat := range m.heartbeats {
+		if heartbeat.After(before) {
+			continue
+		}
+		job := m.jobs[id]
+		job.Status = JobFailed
+		job.Error = errMsg
+		job.FinishedAt = &now
+		m.jobs[id] = job
+		delete(m.heartbeats, id)
+		failed = append(failed, &job)
+	}
+	return failed, nil
+}
+
+func (m *MemoryJobRepository) DeleteFinished(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && !job.FinishedAt.After(before) {
+			delete(m.jobs, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrLockHeld is returned by Acquire when another holder has the lock.
+	ErrLockHeld = errors.New("database: lock is held")
+	// ErrLockLost is returned when a lock has expired or been taken by
+	// another holder.
+	ErrLockLost = errors.New("database: lock was lost")
+)
+
+// Lock is a held lock.
+type Lock struct {
+	Name string
+	// Token is a fencing token that increases every time Name is acquired.
+	// Storage written under the lock can reject writes carrying a token
+	// older than the last it saw, from a holder whose lock expired while it
+	// was paused.
+	Token int64
+	// owner identifies the holder, so only it can renew or release the lock.
+	owner string
+}
+
+// Locker provides locks shared between replicas. Locks expire after their
+// TTL unless renewed, so a replica that crashes does not keep its locks.
+type Locker interface {
+	// Acquire takes the lock name for ttl. It returns ErrLockHeld when
+	// another holder has it.
+	Acquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error)
+	// Renew makes lock expire ttl from now. It returns ErrLockLost when the
+	// lock is no longer held.
+	Renew(ctx context.Context, lock *Lock, ttl time.Duration) error
+	// Release frees lock. It returns ErrLockLost when the lock is no longer
+	// held.
+	Release(ctx context.Context, lock *Lock) error
+}
+
+// KeepAlive renews lock every third of ttl until ctx is done, returning
+// ctx.Err(), or until the lock is lost, returning ErrLockLost. Renewals that
+// fail with other errors are retried while the lock has not expired.
+func KeepAlive(ctx context.Context, locker Locker, lock *Lock, ttl time.Duration) error {
+	interval := ttl / 3
+	expires := time.Now().Add(ttl)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		err := locker.Renew(ctx, lock, ttl)
+		switch {
+		case err == nil:
+			expires = start.Add(ttl)
+		case errors.Is(err, ErrLockLost):
+			return err
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case time.Until(expires) <= interval:
+			// The lock may expire before the next attempt, when another
+			// replica could take it.
+			return fmt.Errorf("%w: %w", ErrLockLost, err)
+		}
+	}
+}
+
+// redisLocker stores each lock under <namespace>:locks:{<name>} with the
+// owner as the value and the TTL as the key expiry, and its fencing token
+// under the same key with a :token suffix. The hash tag keeps both keys in
+// one Cluster slot.
+type redisLocker struct {
+	db     redis.UniversalClient
+	prefix string
+}
+
+// Locks returns the Redis backed locker. Locks are held on a single Redis
+// primary, so a failover that loses recent writes can grant a lock twice;
+// fencing tokens let storage detect that.
+func (s *service) Locks() Locker {
+	return &redisLocker{db: s.db, prefix: s.keyNamespace + ":locks:"}
+}
+
+func (l *redisLocker) key(name string) string {
+	return l.prefix + "{" + name + "}"
+}
+
+// lockAcquireScript sets the lock to the owner in ARGV[1] for ARGV[2]
+// milliseconds unless it is held.
+// Returns the new fencing token, or nil when the lock is held.
+var lockAcquireScript = redis.NewScript(`
+if not redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return false
+end
+return redis.call("INCR", KEYS[2])
+`)
+
+// lockRenewScript sets the TTL of the lock to ARGV[2] milliseconds if it is
+// held by the owner in ARGV[1].
+// Returns 1, or 0 when the lock is not held by the owner.
+var lockRenewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+`)
+
+// lockReleaseScript deletes the lock if it is held by the owner in ARGV[1].
+// Returns 1, or 0 when the lock is not held by the owner.
+var lockReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call("DEL", KEYS[1])
+`)
+
+func (l *redisLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	owner := uuid.NewString()
+	key := l.key(name)
+	token, err := lockAcquireScript.Run(ctx, l.db, []string{key, key + ":token"}, owner, ttl.Milliseconds()).Int64()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrLockHeld
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Lock{Name: name, Token: token, owner: owner}, nil
+}
+
+func (l *redisLocker) Renew(ctx context.Context, lock *Lock, ttl time.Duration) error {
+	renewed, err := lockRenewScript.Run(ctx, l.db, []string{l.key(lock.Name)}, lock.owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if renewed == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (l *redisLocker) Release(ctx context.Context, lock *Lock) error {
+	released, err := lockReleaseScript.Run(ctx, l.db, []string{l.key(lock.Name)}, lock.owner).Int64()
+	if err != nil {
+		return err
+	}
+	if released == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
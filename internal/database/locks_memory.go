@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryLocker is an in-memory Locker for tests and local development. Its
+// locks are only shared within the process.
+type MemoryLocker struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	locks  map[string]memoryLock
+	tokens map[string]int64
+}
+
+type memoryLock struct {
+	owner   string
+	expires time.Time
+}
+
+// NewMemoryLocker returns an in-memory locker without any locks held.
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{now: time.Now, locks: make(map[string]memoryLock), tokens: make(map[string]int64)}
+}
+
+// heldLocked reports whether owner holds the lock name, deleting it if it
+// has expired.
+func (m *MemoryLocker) heldLocked(name, owner string) bool {
+	l, ok := m.locks[name]
+	if ok && !m.now().Before(l.expires) {
+		delete(m.locks, name)
+		return false
+	}
+	return ok && l.owner == owner
+}
+
+func (m *MemoryLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.heldLocked(name, "")
+	if _, ok := m.locks[name]; ok {
+		return nil, ErrLockHeld
+	}
+	owner := uuid.NewString()
+	m.locks[name] = memoryLock{owner: owner, expires: m.now().Add(ttl)}
+	m.tokens[name]++
+	return &Lock{Name: name, Token: m.tokens[name], owner: owner}, nil
+}
+
+func (m *MemoryLocker) Renew(ctx context.Context, lock *Lock, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.heldLocked(lock.Name, lock.owner) {
+		return ErrLockLost
+	}
+	m.locks[lock.Name] = memoryLock{owner: lock.owner, expires: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryLocker) Release(ctx context.Context, lock *Lock) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.heldLocked(lock.Name, lock.owner) {
+		return ErrLockLost
+	}
+	delete(m.locks, lock.Name)
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package memory implements database.Service in memory, for local
+// development without Redis and for tests. Data lasts as long as the process
+// and is not shared between replicas, so it does not suit production.
+package memory
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"gofetch.timwalker.dev/internal/database"
+)
+
+// Service is an in-memory database.Service built from the in-memory
+// repositories of the database package.
+type Service struct {
+	*database.MemoryRateLimiter
+	apiKeys  *database.MemoryAPIKeyRepository
+	albums   *database.MemoryAlbumRepository
+	idem     *database.MemoryIdempotencyStore
+	jobs     *database.MemoryJobRepository
+	cache    *database.MemoryCache
+	counters *database.MemoryCounterStore
+	pubsub   *database.MemoryPubSub
+	locks    *database.MemoryLocker
+	counter  atomic.Int64
+}
+
+var _ database.Service = (*Service)(nil)
+
+// New returns an empty in-memory service.
+func New() *Service {
+	return &Service{
+		MemoryRateLimiter: database.NewMemoryRateLimiter(),
+		apiKeys:           database.NewMemoryAPIKeyRepository(),
+		albums:            database.NewMemoryAlbumRepository(),
+		idem:              database.NewMemoryIdempotencyStore(),
+		jobs:              database.NewMemoryJobRepository(),
+		cache:             database.NewMemoryCache(),
+		counters:          database.NewMemoryCounterStore(),
+		pubsub:            database.NewMemoryPubSub(),
+		locks:             database.NewMemoryLocker(),
+	}
+}
+
+// Ping always succeeds, unless ctx is done.
+func (s *Service) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (s *Service) IncrementCounter(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	count := s.counter.Add(1)
+	s.pubsub.Publish(ctx, database.CounterChannel, []byte(strconv.FormatInt(count, 10)))
+	return int(count), nil
+}
+
+func (s *Service) CounterValue(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return int(s.counter.Load()), nil
+}
+
+func (s *Service) APIKeys() database.APIKeyRepository {
+	return s.apiKeys
+}
+
+func (s *Service) Albums() database.AlbumRepository {
+	return s.albums
+}
+
+func (s *Service) Idempotency() database.IdempotencyStore {
+	return s.idem
+}
+
+func (s *Service) Jobs() database.JobRepository {
+	return s.jobs
+}
+
+func (s *Service) Cache() database.Cache {
+	return s.cache
+}
+
+func (s *Service) Counters() database.CounterStore {
+	return s.counters
+}
+
+func (s *Service) PubSub() database.PubSub {
+	return s.pubsub
+}
+
+func (s *Service) Locks() database.Locker {
+	return s.locks
+}
+
+// NumSub returns the number of subscriptions to channel, for tests.
+func (s *Service) NumSub(channel string) int {
+	return s.pubsub.NumSub(channel)
+}
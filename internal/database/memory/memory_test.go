@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/database/databasetest"
+)
+
+func TestService(t *testing.T) {
+	databasetest.Run(t, func(t *testing.T) database.Service {
+		return New()
+	})
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Message is a payload published to a channel.
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+// PubSub delivers messages to subscribers on every replica. Delivery is at
+// most once: subscribers miss messages published while they are
+// disconnected or too slow to keep up.
+type PubSub interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+	// Subscribe returns a subscription to channels once it is active, so
+	// messages published after Subscribe returns are received.
+	Subscribe(ctx context.Context, channels ...string) (Subscription, error)
+}
+
+// Subscription receives the messages of one Subscribe call.
+type Subscription interface {
+	// Messages returns the channel of received messages, which is closed
+	// by Close.
+	Messages() <-chan Message
+	Close() error
+}
+
+// redisPubSub publishes with PUBLISH. Each subscription holds its own
+// connection, which go-redis reconnects and resubscribes after failures.
+type redisPubSub struct {
+	db redis.UniversalClient
+}
+
+// PubSub returns the Redis backed pub/sub.
+func (s *service) PubSub() PubSub {
+	return &redisPubSub{db: s.db}
+}
+
+func (p *redisPubSub) Publish(ctx context.Context, channel string, payload []byte) error {
+	return p.db.Publish(ctx, channel, payload).Err()
+}
+
+func (p *redisPubSub) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	ps := p.db.Subscribe(ctx, channels...)
+	// Wait for a confirmation per channel so connection errors are returned
+	// here and no message published afterwards is missed.
+	// Messages on channels confirmed first are kept for delivery.
+	var early []*redis.Message
+	for confirmed := 0; confirmed < len(channels); {
+		reply, err := ps.Receive(ctx)
+		if err != nil {
+			ps.Close()
+			return nil, err
+		}
+		switch reply := reply.(type) {
+		case *redis.Subscription:
+			confirmed++
+		case *redis.Message:
+			early = append(early, reply)
+		}
+	}
+
+	sub := &redisSubscription{ps: ps, messages: make(chan Message), done: make(chan struct{})}
+	go func() {
+		defer close(sub.messages)
+		deliver := func(msg *redis.Message) bool {
+			select {
+			case sub.messages <- Message{Channel: msg.Channel, Payload: []byte(msg.Payload)}:
+				return true
+			case <-sub.done:
+				return false
+			}
+		}
+		for _, msg := range early {
+			if !deliver(msg) {
+				return
+			}
+		}
+		for msg := range ps.Channel() {
+			if !deliver(msg) {
+				return
+			}
+		}
+	}()
+	return sub, nil
+}
+
+type redisSubscription struct {
+	ps       *redis.PubSub
+	messages chan Message
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (s *redisSubscription) Messages() <-chan Message {
+	return s.messages
+}
+
+func (s *redisSubscription) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return s.ps.Close()
+}
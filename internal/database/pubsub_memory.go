@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"sync"
+)
+
+// memorySubscriptionBuffer is how many messages a MemorySubscription holds
+// before newer ones are dropped.
+const memorySubscriptionBuffer = 64
+
+// MemoryPubSub is an in-memory PubSub for tests and local development. It only
+// reaches subscribers in the same process.
+type MemoryPubSub struct {
+	mu   sync.Mutex
+	subs map[string]map[*memorySubscription]struct{}
+}
+
+// NewMemoryPubSub returns a pub/sub without subscribers.
+func NewMemoryPubSub() *MemoryPubSub {
+	return &MemoryPubSub{subs: make(map[string]map[*memorySubscription]struct{})}
+}
+
+func (p *MemoryPubSub) Publish(ctx context.Context, channel string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for sub := range p.subs[channel] {
+		select {
+		case sub.messages <- Message{Channel: channel, Payload: payload}:
+		default:
+			// The subscriber is too slow; like Redis, drop the message.
+		}
+	}
+	return nil
+}
+
+func (p *MemoryPubSub) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	sub := &memorySubscription{
+		pubsub:   p,
+		channels: channels,
+		messages: make(chan Message, memorySubscriptionBuffer),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, channel := range channels {
+		if p.subs[channel] == nil {
+			p.subs[channel] = make(map[*memorySubscription]struct{})
+		}
+		p.subs[channel][sub] = struct{}{}
+	}
+	return sub, nil
+}
+
+// NumSub returns the number of subscriptions to channel, like PUBSUB NUMSUB.
+func (p *MemoryPubSub) NumSub(channel string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.subs[channel])
+}
+
+type memorySubscription struct {
+	pubsub   *MemoryPubSub
+	channels []string
+	messages chan Message
+	closed   bool
+}
+
+func (s *memorySubscription) Messages() <-chan Message {
+	return s.messages
+}
+
+func (s *memorySubscription) Close() error {
+	p := s.pubsub
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for _, channel := range s.channels {
+		delete(p.subs[channel], s)
+		if len(p.subs[channel]) == 0 {
+			delete(p.subs, channel)
+		}
+	}
+	close(s.messages)
+	return nil
+}
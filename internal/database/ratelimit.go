@@ -0,0 +1,186 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitAlgorithm names a rate limiting strategy.
+type RateLimitAlgorithm string
+
+const (
+	// FixedWindow counts requests in discrete windows that reset on expiry.
+	FixedWindow RateLimitAlgorithm = "fixed-window"
+	// SlidingWindow counts requests made within the trailing window.
+	SlidingWindow RateLimitAlgorithm = "sliding-window"
+	// TokenBucket allows bursts up to the limit, refilling over the window.
+	TokenBucket RateLimitAlgorithm = "token-bucket"
+)
+
+// ParseRateLimitAlgorithm returns the algorithm matching name.
+func ParseRateLimitAlgorithm(name string) (RateLimitAlgorithm, error) {
+	switch alg := RateLimitAlgorithm(name); alg {
+	case FixedWindow, SlidingWindow, TokenBucket:
+		return alg, nil
+	default:
+		return "", fmt.Errorf("unknown rate limit algorithm %q", name)
+	}
+}
+
+// RateLimitPolicy allows Limit requests per Window using Algorithm.
+type RateLimitPolicy struct {
+	Algorithm RateLimitAlgorithm
+	Limit     int
+	Window    time.Duration
+}
+
+// RateLimitResult is the outcome of a single rate limit check.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the quota is fully restored.
+	Reset time.Duration
+	// RetryAfter is the time until the next request would be allowed.
+	// It is zero when the request was allowed.
+	RetryAfter time.Duration
+}
+
+// fixedWindowScript increments the window counter, starting the window
+// expiry on the first request.
+// Returns {count, pttl}.
+var fixedWindowScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+// slidingWindowScript keeps a sorted set log of request timestamps,
+// trimming entries older than the window before counting.
+// Returns {allowed, count, reset_ms, retry_ms}.
+var slidingWindowScript = redis.NewScript(`
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call("PEXPIRE", KEYS[1], window)
+
+local reset = window
+local retry = 0
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+	if allowed == 0 then
+		retry = reset
+	end
+end
+return {allowed, count, reset, retry}
+`)
+
+// tokenBucketScript refills the bucket based on the elapsed time since the
+// last request, then takes a token if one is available.
+// Returns {allowed, tokens, reset_ms, retry_ms}.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local rate = capacity / window
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1]) or capacity
+local ts = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], window)
+
+local reset = math.ceil((capacity - tokens) / rate)
+return {allowed, math.floor(tokens), reset, retry}
+`)
+
+// RateLimit atomically records a request against key and reports whether it
+// is within the policy.
+func (s *service) RateLimit(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	key = "ratelimit:" + string(policy.Algorithm) + ":" + key
+	window := policy.Window.Milliseconds()
+	if window <= 0 || policy.Limit <= 0 {
+		return RateLimitResult{}, fmt.Errorf("invalid rate limit policy: %+v", policy)
+	}
+
+	switch policy.Algorithm {
+	case FixedWindow:
+		vals, err := fixedWindowScript.Run(ctx, s.db, []string{key}, window).Int64Slice()
+		if err != nil {
+			return RateLimitResult{}, fmt.Errorf("fixed window rate limit: %w", err)
+		}
+		count, ttl := int(vals[0]), time.Duration(vals[1])*time.Millisecond
+		result := RateLimitResult{
+			Allowed:   count <= policy.Limit,
+			Limit:     policy.Limit,
+			Remaining: max(policy.Limit-count, 0),
+			Reset:     ttl,
+		}
+		if !result.Allowed {
+			result.RetryAfter = ttl
+		}
+		return result, nil
+
+	case SlidingWindow:
+		vals, err := slidingWindowScript.Run(ctx, s.db, []string{key}, window, policy.Limit, uuid.NewString()).Int64Slice()
+		if err != nil {
+			return RateLimitResult{}, fmt.Errorf("sliding window rate limit: %w", err)
+		}
+		return RateLimitResult{
+			Allowed:    vals[0] == 1,
+			Limit:      policy.Limit,
+			Remaining:  max(policy.Limit-int(vals[1]), 0),
+			Reset:      time.Duration(vals[2]) * time.Millisecond,
+			RetryAfter: time.Duration(vals[3]) * time.Millisecond,
+		}, nil
+
+	case TokenBucket:
+		vals, err := tokenBucketScript.Run(ctx, s.db, []string{key}, policy.Limit, window).Int64Slice()
+		if err != nil {
+			return RateLimitResult{}, fmt.Errorf("token bucket rate limit: %w", err)
+		}
+		return RateLimitResult{
+			Allowed:    vals[0] == 1,
+			Limit:      policy.Limit,
+			Remaining:  int(vals[1]),
+			Reset:      time.Duration(vals[2]) * time.Millisecond,
+			RetryAfter: time.Duration(vals[3]) * time.Millisecond,
+		}, nil
+
+	default:
+		return RateLimitResult{}, fmt.Errorf("unknown rate limit algorithm %q", policy.Algorithm)
+	}
+}
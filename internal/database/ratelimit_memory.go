@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryRateLimiter implements the rate limiting algorithms in process.
+// It is used as a fallback when Redis is unavailable, so limits are only
+// enforced per instance.
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	windows   map[string]*memoryWindow
+	logs      map[string]*memoryLog
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryWindow struct {
+	count   int
+	expires time.Time
+}
+
+type memoryLog struct {
+	times   []time.Time
+	expires time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	ts      time.Time
+	expires time.Time
+}
+
+// sweepInterval is how often expired entries are evicted.
+const sweepInterval = time.Minute
+
+// NewMemoryRateLimiter returns an empty in-memory rate limiter.
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		now:       time.Now,
+		windows:   make(map[string]*memoryWindow),
+		logs:      make(map[string]*memoryLog),
+		buckets:   make(map[string]*memoryBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// RateLimit records a request against key and reports whether it is within
+// the policy.
+func (m *MemoryRateLimiter) RateLimit(ctx context.Context, key string, policy RateLimitPolicy) (RateLimitResult, error) {
+	if policy.Window <= 0 || policy.Limit <= 0 {
+		return RateLimitResult{}, fmt.Errorf("invalid rate limit policy: %+v", policy)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		m.sweep(now)
+	}
+
+	switch policy.Algorithm {
+	case FixedWindow:
+		return m.fixedWindow(now, key, policy), nil
+	case SlidingWindow:
+		return m.slidingWindow(now, key, policy), nil
+	case TokenBucket:
+		return m.tokenBucket(now, key, policy), nil
+	default:
+		return RateLimitResult{}, fmt.Errorf("unknown rate limit algorithm %q", policy.Algorithm)
+	}
+}
+
+func (m *MemoryRateLimiter) fixedWindow(now time.Time, key string, policy RateLimitPolicy) RateLimitResult {
+	w, ok := m.windows[key]
+	if !ok || !now.Before(w.expires) {
+		w = &memoryWindow{expires: now.Add(policy.Window)}
+		m.windows[key] = w
+	}
+	w.count++
+
+	result := RateLimitResult{
+		Allowed:   w.count <= policy.Limit,
+		Limit:     policy.Limit,
+		Remaining: max(policy.Limit-w.count, 0),
+		Reset:     w.expires.Sub(now),
+	}
+	if !result.Allowed {
+		result.RetryAfter = result.Reset
+	}
+	return result
+}
+
+func (m *MemoryRateLimiter) slidingWindow(now time.Time, key string, policy RateLimitPolicy) RateLimitResult {
+	l, ok := m.logs[key]
+	if !ok {
+		l = &memoryLog{}
+		m.logs[key] = l
+	}
+	cutoff := now.Add(-policy.Window)
+	i := 0
+	for i < len(l.times) && !l.times[i].After(cutoff) {
+		i++
+	}
+	l.times = l.times[i:]
+
+	result := RateLimitResult{Limit: policy.Limit}
+	if len(l.times) < policy.Limit {
+		l.times = append(l.times, now)
+		result.Allowed = true
+	}
+	l.expires = now.Add(policy.Window)
+
+	result.Remaining = max(policy.Limit-len(l.times), 0)
+	result.Reset = l.times[0].Add(policy.Window).Sub(now)
+	if !result.Allowed {
+		result.RetryAfter = result.Reset
+	}
+	return result
+}
+
+func (m *MemoryRateLimiter) tokenBucket(now time.Time, key string, policy RateLimitPolicy) RateLimitResult {
+	capacity := float64(policy.Limit)
+	rate := capacity / float64(policy.Window)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: capacity, ts: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.ts))*rate)
+	b.ts = now
+	b.expires = now.Add(policy.Window)
+
+	result := RateLimitResult{Limit: policy.Limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration(math.Ceil((1 - b.tokens) / rate))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration(math.Ceil((capacity - b.tokens) / rate))
+	return result
+}
+
+// sweep evicts entries that have been idle for longer than their window.
+func (m *MemoryRateLimiter) sweep(now time.Time) {
+	for key, w := range m.windows {
+		if !now.Before(w.expires) {
+			delete(m.windows, key)
+		}
+	}
+	for key, l := range m.logs {
+		if !now.Before(l.expires) {
+			delete(m.logs, key)
+		}
+	}
+	for key, b := range m.buckets {
+		if !now.Before(b.expires) {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
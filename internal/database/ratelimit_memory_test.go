@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestRateLimiter returns a limiter with a controllable clock.
+func newTestRateLimiter() (*MemoryRateLimiter, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemoryRateLimiter()
+	m.now = func() time.Time { return now }
+	m.lastSweep = now
+	return m, &now
+}
+
+func TestMemoryRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	for _, alg := range []RateLimitAlgorithm{FixedWindow, SlidingWindow, TokenBucket} {
+		t.Run(string(alg), func(t *testing.T) {
+			m, now := newTestRateLimiter()
+			policy := RateLimitPolicy{Algorithm: alg, Limit: 3, Window: time.Minute}
+
+			for i := range 3 {
+				res, err := m.RateLimit(ctx, "client", policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !res.Allowed {
+					t.Fatalf("request %d: expected allowed", i)
+				}
+				if res.Remaining != 2-i {
+					t.Errorf("request %d: remaining got %d want %d", i, res.Remaining, 2-i)
+				}
+			}
+
+			res, err := m.RateLimit(ctx, "client", policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Allowed {
+				t.Fatal("expected request over the limit to be rejected")
+			}
+			if res.RetryAfter <= 0 || res.RetryAfter > policy.Window {
+				t.Errorf("retry after got %v, want within (0, %v]", res.RetryAfter, policy.Window)
+			}
+
+			*now = now.Add(policy.Window)
+			res, err = m.RateLimit(ctx, "client", policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !res.Allowed {
+				t.Error("expected request to be allowed after the window")
+			}
+		})
+	}
+
+	t.Run("token_bucket_refills_gradually", func(t *testing.T) {
+		m, now := newTestRateLimiter()
+		policy := RateLimitPolicy{Algorithm: TokenBucket, Limit: 6, Window: time.Minute}
+
+		for range 6 {
+			m.RateLimit(ctx, "client", policy)
+		}
+		*now = now.Add(10 * time.Second)
+
+		res, _ := m.RateLimit(ctx, "client", policy)
+		if !res.Allowed {
+			t.Error("expected one token to refill after 10s")
+		}
+		res, _ = m.RateLimit(ctx, "client", policy)
+		if res.Allowed {
+			t.Error("expected the bucket to be empty again")
+		}
+	})
+
+	t.Run("sliding_window_does_not_reset_at_boundary", func(t *testing.T) {
+		m, now := newTestRateLimiter()
+		policy := RateLimitPolicy{Algorithm: SlidingWindow, Limit: 2, Window: time.Minute}
+
+		m.RateLimit(ctx, "client", policy)
+		*now = now.Add(50 * time.Second)
+		m.RateLimit(ctx, "client", policy)
+		*now = now.Add(20 * time.Second)
+
+		res, _ := m.RateLimit(ctx, "client", policy)
+		if !res.Allowed {
+			t.Error("expected the first request to have left the window")
+		}
+		res, _ = m.RateLimit(ctx, "client", policy)
+		if res.Allowed {
+			t.Error("expected the second request to still count")
+		}
+	})
+
+	t.Run("rejects_invalid_policy", func(t *testing.T) {
+		m, _ := newTestRateLimiter()
+		if _, err := m.RateLimit(ctx, "client", RateLimitPolicy{Algorithm: FixedWindow}); err == nil {
+			t.Error("expected an error for a zero policy")
+		}
+	})
+}
@@ -0,0 +1,210 @@
+// Package fibonacci computes Fibonacci numbers with several algorithms, from
+// the exponential naive recursion used to emulate CPU-heavy work to matrix
+// exponentiation. Every algorithm stops when its context is done.
+package fibonacci
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+// Algorithm names a way of computing Fibonacci numbers.
+type Algorithm string
+
+const (
+	// Naive is the exponential recursion, kept for CPU benchmarking.
+	Naive Algorithm = "naive"
+	// Iterative sums pairs in a loop, taking n additions.
+	Iterative Algorithm = "iterative"
+	// Memo is top-down recursion with memoization.
+	Memo Algorithm = "memo"
+	// Matrix raises [[1,1],[1,0]] to the nth power by squaring, taking
+	// O(log n) multiplications.
+	Matrix Algorithm = "matrix"
+)
+
+// Algorithms lists the algorithms in order of increasing speed.
+var Algorithms = []Algorithm{Naive, Memo, Iterative, Matrix}
+
+// limits are the largest n for each algorithm that completes within a few
+// seconds. Memo is bounded by its recursion depth and memory.
+var limits = map[Algorithm]int{
+	Naive:     49,
+	Memo:      10_000,
+	Iterative: 100_000,
+	Matrix:    1_000_000,
+}
+
+// ParseAlgorithm returns the Algorithm named s.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	if a := Algorithm(s); slices.Contains(Algorithms, a) {
+		return a, nil
+	}
+	return "", fmt.Errorf("fibonacci: unknown algorithm %q", s)
+}
+
+// Limit returns the largest n the algorithm computes within a few seconds.
+// Callers serving requests should reject larger values.
+func (a Algorithm) Limit() int {
+	return limits[a]
+}
+
+// checkInterval is how many steps an algorithm takes between checks of its
+// context and reports of progress.
+const checkInterval = 1 << 20
+
+// Compute returns the nth Fibonacci number using algo. It returns an error
+// when n is negative, and the context error when ctx is done first.
+// progress, which may be nil, is called periodically with the fraction of
+// work done.
+func Compute(ctx context.Context, algo Algorithm, n int, progress func(float64)) (*big.Int, error) {
+	if _, ok := limits[algo]; !ok {
+		return nil, fmt.Errorf("fibonacci: unknown algorithm %q", algo)
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("fibonacci: n must not be negative, got %d", n)
+	}
+	if progress == nil {
+		progress = func(float64) {}
+	}
+
+	switch algo {
+	case Naive:
+		return naive(ctx, n, progress)
+	case Memo:
+		return memo(ctx, n, progress)
+	case Iterative:
+		return iterative(ctx, n, progress)
+	default:
+		return matrix(ctx, n, progress)
+	}
+}
+
+func naive(ctx context.Context, n int, progress func(float64)) (*big.Int, error) {
+	// The recursion makes 2*fib(n+1)-1 calls.
+	a, b := 0, 1
+	for range n + 1 {
+		a, b = b, a+b
+	}
+	total := float64(2*a - 1)
+
+	var calls int
+	var err error
+	var fib func(n int) int
+	fib = func(n int) int {
+		if err != nil {
+			return 0
+		}
+		calls++
+		if calls%checkInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return 0
+			}
+			progress(float64(calls) / total)
+		}
+		if n <= 1 {
+			return n
+		}
+		return fib(n-1) + fib(n-2)
+	}
+
+	result := fib(n)
+	if err != nil {
+		return nil, err
+	}
+	return big.NewInt(int64(result)), nil
+}
+
+func memo(ctx context.Context, n int, progress func(float64)) (*big.Int, error) {
+	cache := make([]*big.Int, n+1)
+	var computed int
+	var err error
+
+	var fib func(n int) *big.Int
+	fib = func(n int) *big.Int {
+		if err != nil {
+			return nil
+		}
+		if n <= 1 {
+			return big.NewInt(int64(n))
+		}
+		if cache[n] != nil {
+			return cache[n]
+		}
+
+		a := fib(n - 1)
+		b := fib(n - 2)
+		if err != nil {
+			return nil
+		}
+		cache[n] = new(big.Int).Add(a, b)
+
+		computed++
+		if computed%1024 == 0 {
+			if err = ctx.Err(); err != nil {
+				return nil
+			}
+			progress(float64(computed) / float64(n))
+		}
+		return cache[n]
+	}
+
+	result := fib(n)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func iterative(ctx context.Context, n int, progress func(float64)) (*big.Int, error) {
+	a, b := big.NewInt(0), big.NewInt(1)
+	for i := range n {
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			progress(float64(i) / float64(n))
+		}
+		a.Add(a, b)
+		a, b = b, a
+	}
+	return a, nil
+}
+
+func matrix(ctx context.Context, n int, progress func(float64)) (*big.Int, error) {
+	// result and base hold [[a, b], [b, c]]; powers of [[1,1],[1,0]] are
+	// symmetric, so three entries are enough.
+	ra, rb, rc := big.NewInt(1), big.NewInt(0), big.NewInt(1)
+	ba, bb, bc := big.NewInt(1), big.NewInt(1), big.NewInt(0)
+	t1, t2, t3 := new(big.Int), new(big.Int), new(big.Int)
+
+	multiply := func(xa, xb, xc, ya, yb, yc *big.Int) {
+		// [[xa,xb],[xb,xc]] * [[ya,yb],[yb,yc]]
+		t1.Add(t1.Mul(xa, ya), t3.Mul(xb, yb))
+		t2.Add(t2.Mul(xa, yb), t3.Mul(xb, yc))
+		t3.Add(t3.Mul(xb, yb), new(big.Int).Mul(xc, yc))
+		xa.Set(t1)
+		xb.Set(t2)
+		xc.Set(t3)
+	}
+
+	bits := max(big.NewInt(int64(n)).BitLen(), 1)
+	for i := 0; n > 0; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		progress(float64(i) / float64(bits))
+
+		if n&1 == 1 {
+			multiply(ra, rb, rc, ba, bb, bc)
+		}
+		n >>= 1
+		if n > 0 {
+			multiply(ba, bb, bc, ba, bb, bc)
+		}
+	}
+	// [[1,1],[1,0]]^n = [[F(n+1), F(n)], [F(n), F(n-1)]]
+	return rb, nil
+}
@@ -0,0 +1,86 @@
+package fibonacci
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCompute(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		n      int
+		expect string
+	}{
+		{0, "0"},
+		{1, "1"},
+		{2, "1"},
+		{10, "55"},
+		{49, "7778742049"},
+		{92, "7540113804746346429"},
+		{93, "12200160415121876738"},
+		{100, "354224848179261915075"},
+	}
+
+	for _, algo := range Algorithms {
+		t.Run(string(algo), func(t *testing.T) {
+			for _, tt := range tests {
+				// Naive takes about a minute at its limit.
+				if tt.n > algo.Limit() || algo == Naive && tt.n > 30 {
+					continue
+				}
+				got, err := Compute(ctx, algo, tt.n, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.String() != tt.expect {
+					t.Errorf("fib(%d): got %s want %s", tt.n, got, tt.expect)
+				}
+			}
+		})
+	}
+
+	t.Run("algorithms_agree", func(t *testing.T) {
+		want, _ := Compute(ctx, Iterative, 5000, nil)
+		for _, algo := range []Algorithm{Memo, Matrix} {
+			if got, _ := Compute(ctx, algo, 5000, nil); got.Cmp(want) != 0 {
+				t.Errorf("%s disagrees with iterative for n=5000", algo)
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := Compute(ctx, Matrix, -1, nil); err == nil {
+			t.Error("expected an error for a negative n")
+		}
+		if _, err := Compute(ctx, "quantum", 1, nil); err == nil {
+			t.Error("expected an error for an unknown algorithm")
+		}
+		if _, err := ParseAlgorithm("quantum"); err == nil {
+			t.Error("expected an error for an unknown algorithm")
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		for _, algo := range Algorithms {
+			if _, err := Compute(canceled, algo, algo.Limit(), nil); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s: expected context.Canceled, got %v", algo, err)
+			}
+		}
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		var last float64
+		Compute(ctx, Naive, 32, func(p float64) {
+			if p < last || p > 1 {
+				t.Errorf("progress went from %v to %v", last, p)
+			}
+			last = p
+		})
+		if last == 0 {
+			t.Error("expected progress to be reported")
+		}
+	})
+}
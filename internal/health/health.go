@@ -0,0 +1,235 @@
+// Package health runs dependency checks for health probes. Each check has its
+// own timeout and caches its result, so frequent probes from several callers
+// do not stampede the dependencies they check.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of a check or a report.
+type Status string
+
+const (
+	// Pass means the check succeeded.
+	Pass Status = "pass"
+	// Warn means a non-critical check failed; the service can still serve.
+	Warn Status = "warn"
+	// Fail means a critical check failed.
+	Fail Status = "fail"
+)
+
+// Defaults for checks registered without a timeout or cache duration.
+const (
+	DefaultTimeout  = time.Second
+	DefaultCacheTTL = time.Second
+)
+
+// Check is a named dependency check.
+type Check struct {
+	Name string
+	// Check returns an error when the dependency is unhealthy. It should
+	// return promptly once ctx is done.
+	Check func(ctx context.Context) error
+	// Timeout bounds each run of Check.
+	Timeout time.Duration
+	// Critical checks fail the report; others only make it warn.
+	Critical bool
+	// CacheTTL is how long a result is reused before Check runs again.
+	CacheTTL time.Duration
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name      string    `json:"name"`
+	Status    Status    `json:"status"`
+	Critical  bool      `json:"critical"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// Report is the combined outcome of every check in a Registry.
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks,omitempty"`
+}
+
+// Registry runs a set of checks.
+type Registry struct {
+	mu     sync.RWMutex
+	checks []*entry
+	now    func() time.Time
+}
+
+// entry holds a check with its cached result. Only one run of a check is in
+// flight at a time; concurrent callers wait for it.
+type entry struct {
+	Check
+
+	mu       sync.Mutex
+	result   Result
+	expires  time.Time
+	inflight chan struct{}
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{now: time.Now}
+}
+
+// Register adds a check. Registering a name twice panics.
+func (r *Registry) Register(check Check) {
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultTimeout
+	}
+	if check.CacheTTL <= 0 {
+		check.CacheTTL = DefaultCacheTTL
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.checks {
+		if e.Name == check.Name {
+			panic(fmt.Sprintf("health: check %q registered twice", check.Name))
+		}
+	}
+	r.checks = append(r.checks, &entry{Check: check})
+}
+
+// Run runs every check concurrently, reusing cached results, and returns
+// them in registration order.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := r.checks
+	r.mu.RUnlock()
+
+	report := Report{Status: Pass, Checks: make([]Result, len(checks))}
+
+	var wg sync.WaitGroup
+	for i, e := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Checks[i] = r.result(ctx, e)
+		}()
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		switch {
+		case result.Status == Fail:
+			report.Status = Fail
+		case result.Status == Warn && report.Status == Pass:
+			report.Status = Warn
+		}
+	}
+	return report
+}
+
+// Result returns the result of the named check, reusing a cached result as
+// Run does. It reports false if no check has that name.
+func (r *Registry) Result(ctx context.Context, name string) (Result, bool) {
+	r.mu.RLock()
+	checks := r.checks
+	r.mu.RUnlock()
+
+	for _, e := range checks {
+		if e.Name == name {
+			return r.result(ctx, e), true
+		}
+	}
+	return Result{}, false
+}
+
+// result returns the cached result of e, running the check if it expired.
+func (r *Registry) result(ctx context.Context, e *entry) Result {
+	for {
+		e.mu.Lock()
+		if r.now().Before(e.expires) {
+			result := e.result
+			e.mu.Unlock()
+			return result
+		}
+		if wait := e.inflight; wait != nil {
+			e.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return e.failure(r.now(), 0, ctx.Err())
+			}
+		}
+		done := make(chan struct{})
+		e.inflight = done
+		e.mu.Unlock()
+
+		result := r.run(e)
+
+		e.mu.Lock()
+		e.result = result
+		e.expires = r.now().Add(e.CacheTTL)
+		e.inflight = nil
+		e.mu.Unlock()
+		close(done)
+		return result
+	}
+}
+
+// run runs the check of e with its timeout. The check does not inherit the
+// caller's context, so a probe that gives up does not poison the cache.
+func (r *Registry) run(e *entry) Result {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+	defer cancel()
+
+	start := r.now()
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				errc <- fmt.Errorf("check panicked: %v", v)
+			}
+		}()
+		errc <- e.Check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", e.Timeout)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", e.Timeout)
+	}
+
+	if err != nil {
+		return e.failure(start, r.now().Sub(start), err)
+	}
+	return Result{
+		Name:      e.Name,
+		Status:    Pass,
+		Critical:  e.Critical,
+		Duration:  r.now().Sub(start).String(),
+		CheckedAt: start,
+	}
+}
+
+func (e *entry) failure(start time.Time, d time.Duration, err error) Result {
+	status := Warn
+	if e.Critical {
+		status = Fail
+	}
+	return Result{
+		Name:      e.Name,
+		Status:    status,
+		Critical:  e.Critical,
+		Error:     err.Error(),
+		Duration:  d.String(),
+		CheckedAt: start,
+	}
+}
@@ -0,0 +1,97 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("statuses", func(t *testing.T) {
+		reg := NewRegistry()
+		reg.Register(Check{Name: "ok", Critical: true, Check: func(ctx context.Context) error { return nil }})
+		reg.Register(Check{Name: "optional", Check: func(ctx context.Context) error { return errors.New("down") }})
+
+		report := reg.Run(ctx)
+		if report.Status != Warn {
+			t.Fatalf("expected a non-critical failure to warn, got %s", report.Status)
+		}
+		if report.Checks[0].Name != "ok" || report.Checks[0].Status != Pass {
+			t.Errorf("unexpected result: %+v", report.Checks[0])
+		}
+		if report.Checks[1].Status != Warn || report.Checks[1].Error != "down" {
+			t.Errorf("unexpected result: %+v", report.Checks[1])
+		}
+
+		reg.Register(Check{Name: "critical", Critical: true, Check: func(ctx context.Context) error { panic("boom") }})
+		if report := reg.Run(ctx); report.Status != Fail || report.Checks[2].Error != "check panicked: boom" {
+			t.Errorf("expected a critical failure to fail: %+v", report)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		reg := NewRegistry()
+		reg.Register(Check{Name: "slow", Critical: true, Timeout: 10 * time.Millisecond, Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}})
+
+		start := time.Now()
+		report := reg.Run(ctx)
+		if report.Status != Fail || report.Checks[0].Error != "timed out after 10ms" {
+			t.Errorf("unexpected report: %+v", report)
+		}
+		if time.Since(start) > time.Second {
+			t.Error("the check was not bounded by its timeout")
+		}
+	})
+
+	t.Run("caches_and_coalesces", func(t *testing.T) {
+		var calls atomic.Int32
+		release := make(chan struct{})
+		now := time.Now()
+
+		reg := NewRegistry()
+		reg.now = func() time.Time { return now }
+		reg.Register(Check{Name: "counted", CacheTTL: time.Minute, Check: func(ctx context.Context) error {
+			calls.Add(1)
+			<-release
+			return nil
+		}})
+
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				reg.Run(ctx)
+			}()
+		}
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		reg.Run(ctx)
+		if calls.Load() != 1 {
+			t.Errorf("expected concurrent and cached runs to share 1 call, got %d", calls.Load())
+		}
+
+		now = now.Add(time.Minute)
+		reg.Run(ctx)
+		if calls.Load() != 2 {
+			t.Errorf("expected an expired result to run again, got %d calls", calls.Load())
+		}
+
+		if result, ok := reg.Result(ctx, "counted"); !ok || result.Status != Pass || calls.Load() != 2 {
+			t.Errorf("Result: got %+v, %v after %d calls", result, ok, calls.Load())
+		}
+		if _, ok := reg.Result(ctx, "missing"); ok {
+			t.Error("Result of an unknown check reported ok")
+		}
+	})
+}
@@ -0,0 +1,410 @@
+// Package jobs runs asynchronous jobs in a bounded worker pool. Job state is
+// kept in a database.JobRepository so any replica can report on or cancel a
+// job, while the work itself runs on the replica that accepted it.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/metrics"
+)
+
+var (
+	// ErrQueueFull is returned by Submit when every worker is busy and the
+	// queue is full.
+	ErrQueueFull = errors.New("jobs: queue is full")
+	// ErrUnknownType is returned by Submit for a type without a registered
+	// Func.
+	ErrUnknownType = errors.New("jobs: unknown job type")
+	// ErrClosed is returned by Submit after Shutdown.
+	ErrClosed = errors.New("jobs: pool is shut down")
+)
+
+// Func runs a job. It should return promptly once ctx is done and may call
+// progress with values between 0 and 1. The result is stored as JSON.
+type Func func(ctx context.Context, input json.RawMessage, progress func(float64)) (any, error)
+
+// Config sizes a Pool.
+type Config struct {
+	// Workers is the number of jobs run at once.
+	Workers int
+	// Queue is how many submitted jobs may wait for a worker.
+	Queue int
+	// PollInterval is how often a running job checks whether another
+	// replica canceled it, and the minimum interval between progress
+	// updates.
+	PollInterval time.Duration
+	// Metrics, if set, receives the queue depth, running jobs and finished
+	// job counts.
+	Metrics *metrics.Registry
+	// PubSub, if set, receives an Event on Channel(id) whenever a job
+	// starts, makes progress or finishes.
+	PubSub database.PubSub
+}
+
+// Event is the state of a job published when it changes. Clients fetch the
+// job for its result once Status is final.
+type Event struct {
+	ID       string             `json:"id"`
+	Status   database.JobStatus `json:"status"`
+	Progress float64            `json:"progress"`
+	Error    string             `json:"error,omitempty"`
+}
+
+// Channel returns the pub/sub channel of the events of job id.
+func Channel(id string) string {
+	return "jobs:" + id
+}
+
+// Pool runs submitted jobs with a fixed number of workers.
+type Pool struct {
+	repo   database.JobRepository
+	logger *slog.Logger
+	cfg    Config
+	funcs  map[string]Func
+
+	queue chan *database.Job
+
+	mu     sync.Mutex
+	closed bool
+	// reserved counts queue slots held by Submit calls storing their job.
+	reserved int
+	cancels  map[string]context.CancelFunc
+	wg       sync.WaitGroup
+
+	// stop cancels every running job on Shutdown.
+	ctx  context.Context
+	stop context.CancelFunc
+
+	running  *metrics.GaugeVec
+	finished *metrics.CounterVec
+}
+
+// New returns a Pool storing jobs in repo. Register job types, then call
+// Start.
+func New(repo database.JobRepository, logger *slog.Logger, cfg Config) *Pool {
+	cfg.Workers = max(cfg.Workers, 1)
+	cfg.Queue = max(cfg.Queue, 1)
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	p := &Pool{
+		repo:    repo,
+		logger:  logger,
+		cfg:     cfg,
+		funcs:   make(map[string]Func),
+		queue:   make(chan *database.Job, cfg.Queue),
+		cancels: make(map[string]context.CancelFunc),
+		ctx:     ctx,
+		stop:    stop,
+	}
+
+	if cfg.Metrics != nil {
+		cfg.Metrics.Gauge("gofetch_jobs_queue_depth", "Jobs waiting for a worker.").
+			Func(func() float64 { return float64(len(p.queue)) })
+		p.running = cfg.Metrics.Gauge("gofetch_jobs_running", "Jobs being run.")
+		p.running.Set(0)
+		p.finished = cfg.Metrics.Counter("gofetch_jobs_finished_total", "Jobs finished by type and status.", "type", "status")
+	}
+	return p
+}
+
+// Register sets the Func run for jobs of typ. It must be called before Start.
+func (p *Pool) Register(typ string, fn Func) {
+	p.funcs[typ] = fn
+}
+
+// Start starts the workers.
+func (p *Pool) Start() {
+	for range p.cfg.Workers {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range p.queue {
+				p.run(job)
+			}
+		}()
+	}
+}
+
+// Submit stores a queued job of typ with input, submitted by owner, and
+// queues it. The returned job has its ID set.
+func (p *Pool) Submit(ctx context.Context, typ, owner string, input any) (*database.Job, error) {
+	if _, ok := p.funcs[typ]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, typ)
+	}
+	raw, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+
+	// A queue slot is reserved before the job is stored, so the lock is not
+	// held across the repository call. Only Submit sends, so a reserved
+	// slot is still free once the job is stored.
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if len(p.queue)+p.reserved >= cap(p.queue) {
+		p.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	p.reserved++
+	p.mu.Unlock()
+
+	job := &database.Job{ID: uuid.NewString(), Type: typ, Owner: owner, Input: raw}
+	err = p.repo.Create(ctx, job)
+
+	p.mu.Lock()
+	p.reserved--
+	closed := p.closed
+	if err == nil && !closed {
+		p.queue <- job
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if closed {
+		// Shutdown closed the queue while the job was stored.
+		p.finish(job, database.JobFailed, nil, errShutdown.Error())
+		return nil, ErrClosed
+	}
+	return job, nil
+}
+
+// Cancel cancels a queued or running job. A job running on another replica
+// stops when that replica next polls its status. It returns ErrNotFound or
+// ErrJobFinished from the repository.
+func (p *Pool) Cancel(ctx context.Context, id string) (*database.Job, error) {
+	job, err := p.repo.Finish(ctx, id, database.JobCanceled, nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	if cancel, ok := p.cancels[id]; ok {
+		cancel()
+	}
+	p.mu.Unlock()
+
+	if p.finished != nil {
+		p.finished.Inc(job.Type, string(job.Status))
+	}
+	p.publish(job.ID, job.Status, job.Progress, job.Error)
+	return job, nil
+}
+
+// Shutdown stops accepting jobs, cancels running jobs and fails queued ones,
+// then waits for the workers to return or ctx to be done.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.queue)
+	p.mu.Unlock()
+
+	p.stop()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cleanup deletes jobs that finished more than ttl ago.
+func (p *Pool) Cleanup(ctx context.Context, ttl time.Duration) (int, error) {
+	return p.repo.DeleteFinished(ctx, time.Now().Add(-ttl))
+}
+
+// FailStale fails running jobs without a heartbeat for timeout, left behind
+// by a replica that stopped without finishing them, and returns how many it
+// failed. They cannot be requeued since their input was only queued on that
+// replica. Running jobs record a heartbeat every PollInterval, so timeout
+// should be several times longer.
+func (p *Pool) FailStale(ctx context.Context, timeout time.Duration) (int, error) {
+	failed, err := p.repo.FailStale(ctx, time.Now().Add(-timeout), errAbandoned.Error())
+	for _, job := range failed {
+		if p.finished != nil {
+			p.finished.Inc(job.Type, string(job.Status))
+		}
+		p.publish(job.ID, job.Status, job.Progress, job.Error)
+	}
+	return len(failed), err
+}
+
+var (
+	// errShutdown is the error of jobs stopped by Shutdown.
+	errShutdown = errors.New("the server shut down before the job finished")
+	// errAbandoned is the error of jobs failed by FailStale.
+	errAbandoned = errors.New("the server running the job stopped before it finished")
+)
+
+func (p *Pool) run(job *database.Job) {
+	if p.ctx.Err() != nil {
+		p.finish(job, database.JobFailed, nil, errShutdown.Error())
+		return
+	}
+
+	started, err := p.repo.Start(p.ctx, job.ID)
+	if err != nil {
+		if !errors.Is(err, database.ErrJobFinished) {
+			p.logger.Error("failed to start job", "job", job.ID, "type", job.Type, "error", err.Error())
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+
+	p.mu.Lock()
+	p.cancels[job.ID] = cancel
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.cancels, job.ID)
+		p.mu.Unlock()
+	}()
+
+	if p.running != nil {
+		p.running.Add(1)
+		defer p.running.Add(-1)
+	}
+	p.publish(job.ID, started.Status, started.Progress, "")
+
+	go p.watch(ctx, cancel, job.ID)
+
+	result, err := p.call(ctx, started, p.progress(ctx, cancel, job.ID))
+
+	switch {
+	case p.ctx.Err() != nil:
+		p.finish(job, database.JobFailed, nil, errShutdown.Error())
+	case ctx.Err() != nil:
+		// Canceled; the repository already records the final state.
+	case err != nil:
+		p.finish(job, database.JobFailed, nil, err.Error())
+	default:
+		raw, err := json.Marshal(result)
+		if err != nil {
+			p.finish(job, database.JobFailed, nil, err.Error())
+			return
+		}
+		p.finish(job, database.JobSucceeded, raw, "")
+	}
+}
+
+// call runs the Func for job, converting a panic into an error.
+func (p *Pool) call(ctx context.Context, job *database.Job, progress func(float64)) (result any, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			p.logger.Error("job panicked", "job", job.ID, "type", job.Type, "error", fmt.Sprint(v), "stack", string(debug.Stack()))
+			err = errors.New("the job failed unexpectedly")
+		}
+	}()
+	return p.funcs[job.Type](ctx, job.Input, progress)
+}
+
+// progress returns a func that stores progress at most once per
+// PollInterval, canceling the job if it was canceled elsewhere.
+func (p *Pool) progress(ctx context.Context, cancel context.CancelFunc, id string) func(float64) {
+	var last time.Time
+	return func(progress float64) {
+		if time.Since(last) < p.cfg.PollInterval || ctx.Err() != nil {
+			return
+		}
+		last = time.Now()
+
+		progress = min(max(progress, 0), 1)
+		err := p.repo.SetProgress(ctx, id, progress)
+		switch {
+		case err == nil:
+			p.publish(id, database.JobRunning, progress, "")
+		case errors.Is(err, database.ErrJobFinished):
+			cancel()
+		case ctx.Err() == nil:
+			p.logger.Warn("failed to store job progress", "job", id, "error", err.Error())
+		}
+	}
+}
+
+// watch records a heartbeat for a running job every PollInterval, and
+// cancels it once its stored status is final.
+func (p *Pool) watch(ctx context.Context, cancel context.CancelFunc, id string) {
+	ticker := time.NewTicker(p.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := p.repo.Heartbeat(ctx, id)
+			if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrJobFinished) {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// finish records the final state of a job, which may already have been set
+// by a cancellation.
+func (p *Pool) finish(job *database.Job, status database.JobStatus, result json.RawMessage, errMsg string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	finished, err := p.repo.Finish(ctx, job.ID, status, result, errMsg)
+	if errors.Is(err, database.ErrJobFinished) {
+		return
+	}
+	if err != nil {
+		p.logger.Error("failed to store job result", "job", job.ID, "type", job.Type, "error", err.Error())
+		return
+	}
+	if p.finished != nil {
+		p.finished.Inc(job.Type, string(status))
+	}
+	p.publish(job.ID, status, finished.Progress, errMsg)
+}
+
+// publish sends an Event for job id if the pool has a PubSub. Events are
+// best effort; the repository remains the source of truth.
+func (p *Pool) publish(id string, status database.JobStatus, progress float64, errMsg string) {
+	if p.cfg.PubSub == nil {
+		return
+	}
+	payload, err := json.Marshal(Event{ID: id, Status: status, Progress: progress, Error: errMsg})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.cfg.PubSub.Publish(ctx, Channel(id), payload); err != nil {
+		p.logger.Warn("failed to publish job event", "job", id, "error", err.Error())
+	}
+}
@@ -0,0 +1,291 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gofetch.timwalker.dev/internal/database"
+	"gofetch.timwalker.dev/internal/metrics"
+)
+
+func newTestPool(t *testing.T, cfg Config) (*Pool, *database.MemoryJobRepository) {
+	t.Helper()
+	repo := database.NewMemoryJobRepository()
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = time.Millisecond
+	}
+	p := New(repo, slog.New(slog.DiscardHandler), cfg)
+
+	p.Register("echo", func(ctx context.Context, input json.RawMessage, progress func(float64)) (any, error) {
+		progress(0.5)
+		return input, nil
+	})
+	p.Register("fail", func(ctx context.Context, input json.RawMessage, progress func(float64)) (any, error) {
+		return nil, errors.New("boom")
+	})
+	p.Register("panic", func(ctx context.Context, input json.RawMessage, progress func(float64)) (any, error) {
+		panic("boom")
+	})
+	p.Register("block", func(ctx context.Context, input json.RawMessage, progress func(float64)) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	t.Cleanup(func() { p.Shutdown(context.Background()) })
+	return p, repo
+}
+
+// wait polls the job until it reaches status.
+func wait(t *testing.T, repo database.JobRepository, id string, status database.JobStatus) *database.Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		job, err := repo.Get(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.Status, status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// slowRepository blocks Create until release is closed.
+type slowRepository struct {
+	*database.MemoryJobRepository
+	creating chan struct{}
+	release  chan struct{}
+}
+
+func (r *slowRepository) Create(ctx context.Context, job *database.Job) error {
+	r.creating <- struct{}{}
+	<-r.release
+	return r.MemoryJobRepository.Create(ctx, job)
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("outcomes", func(t *testing.T) {
+		reg := metrics.NewRegistry()
+		p, repo := newTestPool(t, Config{Workers: 2, Queue: 4, Metrics: reg})
+		p.Start()
+
+		echo, err := p.Submit(ctx, "echo", "", map[string]int{"n": 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := wait(t, repo, echo.ID, database.JobSucceeded); string(got.Result) != `{"n":1}` || got.Progress != 1 {
+			t.Errorf("unexpected job: %+v", got)
+		}
+
+		fail, _ := p.Submit(ctx, "fail", "", nil)
+		if got := wait(t, repo, fail.ID, database.JobFailed); got.Error != "boom" {
+			t.Errorf("unexpected error %q", got.Error)
+		}
+
+		panicked, _ := p.Submit(ctx, "panic", "", nil)
+		if got := wait(t, repo, panicked.ID, database.JobFailed); got.Error == "" {
+			t.Error("expected the panic to fail the job")
+		}
+
+		if _, err := p.Submit(ctx, "missing", "", nil); !errors.Is(err, ErrUnknownType) {
+			t.Errorf("expected ErrUnknownType, got %v", err)
+		}
+
+		var b strings.Builder
+		reg.WriteTo(&b)
+		if !strings.Contains(b.String(), `gofetch_jobs_finished_total{type="echo",status="succeeded"} 1`) {
+			t.Errorf("unexpected metrics:\n%s", b.String())
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		p, repo := newTestPool(t, Config{Workers: 1, Queue: 1})
+		p.Start()
+
+		job, _ := p.Submit(ctx, "block", "", nil)
+		wait(t, repo, job.ID, database.JobRunning)
+
+		canceled, err := p.Cancel(ctx, job.ID)
+		if err != nil || canceled.Status != database.JobCanceled {
+			t.Fatalf("unexpected cancel: %+v %v", canceled, err)
+		}
+		if _, err := p.Cancel(ctx, job.ID); !errors.Is(err, database.ErrJobFinished) {
+			t.Errorf("expected ErrJobFinished, got %v", err)
+		}
+
+		// The worker is free again once the canceled job stops.
+		next, _ := p.Submit(ctx, "echo", "", nil)
+		wait(t, repo, next.ID, database.JobSucceeded)
+	})
+
+	t.Run("cancel_from_another_replica", func(t *testing.T) {
+		p, repo := newTestPool(t, Config{Workers: 1, Queue: 1})
+		p.Start()
+
+		job, _ := p.Submit(ctx, "block", "", nil)
+		wait(t, repo, job.ID, database.JobRunning)
+
+		// Cancel through the repository, as a replica without the job would.
+		if _, err := repo.Finish(ctx, job.ID, database.JobCanceled, nil, ""); err != nil {
+			t.Fatal(err)
+		}
+		next, _ := p.Submit(ctx, "echo", "", nil)
+		wait(t, repo, next.ID, database.JobSucceeded)
+	})
+
+	t.Run("queue_full", func(t *testing.T) {
+		p, _ := newTestPool(t, Config{Workers: 1, Queue: 1})
+
+		if _, err := p.Submit(ctx, "echo", "", nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.Submit(ctx, "echo", "", nil); !errors.Is(err, ErrQueueFull) {
+			t.Errorf("expected ErrQueueFull, got %v", err)
+		}
+	})
+
+	t.Run("slow_create", func(t *testing.T) {
+		repo := &slowRepository{
+			MemoryJobRepository: database.NewMemoryJobRepository(),
+			creating:            make(chan struct{}),
+			release:             make(chan struct{}),
+		}
+		p := New(repo, slog.New(slog.DiscardHandler), Config{Workers: 1, Queue: 1})
+		p.Register("echo", func(ctx context.Context, input json.RawMessage, progress func(float64)) (any, error) {
+			return input, nil
+		})
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := p.Submit(ctx, "echo", "", nil)
+			errs <- err
+		}()
+		<-repo.creating
+
+		// The pending job holds the only queue slot, and the pool stays
+		// usable while it is stored.
+		if _, err := p.Submit(ctx, "echo", "", nil); !errors.Is(err, ErrQueueFull) {
+			t.Errorf("expected ErrQueueFull, got %v", err)
+		}
+		if err := p.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		close(repo.release)
+		if err := <-errs; !errors.Is(err, ErrClosed) {
+			t.Errorf("Submit during Shutdown: expected ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		p, repo := newTestPool(t, Config{Workers: 1, Queue: 1})
+		p.Start()
+
+		running, _ := p.Submit(ctx, "block", "", nil)
+		wait(t, repo, running.ID, database.JobRunning)
+		queued, _ := p.Submit(ctx, "echo", "", nil)
+
+		if err := p.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{running.ID, queued.ID} {
+			if got := wait(t, repo, id, database.JobFailed); got.Error != errShutdown.Error() {
+				t.Errorf("unexpected error %q", got.Error)
+			}
+		}
+		if _, err := p.Submit(ctx, "echo", "", nil); !errors.Is(err, ErrClosed) {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+	})
+
+	t.Run("cleanup", func(t *testing.T) {
+		p, repo := newTestPool(t, Config{Workers: 1, Queue: 1})
+		p.Start()
+
+		job, _ := p.Submit(ctx, "echo", "", nil)
+		wait(t, repo, job.ID, database.JobSucceeded)
+
+		if n, _ := p.Cleanup(ctx, time.Hour); n != 0 {
+			t.Errorf("deleted %d jobs within their ttl", n)
+		}
+		if n, _ := p.Cleanup(ctx, -time.Second); n != 1 {
+			t.Errorf("expected 1 expired job deleted, got %d", n)
+		}
+		if _, err := repo.Get(ctx, job.ID); !errors.Is(err, database.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("fail_stale", func(t *testing.T) {
+		p, repo := newTestPool(t, Config{Workers: 1, Queue: 1})
+		p.Start()
+
+		live, _ := p.Submit(ctx, "block", "", nil)
+		wait(t, repo, live.ID, database.JobRunning)
+
+		// Start a job through the repository, as a replica that stopped
+		// while running it would have.
+		abandoned := &database.Job{ID: "abandoned", Type: "block"}
+		if err := repo.Create(ctx, abandoned); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Start(ctx, abandoned.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		if n, err := p.FailStale(ctx, 25*time.Millisecond); err != nil || n != 1 {
+			t.Errorf("expected 1 stale job failed, got %d, %v", n, err)
+		}
+		if got := wait(t, repo, abandoned.ID, database.JobFailed); got.Error != errAbandoned.Error() {
+			t.Errorf("unexpected error %q", got.Error)
+		}
+		if got, _ := repo.Get(ctx, live.ID); got.Status != database.JobRunning {
+			t.Errorf("job with heartbeats is %s, want %s", got.Status, database.JobRunning)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		pubsub := database.NewMemoryPubSub()
+		p, _ := newTestPool(t, Config{Workers: 1, Queue: 1, PubSub: pubsub})
+
+		job, _ := p.Submit(ctx, "echo", "", nil)
+		sub, err := pubsub.Subscribe(ctx, Channel(job.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+		p.Start()
+
+		var events []Event
+		for len(events) == 0 || !events[len(events)-1].Status.Finished() {
+			select {
+			case msg := <-sub.Messages():
+				var ev Event
+				if err := json.Unmarshal(msg.Payload, &ev); err != nil {
+					t.Fatal(err)
+				}
+				events = append(events, ev)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("no final event, got %+v", events)
+			}
+		}
+		first, last := events[0], events[len(events)-1]
+		if first.ID != job.ID || first.Status != database.JobRunning {
+			t.Errorf("unexpected first event %+v", first)
+		}
+		if last.Status != database.JobSucceeded || last.Progress != 1 {
+			t.Errorf("unexpected last event %+v", last)
+		}
+	})
+}
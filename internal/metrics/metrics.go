@@ -0,0 +1,253 @@
+// Package metrics implements counters and gauges exposed in the Prometheus
+// text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds metrics for exposition. The zero value is not usable; use
+// NewRegistry.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+type metricType string
+
+const (
+	counterType metricType = "counter"
+	gaugeType   metricType = "gauge"
+)
+
+// metric is a family of series sharing a name and label names.
+type metric struct {
+	name   string
+	help   string
+	typ    metricType
+	labels []string
+
+	mu     sync.RWMutex
+	series map[string]*series
+	// funcs are series whose values are read at exposition time.
+	funcs map[string]func() float64
+}
+
+type series struct {
+	labelValues []string
+	bits        atomic.Uint64
+}
+
+func (s *series) value() float64 {
+	return math.Float64frombits(s.bits.Load())
+}
+
+func (s *series) add(delta float64) {
+	for {
+		old := s.bits.Load()
+		if s.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (r *Registry) register(name, help string, typ metricType, labels []string) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		if m.typ != typ || !slices.Equal(m.labels, labels) {
+			panic(fmt.Sprintf("metrics: %s registered again with a different type or labels", name))
+		}
+		return m
+	}
+
+	m := &metric{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]*series),
+		funcs:  make(map[string]func() float64),
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// with returns the series for labelValues, creating it if needed.
+func (m *metric) with(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	m.mu.RLock()
+	s, ok := m.series[key]
+	m.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.series[key]; ok {
+		return s
+	}
+	s = &series{labelValues: slices.Clone(labelValues)}
+	m.series[key] = s
+	return s
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct{ m *metric }
+
+// Counter registers a counter, or returns the one registered under name.
+// Registering a name again with a different type or labels panics.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{m: r.register(name, help, counterType, labels)}
+}
+
+// Inc adds 1 to the series for labelValues.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.m.with(labelValues).add(1)
+}
+
+// Add adds delta, which must not be negative, to the series for labelValues.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.m.with(labelValues).add(delta)
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct{ m *metric }
+
+// Gauge registers a gauge, or returns the one registered under name.
+// Registering a name again with a different type or labels panics.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{m: r.register(name, help, gaugeType, labels)}
+}
+
+// Set sets the series for labelValues to v.
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.m.with(labelValues).bits.Store(math.Float64bits(v))
+}
+
+// Add adds delta to the series for labelValues.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.m.with(labelValues).add(delta)
+}
+
+// Func reports the series for labelValues by calling fn at exposition time.
+func (g *GaugeVec) Func(fn func() float64, labelValues ...string) {
+	s := g.m.with(labelValues)
+
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+	g.m.funcs[strings.Join(s.labelValues, "\xff")] = fn
+}
+
+// WriteTo writes every metric in the Prometheus text format, sorted by name
+// and label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(metrics, func(a, b *metric) int { return strings.Compare(a.name, b.name) })
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		m.writeTo(cw)
+	}
+	err := cw.w.(*bufio.Writer).Flush()
+	return cw.n, err
+}
+
+func (m *metric) writeTo(w io.Writer) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		v := s.value()
+		if fn, ok := m.funcs[key]; ok {
+			v = fn()
+		}
+
+		fmt.Fprint(w, m.name)
+		if len(m.labels) > 0 {
+			pairs := make([]string, len(m.labels))
+			for i, label := range m.labels {
+				pairs[i] = label + `="` + escapeLabelValue(s.labelValues[i]) + `"`
+			}
+			fmt.Fprint(w, "{"+strings.Join(pairs, ",")+"}")
+		}
+		fmt.Fprintln(w, " "+formatValue(v))
+	}
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpReplacer.Replace(s) }
+func escapeLabelValue(s string) string { return labelValueReplacer.Replace(s) }
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
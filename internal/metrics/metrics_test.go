@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := reg.Counter("requests_total", "Requests handled.", "route")
+	requests.Inc("b")
+	requests.Add(2, "a")
+	requests.Inc(`quo"te`)
+
+	depth := reg.Gauge("queue_depth", "Queued requests.")
+	depth.Set(3)
+	depth.Add(-1)
+
+	reg.Gauge("limit", "Current limit.", "route").Func(func() float64 { return 7 }, "a")
+
+	rr := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `# HELP limit Current limit.
+# TYPE limit gauge
+limit{route="a"} 7
+# HELP queue_depth Queued requests.
+# TYPE queue_depth gauge
+queue_depth 2
+# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{route="a"} 2
+requests_total{route="b"} 1
+requests_total{route="quo\"te"} 1
+`
+	if got := rr.Body.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected Content-Type %q", ct)
+	}
+}
+
+func TestRegistryConflicts(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("x", "", "a")
+
+	if reg.Counter("x", "", "a").m != reg.Counter("x", "", "a").m {
+		t.Error("expected the same metric for a repeated registration")
+	}
+
+	for name, fn := range map[string]func(){
+		"type":   func() { reg.Gauge("x", "", "a") },
+		"labels": func() { reg.Counter("x", "", "b") },
+		"values": func() { reg.Counter("x", "", "a").Inc() },
+		"negate": func() { reg.Counter("x", "", "a").Add(-1, "v") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			fn()
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package query
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Getter returns the value of a field of item for filtering and sorting.
+// Values should be strings or numbers.
+type Getter[T any] func(item T, field string) any
+
+// Page describes the page of results returned by Apply and Paginate.
+type Page struct {
+	Total int `json:"total"`
+	Limit int `json:"limit"`
+	// Page is the page number, omitted when paginating by cursor.
+	Page int `json:"page,omitempty"`
+	// Next and Prev are the URLs of the adjacent pages. Prev is only set for
+	// page pagination.
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+
+	first, last string
+}
+
+// Apply filters, sorts and paginates items according to q.
+func Apply[T any](items []T, q *Query, get Getter[T]) ([]T, Page) {
+	matched := make([]T, 0, len(items))
+	for _, item := range items {
+		if matches(item, q.Filters, get) {
+			matched = append(matched, item)
+		}
+	}
+
+	keys := func(item T) []any {
+		values := make([]any, len(q.Sort))
+		for i, s := range q.Sort {
+			values[i] = get(item, s.Field)
+		}
+		return values
+	}
+	slices.SortStableFunc(matched, func(a, b T) int {
+		return q.compare(keys(a), keys(b))
+	})
+
+	start := 0
+	switch {
+	case q.Page > 0:
+		start = min(q.Offset(), len(matched))
+	case q.after != nil:
+		// The first item sorting after the cursor.
+		start, _ = slices.BinarySearchFunc(matched, q.after, func(item T, after []any) int {
+			if q.compare(keys(item), after) <= 0 {
+				return -1
+			}
+			return 1
+		})
+	}
+	end := min(start+q.Limit+1, len(matched))
+	return Paginate(matched[start:end], len(matched), q, get)
+}
+
+// Paginate returns the page of results and its description. results are the
+// filtered and sorted items starting at the page, up to q.Limit+1 of them:
+// an item past the limit means there is a next page. total is the number of
+// items across all pages.
+func Paginate[T any](results []T, total int, q *Query, get Getter[T]) ([]T, Page) {
+	more := len(results) > q.Limit
+	if more {
+		results = results[:q.Limit]
+	}
+
+	page := Page{Total: total, Limit: q.Limit, Page: q.Page}
+	if q.Page > 0 {
+		last := lastPage(total, q.Limit)
+		page.first = q.link("page", "1")
+		page.last = q.link("page", strconv.Itoa(last))
+		if more {
+			page.Next = q.link("page", strconv.Itoa(q.Page+1))
+		}
+		if q.Page > 1 {
+			page.Prev = q.link("page", strconv.Itoa(min(q.Page-1, last)))
+		}
+	} else if more {
+		last := results[len(results)-1]
+		values := make([]any, len(q.Sort))
+		for i, s := range q.Sort {
+			values[i] = get(last, s.Field)
+		}
+		page.Next = q.link("cursor", encodeCursor(q.Sort, values))
+	}
+
+	return results, page
+}
+
+func lastPage(total, limit int) int {
+	return max((total+limit-1)/limit, 1)
+}
+
+// LinkHeader formats the page links as an RFC 8288 Link header value.
+func (p Page) LinkHeader() string {
+	var links []string
+	for _, l := range []struct{ rel, url string }{
+		{"first", p.first}, {"prev", p.Prev}, {"next", p.Next}, {"last", p.last},
+	} {
+		if l.url != "" {
+			links = append(links, fmt.Sprintf("<%s>; rel=%q", l.url, l.rel))
+		}
+	}
+	return strings.Join(links, ", ")
+}
+
+// Select returns items with only the fields listed in q.Fields, or items
+// unchanged when no fields were requested. Fields are matched against the
+// JSON encoding of each item.
+func Select[T any](items []T, q *Query) (any, error) {
+	if len(q.Fields) == 0 {
+		return items, nil
+	}
+
+	selected := make([]map[string]json.RawMessage, len(items))
+	for i, item := range items {
+		b, err := json.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(b, &all); err != nil {
+			return nil, fmt.Errorf("query: select fields of %T: %w", item, err)
+		}
+
+		selected[i] = make(map[string]json.RawMessage, len(q.Fields))
+		for _, field := range q.Fields {
+			if v, ok := all[field]; ok {
+				selected[i][field] = v
+			}
+		}
+	}
+	return selected, nil
+}
+
+// link returns the request URL with param set to value.
+func (q *Query) link(param, value string) string {
+	values := q.url.Query()
+	values.Del("page")
+	values.Del("cursor")
+	values.Set(param, value)
+
+	u := url.URL{Path: q.url.Path, RawQuery: values.Encode()}
+	return u.String()
+}
+
+// matches reports whether item matches every filter.
+func matches[T any](item T, filters []Filter, get Getter[T]) bool {
+	for _, f := range filters {
+		v := fmt.Sprint(get(item, f.Field))
+		switch f.Op {
+		case Equal:
+			if v != f.Value {
+				return false
+			}
+		case Contains:
+			if !strings.Contains(strings.ToLower(v), strings.ToLower(f.Value)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compare orders two sets of sort values according to q.Sort.
+func (q *Query) compare(a, b []any) int {
+	for i, s := range q.Sort {
+		c := compareValues(a[i], b[i])
+		if s.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues compares strings or numbers. Numbers decoded from a cursor
+// are float64, so all numbers are compared as float64.
+func compareValues(a, b any) int {
+	if as, ok := a.(string); ok {
+		bs, _ := b.(string)
+		return strings.Compare(as, bs)
+	}
+	return cmp.Compare(toFloat(a), toFloat(b))
+}
+
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,261 @@
+// Package query parses and applies the standard query parameters of list
+// endpoints:
+//
+//	limit=N             page size
+//	page=N              offset pagination, 1-based
+//	cursor=C            opaque cursor from the next link of the previous page
+//	sort=-title,id      comma separated fields, descending when prefixed by -
+//	field=v, field~=v   filters: equal to v, or containing v ignoring case
+//	fields=id,title     sparse fieldsets: only return the listed fields
+//
+// Without a page parameter lists are paginated by cursor, starting from the
+// first item. Repeated filters must all match. Every field is checked
+// against the allowlists of a Config, and invalid parameters are reported as
+// validator.Errors keyed by parameter name.
+//
+// Apply filters, sorts and paginates a whole list in memory. Stores that can
+// filter, sort and page themselves fetch from Offset or After instead, and
+// build the Page with Paginate.
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gofetch.timwalker.dev/internal/validator"
+)
+
+// Operator is a filter comparison.
+type Operator string
+
+const (
+	// Equal matches values equal to the filter value.
+	Equal Operator = "="
+	// Contains matches strings containing the filter value, ignoring case.
+	Contains Operator = "~="
+)
+
+// Config is the allowlist and defaults for a resource.
+type Config struct {
+	// Filters maps filterable fields to the operators they allow.
+	Filters map[string][]Operator
+	// Sort lists the sortable fields.
+	Sort []string
+	// Fields lists the fields that may be selected with fields=.
+	Fields []string
+	// Key is a unique field. It is appended to every sort so the order, and
+	// therefore cursors, are stable.
+	Key string
+	// DefaultSort is used when the request has no sort parameter.
+	DefaultSort  string
+	DefaultLimit int
+	MaxLimit     int
+}
+
+// Filter is a parsed filter parameter.
+type Filter struct {
+	Field string
+	Op    Operator
+	Value string
+}
+
+// SortField is a field of a sort parameter.
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Query is a parsed list request.
+type Query struct {
+	// Page is the 1-based page number, or 0 when paginating by cursor.
+	Page    int
+	Limit   int
+	Sort    []SortField
+	Filters []Filter
+	Fields  []string
+
+	// after holds the sort values of the last item of the previous page when
+	// paginating by cursor.
+	after []any
+	url   url.URL
+}
+
+// Offset is the index of the first item of the page when paginating by page
+// number, and 0 otherwise.
+func (q *Query) Offset() int {
+	if q.Page == 0 {
+		return 0
+	}
+	return (q.Page - 1) * q.Limit
+}
+
+// After returns the sort values of the last item of the previous page when
+// paginating by cursor, or nil. The page starts with the first item sorting
+// after them. Numbers are float64.
+func (q *Query) After() []any {
+	return q.after
+}
+
+// reserved are the parameter names that are not filters.
+var reserved = []string{"page", "limit", "cursor", "sort", "fields"}
+
+// Parse parses the query parameters of u against cfg. It returns
+// validator.Errors describing every invalid parameter.
+func Parse(u *url.URL, cfg Config) (*Query, error) {
+	values := u.Query()
+	errs := validator.Errors{}
+	q := &Query{Limit: cfg.DefaultLimit, url: *u}
+
+	if v := values.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		switch {
+		case err != nil:
+			errs.Add("limit", "must be an integer")
+		case limit < 1 || limit > cfg.MaxLimit:
+			errs.Add("limit", fmt.Sprintf("must be between 1 and %d", cfg.MaxLimit))
+		default:
+			q.Limit = limit
+		}
+	}
+
+	sortParam := values.Get("sort")
+	if sortParam == "" {
+		sortParam = cfg.DefaultSort
+	}
+	q.Sort = parseSort(sortParam, cfg, errs)
+
+	if v := values.Get("page"); v != "" {
+		page, err := strconv.Atoi(v)
+		if err != nil || page < 1 {
+			errs.Add("page", "must be a positive integer")
+		} else {
+			q.Page = page
+		}
+	}
+
+	if v := values.Get("cursor"); v != "" {
+		if values.Has("page") {
+			errs.Add("cursor", "cannot be combined with page")
+		} else if after, err := decodeCursor(v, q.Sort); err != nil {
+			errs.Add("cursor", err.Error())
+		} else {
+			q.after = after
+		}
+	}
+
+	if v := values.Get("fields"); v != "" {
+		for field := range strings.SplitSeq(v, ",") {
+			if !slices.Contains(cfg.Fields, field) {
+				errs.Add("fields", fmt.Sprintf("unknown field %q", field))
+				continue
+			}
+			if !slices.Contains(q.Fields, field) {
+				q.Fields = append(q.Fields, field)
+			}
+		}
+	}
+
+	for param, vs := range values {
+		if slices.Contains(reserved, param) {
+			continue
+		}
+		field, op := param, Equal
+		if f, ok := strings.CutSuffix(param, "~"); ok {
+			field, op = f, Contains
+		}
+
+		ops, ok := cfg.Filters[field]
+		if !ok {
+			errs.Add(param, "is not a supported filter")
+			continue
+		}
+		if !slices.Contains(ops, op) {
+			errs.Add(param, fmt.Sprintf("does not support the %s operator", op))
+			continue
+		}
+		for _, v := range vs {
+			q.Filters = append(q.Filters, Filter{Field: field, Op: op, Value: v})
+		}
+	}
+	// Map iteration order is random; keep filters in a stable order.
+	slices.SortFunc(q.Filters, func(a, b Filter) int {
+		return strings.Compare(a.Field+string(a.Op)+a.Value, b.Field+string(b.Op)+b.Value)
+	})
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return q, nil
+}
+
+func parseSort(param string, cfg Config, errs validator.Errors) []SortField {
+	var fields []SortField
+	seen := map[string]bool{}
+
+	for part := range strings.SplitSeq(param, ",") {
+		field, desc := strings.CutPrefix(strings.TrimSpace(part), "-")
+		if !slices.Contains(cfg.Sort, field) {
+			errs.Add("sort", fmt.Sprintf("unknown sort field %q", field))
+			continue
+		}
+		if seen[field] {
+			errs.Add("sort", fmt.Sprintf("duplicate sort field %q", field))
+			continue
+		}
+		seen[field] = true
+		fields = append(fields, SortField{Field: field, Desc: desc})
+	}
+
+	if cfg.Key != "" && !seen[cfg.Key] {
+		fields = append(fields, SortField{Field: cfg.Key})
+	}
+	return fields
+}
+
+// sortString formats sort fields as a sort parameter.
+func sortString(sort []SortField) string {
+	parts := make([]string, len(sort))
+	for i, s := range sort {
+		parts[i] = s.Field
+		if s.Desc {
+			parts[i] = "-" + s.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// cursor is the decoded form of a cursor parameter. Sort records the order
+// the cursor was issued for, so it cannot be reused with another sort.
+type cursor struct {
+	Sort  string `json:"s"`
+	After []any  `json:"a"`
+}
+
+var errInvalidCursor = errors.New("is invalid")
+
+func encodeCursor(sort []SortField, after []any) string {
+	b, _ := json.Marshal(cursor{Sort: sortString(sort), After: after})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string, sort []SortField) ([]any, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(b, &c); err != nil || len(c.After) != len(sort) {
+		return nil, errInvalidCursor
+	}
+	if c.Sort != sortString(sort) {
+		return nil, errors.New("was issued for a different sort")
+	}
+	return c.After, nil
+}
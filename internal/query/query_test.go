@@ -0,0 +1,172 @@
+package query
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"gofetch.timwalker.dev/internal/validator"
+)
+
+type item struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Group string `json:"group"`
+}
+
+func itemField(it item, field string) any {
+	switch field {
+	case "id":
+		return it.ID
+	case "name":
+		return it.Name
+	case "group":
+		return it.Group
+	}
+	return nil
+}
+
+var testConfig = Config{
+	Filters:      map[string][]Operator{"group": {Equal}, "name": {Equal, Contains}},
+	Sort:         []string{"id", "name", "group"},
+	Fields:       []string{"id", "name", "group"},
+	Key:          "id",
+	DefaultSort:  "id",
+	DefaultLimit: 2,
+	MaxLimit:     10,
+}
+
+var testItems = []item{
+	{ID: 1, Name: "delta", Group: "a"},
+	{ID: 2, Name: "alpha", Group: "b"},
+	{ID: 3, Name: "charlie", Group: "a"},
+	{ID: 4, Name: "bravo", Group: "a"},
+	{ID: 5, Name: "alpha", Group: "a"},
+}
+
+func mustParse(t *testing.T, rawQuery string) *Query {
+	t.Helper()
+	q, err := Parse(&url.URL{Path: "/items", RawQuery: rawQuery}, testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func ids(items []item) []int {
+	var ids []int
+	for _, it := range items {
+		ids = append(ids, it.ID)
+	}
+	return ids
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query  string
+		expect validator.Errors
+	}{
+		{"limit=0", validator.Errors{"limit": "must be between 1 and 10"}},
+		{"limit=x", validator.Errors{"limit": "must be an integer"}},
+		{"page=-1", validator.Errors{"page": "must be a positive integer"}},
+		{"sort=year", validator.Errors{"sort": `unknown sort field "year"`}},
+		{"sort=name,-name", validator.Errors{"sort": `duplicate sort field "name"`}},
+		{"fields=id,secret", validator.Errors{"fields": `unknown field "secret"`}},
+		{"owner=1", validator.Errors{"owner": "is not a supported filter"}},
+		{"group~=a", validator.Errors{"group~": "does not support the ~= operator"}},
+		{"cursor=!!", validator.Errors{"cursor": "is invalid"}},
+		{"page=1&cursor=abc", validator.Errors{"cursor": "cannot be combined with page"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(&url.URL{RawQuery: tt.query}, testConfig)
+			var errs validator.Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected validator.Errors, got %v", err)
+			}
+			if !reflect.DeepEqual(errs, tt.expect) {
+				t.Errorf("got %v want %v", errs, tt.expect)
+			}
+		})
+	}
+}
+
+func TestApplyFilterAndSort(t *testing.T) {
+	q := mustParse(t, "group=a&name~=A&sort=name,-id&limit=10")
+	results, page := Apply(testItems, q, itemField)
+
+	if got, want := ids(results), []int{5, 4, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if page.Total != 4 || page.Next != "" {
+		t.Errorf("unexpected page: %+v", page)
+	}
+}
+
+func TestApplyPages(t *testing.T) {
+	q := mustParse(t, "page=2")
+	results, page := Apply(testItems, q, itemField)
+
+	if got, want := ids(results), []int{3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+	if page.Total != 5 || page.Page != 2 || page.Next != "/items?page=3" {
+		t.Errorf("unexpected page: %+v", page)
+	}
+
+	want := `</items?page=1>; rel="first", </items?page=1>; rel="prev", </items?page=3>; rel="next", </items?page=3>; rel="last"`
+	if got := page.LinkHeader(); got != want {
+		t.Errorf("Link: got %s want %s", got, want)
+	}
+}
+
+func TestApplyCursor(t *testing.T) {
+	var got []int
+	rawQuery := "sort=-name"
+	for range 5 {
+		results, page := Apply(testItems, mustParse(t, rawQuery), itemField)
+		got = append(got, ids(results)...)
+		if page.Page != 0 || page.Total != 5 {
+			t.Errorf("unexpected page: %+v", page)
+		}
+		if page.Next == "" {
+			break
+		}
+		next, err := url.Parse(page.Next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawQuery = next.RawQuery
+	}
+
+	if want := []int{1, 3, 4, 2, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+}
+
+func TestCursorRejectsDifferentSort(t *testing.T) {
+	_, page := Apply(testItems, mustParse(t, "sort=name"), itemField)
+	next, _ := url.Parse(page.Next)
+
+	values := next.Query()
+	values.Set("sort", "-name")
+	_, err := Parse(&url.URL{RawQuery: values.Encode()}, testConfig)
+	if err == nil {
+		t.Fatal("expected an error reusing a cursor with another sort")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	data, err := Select(testItems[:1], mustParse(t, "fields=name,id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := json.Marshal(data)
+	if got, want := string(b), `[{"id":1,"name":"delta"}]`; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
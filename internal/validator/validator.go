@@ -0,0 +1,316 @@
+// Package validator validates structs using `validate` struct tags and
+// optional Validate methods.
+//
+// Rules are comma separated and checked in order, stopping at the first
+// failure for a field:
+//
+//	required      the value must not be the zero value
+//	omitempty     skip the remaining rules when the value is the zero value
+//	min=N, max=N  bounds on numbers, or on the length of strings, slices and maps
+//	len=N         exact length of strings, slices and maps
+//	oneof=a b c   the value must be one of the space separated options
+//	regexp=expr   strings must match expr; must be the last rule
+//
+// Nested structs, pointers to structs and slices of structs are validated
+// recursively, with errors keyed by their JSON field path such as
+// "tracks[0].title".
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Errors maps JSON field paths to a validation message.
+type Errors map[string]string
+
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	msgs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		msgs = append(msgs, field+": "+e[field])
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Add records message for field unless the field already has an error.
+func (e Errors) Add(field, message string) {
+	if _, exists := e[field]; !exists {
+		e[field] = message
+	}
+}
+
+// Validator is implemented by types with validation that cannot be expressed
+// with tags, such as rules spanning several fields. Validate should return
+// Errors to report specific fields; any other error is reported against the
+// struct itself.
+type Validator interface {
+	Validate() error
+}
+
+// Struct validates v, which should be a struct or a pointer to one. It
+// returns Errors when validation fails and nil otherwise. Invalid tags are
+// programming errors and cause a panic.
+func Struct(v any) error {
+	errs := Errors{}
+	validateValue(reflect.ValueOf(v), "", errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+var validatorType = reflect.TypeFor[Validator]()
+
+func validateValue(v reflect.Value, path string, errs Errors) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name := fieldName(f)
+			if name == "-" {
+				continue
+			}
+			fieldPath := joinPath(path, name)
+			fv := v.Field(i)
+
+			if tag, ok := f.Tag.Lookup("validate"); ok {
+				for _, r := range parseRules(tag) {
+					if r.skip(fv) {
+						break
+					}
+					if msg := r.check(fv); msg != "" {
+						errs.Add(fieldPath, msg)
+						break
+					}
+				}
+			}
+
+			validateValue(fv, fieldPath, errs)
+		}
+		callValidate(v, path, errs)
+
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			validateValue(iter.Value(), joinPath(path, fmt.Sprint(iter.Key().Interface())), errs)
+		}
+	}
+}
+
+// callValidate runs the Validate method of v, including pointer receiver
+// methods when v is addressable.
+func callValidate(v reflect.Value, path string, errs Errors) {
+	var validator Validator
+	switch {
+	case v.CanAddr() && v.Addr().Type().Implements(validatorType):
+		validator = v.Addr().Interface().(Validator)
+	case v.Type().Implements(validatorType) && v.CanInterface():
+		validator = v.Interface().(Validator)
+	default:
+		return
+	}
+
+	err := validator.Validate()
+	if err == nil {
+		return
+	}
+
+	var fieldErrs Errors
+	if errors.As(err, &fieldErrs) {
+		for field, msg := range fieldErrs {
+			errs.Add(joinPath(path, field), msg)
+		}
+		return
+	}
+
+	if path == "" {
+		path = "body"
+	}
+	errs.Add(path, err.Error())
+}
+
+// fieldName returns the JSON name for a struct field.
+func fieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+type rule struct {
+	name  string
+	arg   string
+	num   float64
+	re    *regexp.Regexp
+	oneof []string
+}
+
+// rulesCache holds parsed rules by tag so tags are parsed once.
+var rulesCache sync.Map
+
+func parseRules(tag string) []rule {
+	if cached, ok := rulesCache.Load(tag); ok {
+		return cached.([]rule)
+	}
+
+	var rules []rule
+	rest := tag
+	for rest != "" {
+		var part string
+		// regexp consumes the rest of the tag as it may contain commas.
+		if strings.HasPrefix(rest, "regexp=") {
+			part, rest = rest, ""
+		} else {
+			part, rest, _ = strings.Cut(rest, ",")
+		}
+
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		r := rule{name: name, arg: arg}
+
+		switch name {
+		case "required", "omitempty":
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validator: invalid %s argument %q in tag %q", name, arg, tag))
+			}
+			r.num = n
+		case "oneof":
+			r.oneof = strings.Fields(arg)
+		case "regexp":
+			r.re = regexp.MustCompile(arg)
+		default:
+			panic(fmt.Sprintf("validator: unknown rule %q in tag %q", name, tag))
+		}
+		rules = append(rules, r)
+	}
+
+	rulesCache.Store(tag, rules)
+	return rules
+}
+
+// skip reports whether the remaining rules should be skipped for v.
+func (r rule) skip(v reflect.Value) bool {
+	if r.name == "omitempty" {
+		return v.IsZero()
+	}
+	// Only required applies to nil pointers.
+	return r.name != "required" && v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+// check returns a message describing why v fails the rule, or "" if it passes.
+func (r rule) check(v reflect.Value) string {
+	if r.name == "required" {
+		if v.IsZero() || (isCollection(v) && v.Len() == 0) {
+			return "is required"
+		}
+		return ""
+	}
+
+	for v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+
+	switch r.name {
+	case "min", "max", "len":
+		return r.checkBound(v)
+	case "oneof":
+		s := fmt.Sprint(v.Interface())
+		if !slices.Contains(r.oneof, s) {
+			return "must be one of: " + strings.Join(r.oneof, ", ")
+		}
+	case "regexp":
+		if v.Kind() != reflect.String {
+			panic(fmt.Sprintf("validator: regexp rule on non-string kind %s", v.Kind()))
+		}
+		if !r.re.MatchString(v.String()) {
+			return "must match the pattern " + r.re.String()
+		}
+	}
+	return ""
+}
+
+func (r rule) checkBound(v reflect.Value) string {
+	var n float64
+	var unit string
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	case reflect.String:
+		n, unit = float64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		n, unit = float64(v.Len()), " items"
+	default:
+		panic(fmt.Sprintf("validator: %s rule on unsupported kind %s", r.name, v.Kind()))
+	}
+
+	if unit == "" && r.name == "len" {
+		panic(fmt.Sprintf("validator: len rule on numeric kind %s", v.Kind()))
+	}
+
+	switch {
+	case r.name == "min" && n < r.num:
+		if unit == "" {
+			return "must be at least " + r.arg
+		}
+		return "must contain at least " + r.arg + unit
+	case r.name == "max" && n > r.num:
+		if unit == "" {
+			return "must be at most " + r.arg
+		}
+		return "must contain at most " + r.arg + unit
+	case r.name == "len" && n != r.num:
+		return "must contain exactly " + r.arg + unit
+	}
+	return ""
+}
+
+func isCollection(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return true
+	}
+	return false
+}
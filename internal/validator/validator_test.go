@@ -0,0 +1,115 @@
+package validator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type track struct {
+	Title string `json:"title" validate:"required,max=10"`
+}
+
+type release struct {
+	Name     string   `json:"name" validate:"required,min=2"`
+	Year     int      `json:"year" validate:"min=1900,max=2100"`
+	Format   string   `json:"format" validate:"omitempty,oneof=cd vinyl"`
+	Code     string   `json:"code" validate:"omitempty,regexp=^[A-Z]{2}-[0-9]{1,3}$"`
+	Country  *string  `json:"country" validate:"len=2"`
+	Tags     []string `json:"tags" validate:"max=2"`
+	Tracks   []track  `json:"tracks" validate:"required"`
+	Producer *track   `json:"producer"`
+	Ignored  string   `json:"-" validate:"required"`
+}
+
+// Validate checks rules spanning several fields.
+func (r *release) Validate() error {
+	if r.Format == "vinyl" && r.Year > 2000 {
+		return Errors{"format": "vinyl releases must be from 2000 or earlier"}
+	}
+	return nil
+}
+
+func validRelease() *release {
+	return &release{
+		Name:   "Album",
+		Year:   1999,
+		Format: "vinyl",
+		Code:   "AB-12",
+		Tracks: []track{{Title: "one"}},
+	}
+}
+
+func TestStruct(t *testing.T) {
+	country := func(s string) *string { return &s }
+
+	tests := []struct {
+		name   string
+		modify func(r *release)
+		expect Errors
+	}{
+		{"valid", func(r *release) {}, nil},
+		{"required", func(r *release) { r.Name = "" }, Errors{"name": "is required"}},
+		{"min_length", func(r *release) { r.Name = "A" }, Errors{"name": "must contain at least 2 characters"}},
+		{"numeric_bounds", func(r *release) { r.Year = 1800 }, Errors{"year": "must be at least 1900"}},
+		{"oneof", func(r *release) { r.Format = "tape" }, Errors{"format": "must be one of: cd, vinyl"}},
+		{"omitempty_skips_zero", func(r *release) { r.Format, r.Code = "", "" }, nil},
+		{"regexp", func(r *release) { r.Code = "ab-1" }, Errors{"code": "must match the pattern ^[A-Z]{2}-[0-9]{1,3}$"}},
+		{"pointer_len", func(r *release) { r.Country = country("USA") }, Errors{"country": "must contain exactly 2 characters"}},
+		{"nil_pointer_skipped", func(r *release) { r.Country = nil }, nil},
+		{"slice_max", func(r *release) { r.Tags = []string{"a", "b", "c"} }, Errors{"tags": "must contain at most 2 items"}},
+		{"empty_slice_required", func(r *release) { r.Tracks = []track{} }, Errors{"tracks": "is required"}},
+		{"nested_slice", func(r *release) { r.Tracks = append(r.Tracks, track{}) }, Errors{"tracks[1].title": "is required"}},
+		{"nested_pointer", func(r *release) { r.Producer = &track{Title: "far too long"} }, Errors{"producer.title": "must contain at most 10 characters"}},
+		{"validate_method", func(r *release) { r.Year = 2010 }, Errors{"format": "vinyl releases must be from 2000 or earlier"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := validRelease()
+			tt.modify(r)
+
+			err := Struct(r)
+			if tt.expect == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected Errors, got %v", err)
+			}
+			if !reflect.DeepEqual(errs, tt.expect) {
+				t.Errorf("got %v want %v", errs, tt.expect)
+			}
+		})
+	}
+}
+
+type plainError struct{}
+
+func (plainError) Validate() error { return errors.New("something is wrong") }
+
+func TestStructPlainValidateError(t *testing.T) {
+	var errs Errors
+	if !errors.As(Struct(plainError{}), &errs) {
+		t.Fatal("expected Errors")
+	}
+	if errs["body"] != "something is wrong" {
+		t.Errorf("got %v", errs)
+	}
+}
+
+func TestStructPanicsOnInvalidTag(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an unknown rule")
+		}
+	}()
+
+	Struct(struct {
+		Name string `validate:"unknown"`
+	}{})
+}
@@ -0,0 +1,66 @@
+// Package version reports the build metadata of the binary. Values set with
+// -ldflags take precedence over those Go embeds from version control:
+//
+//	go build -ldflags="-X gofetch.timwalker.dev/internal/version.version=v1.2.0 \
+//		-X gofetch.timwalker.dev/internal/version.commit=$(git rev-parse HEAD) \
+//		-X gofetch.timwalker.dev/internal/version.dirty=false \
+//		-X gofetch.timwalker.dev/internal/version.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"cmp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+)
+
+// Set with -ldflags -X. dirty is a string since -X only sets strings.
+var (
+	version   string
+	commit    string
+	dirty     string
+	buildTime string
+)
+
+// Info is the build metadata of the binary. Unknown values are empty, and
+// BuildTime falls back to the commit time Go embeds when not set by -ldflags.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Dirty     bool   `json:"dirty"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build metadata.
+var Get = sync.OnceValue(read)
+
+func read() Info {
+	info := Info{GoVersion: runtime.Version()}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		// go run and go build in a checkout report "(devel)".
+		if bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Commit = s.Value
+			case "vcs.modified":
+				info.Dirty = s.Value == "true"
+			case "vcs.time":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+
+	info.Version = cmp.Or(version, info.Version, "dev")
+	info.Commit = cmp.Or(commit, info.Commit)
+	info.BuildTime = cmp.Or(buildTime, info.BuildTime)
+	if d, err := strconv.ParseBool(dirty); err == nil {
+		info.Dirty = d
+	}
+	return info
+}
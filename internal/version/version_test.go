@@ -0,0 +1,21 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	info := read()
+	if info.Version == "" || info.GoVersion != runtime.Version() {
+		t.Errorf("unexpected defaults: %+v", info)
+	}
+
+	version, commit, dirty, buildTime = "v1.2.3", "abc123", "true", "2024-01-02T03:04:05Z"
+	t.Cleanup(func() { version, commit, dirty, buildTime = "", "", "", "" })
+
+	want := Info{Version: "v1.2.3", Commit: "abc123", Dirty: true, BuildTime: "2024-01-02T03:04:05Z", GoVersion: runtime.Version()}
+	if got := read(); got != want {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+}
@@ -0,0 +1,273 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// acceptGUID is appended to the client key to compute Sec-WebSocket-Accept.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// HandshakeError is returned by Upgrade for requests that are not a valid
+// opening handshake. Status is the HTTP status to respond with.
+type HandshakeError struct {
+	Status int
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket: " + e.Reason
+}
+
+// UpgradeOptions configures Upgrade.
+type UpgradeOptions struct {
+	// Subprotocols lists the supported subprotocols in order of preference.
+	Subprotocols []string
+	// CheckOrigin reports whether to accept a request's Origin. When nil,
+	// requests without an Origin, such as those of non-browser clients, and
+	// same-origin requests are accepted.
+	CheckOrigin func(r *http.Request) bool
+	// ReadLimit overrides DefaultReadLimit.
+	ReadLimit int64
+}
+
+// Upgrade completes the opening handshake and hijacks the connection. On a
+// *HandshakeError nothing has been written: Upgrade only sets the headers
+// the error response needs, so callers can send it in their own format.
+// Headers already set on w are included in the handshake response.
+func Upgrade(w http.ResponseWriter, r *http.Request, opts *UpgradeOptions) (*Conn, error) {
+	if opts == nil {
+		opts = &UpgradeOptions{}
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		return nil, &HandshakeError{http.StatusMethodNotAllowed, "handshake method must be GET"}
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		return nil, &HandshakeError{http.StatusUpgradeRequired, "request is not a websocket upgrade"}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, &HandshakeError{http.StatusUpgradeRequired, "unsupported Sec-WebSocket-Version"}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, &HandshakeError{http.StatusBadRequest, "invalid Sec-WebSocket-Key"}
+	}
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return nil, &HandshakeError{http.StatusForbidden, "origin not allowed"}
+	}
+
+	subprotocol := negotiateSubprotocol(r.Header, opts.Subprotocols)
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+	// The server's read and write timeouts stay set on a hijacked
+	// connection.
+	netConn.SetDeadline(time.Time{})
+
+	h := w.Header().Clone()
+	for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Vary"} {
+		h.Del(name)
+	}
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(key))
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+
+	bw := brw.Writer
+	bw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	h.Write(bw)
+	bw.WriteString("\r\n")
+	if err := bw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	c := newConn(netConn, brw.Reader, true, subprotocol)
+	if opts.ReadLimit > 0 {
+		c.readLimit = opts.ReadLimit
+	}
+	return c, nil
+}
+
+// acceptKey returns the Sec-WebSocket-Accept value for a client key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContainsToken reports whether the comma-separated header name
+// contains token, ignoring case.
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameOrigin accepts requests without an Origin header and those whose
+// Origin host matches the Host header.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// negotiateSubprotocol returns the first of supported that the client
+// offers.
+func negotiateSubprotocol(h http.Header, supported []string) string {
+	var offered []string
+	for _, v := range h.Values("Sec-WebSocket-Protocol") {
+		for p := range strings.SplitSeq(v, ",") {
+			offered = append(offered, strings.TrimSpace(p))
+		}
+	}
+	for _, p := range supported {
+		if slices.Contains(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// ErrBadHandshake is returned by Dial when the server does not complete the
+// opening handshake. The response is returned alongside it.
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Dial opens a client connection to a ws, wss, http or https URL. header is
+// sent with the handshake request, for example to offer subprotocols with
+// Sec-WebSocket-Protocol. The handshake response is returned, with its body
+// closed, on success and with ErrBadHandshake.
+func Dial(ctx context.Context, rawURL string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var netConn net.Conn
+	if u.Scheme == "https" {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}
+		netConn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		var dialer net.Dialer
+		netConn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The context bounds the handshake, not the connection.
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { netConn.SetDeadline(time.Now()) })
+	defer stop()
+
+	c, resp, err := handshake(netConn, u, header)
+	if err != nil {
+		netConn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, resp, err
+	}
+	if !stop() {
+		netConn.Close()
+		return nil, resp, ctx.Err()
+	}
+	netConn.SetDeadline(time.Time{})
+	return c, resp, nil
+}
+
+func handshake(netConn net.Conn, u *url.URL, header http.Header) (*Conn, *http.Response, error) {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header.Clone(),
+		Host:       u.Host,
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(netConn); err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContainsToken(resp.Header, "Upgrade", "websocket") ||
+		!headerContainsToken(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, resp, ErrBadHandshake
+	}
+
+	return newConn(netConn, br, false, resp.Header.Get("Sec-WebSocket-Protocol")), resp, nil
+}
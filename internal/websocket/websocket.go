@@ -0,0 +1,511 @@
+// Package websocket implements the WebSocket protocol (RFC 6455) on top of
+// net/http, without extensions. Upgrade turns a request into a server Conn
+// and Dial opens a client Conn; both read whole messages, reassembling
+// fragments, answer pings and run the closing handshake.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	// TextMessage is a UTF-8 encoded message.
+	TextMessage MessageType = opText
+	// BinaryMessage is a message of arbitrary bytes.
+	BinaryMessage MessageType = opBinary
+)
+
+// Frame opcodes.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes defined by RFC 6455 section 7.4.1 and the IANA registry.
+const (
+	CloseNormalClosure      = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatusReceived   = 1005
+	CloseAbnormalClosure    = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+	CloseServiceRestart     = 1012
+	CloseTryAgainLater      = 1013
+)
+
+const (
+	// DefaultReadLimit is the largest message a Conn reads unless changed
+	// with SetReadLimit.
+	DefaultReadLimit = 1 << 20
+	// maxControlPayload is the largest payload of a control frame.
+	maxControlPayload = 125
+	// closeTimeout bounds the closing handshake and the writes of control
+	// frames sent in reply to the peer.
+	closeTimeout = 5 * time.Second
+)
+
+// ErrClosed is returned by writes after the close frame was sent.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// CloseError is returned by ReadMessage once the connection is closed by a
+// close frame, either received from the peer or sent because the peer
+// violated the protocol. Code is CloseNoStatusReceived when the peer's
+// close frame had no code.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: close %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: close %d: %s", e.Code, e.Reason)
+}
+
+// IsCloseError reports whether err is a *CloseError with one of codes.
+func IsCloseError(err error, codes ...int) bool {
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		return false
+	}
+	for _, code := range codes {
+		if closeErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others write;
+// writes are serialized.
+type Conn struct {
+	conn        net.Conn
+	isServer    bool
+	subprotocol string
+
+	readMu      sync.Mutex
+	br          *bufio.Reader
+	readLimit   int64
+	readErr     error
+	pongHandler func(data []byte)
+
+	writeMu       sync.Mutex
+	bw            *bufio.Writer
+	writeDeadline time.Time
+	closeSent     bool
+
+	closeOnce sync.Once
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, isServer bool, subprotocol string) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{
+		conn:        conn,
+		isServer:    isServer,
+		subprotocol: subprotocol,
+		br:          br,
+		readLimit:   DefaultReadLimit,
+		bw:          bufio.NewWriter(conn),
+	}
+}
+
+// Subprotocol returns the negotiated subprotocol, if any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadLimit sets the largest message ReadMessage accepts. Larger
+// messages close the connection with CloseMessageTooBig.
+func (c *Conn) SetReadLimit(limit int64) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.readLimit = limit
+}
+
+// SetReadDeadline sets the deadline of reads. A read that times out closes
+// the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline of writes of data messages and pings.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.writeDeadline = t
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPongHandler sets a func called from ReadMessage with the payload of
+// each pong received, typically to extend the read deadline.
+func (c *Conn) SetPongHandler(h func(data []byte)) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	c.pongHandler = h
+}
+
+// ReadMessage reads the next data message, answering pings and passing
+// pongs to the pong handler meanwhile. It returns a *CloseError once the
+// connection is closed, and any read error, such as a timeout, after closing
+// the connection.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	return c.readMessage()
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+
+	var typ MessageType
+	var msg []byte
+	for {
+		f, err := c.readFrame(c.readLimit - int64(len(msg)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.writeControl(opPong, f.payload); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case opPong:
+			if c.pongHandler != nil {
+				c.pongHandler(f.payload)
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(protocolError("expected a continuation frame"))
+			}
+			typ = MessageType(f.opcode)
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(protocolError("unexpected continuation frame"))
+			}
+		}
+
+		msg = append(msg, f.payload...)
+		if f.fin {
+			if typ == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "invalid UTF-8 in text message"})
+			}
+			if msg == nil {
+				msg = []byte{}
+			}
+			return typ, msg, nil
+		}
+	}
+}
+
+// frame is a frame read from the peer, with its payload unmasked.
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+func protocolError(reason string) *CloseError {
+	return &CloseError{Code: CloseProtocolError, Reason: reason}
+}
+
+// readFrame reads a frame, returning a *CloseError for frames that violate
+// the protocol and for data frames with a payload over remaining bytes.
+func (c *Conn) readFrame(remaining int64) (frame, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(c.br, h[:2]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{fin: h[0]&0x80 != 0, opcode: h[0] & 0x0f}
+	if h[0]&0x70 != 0 {
+		return frame{}, protocolError("reserved bits set without a negotiated extension")
+	}
+	masked := h[1]&0x80 != 0
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(c.br, h[:2]); err != nil {
+			return frame{}, err
+		}
+		n = uint64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, h[:8]); err != nil {
+			return frame{}, err
+		}
+		n = binary.BigEndian.Uint64(h[:8])
+		if n>>63 != 0 {
+			return frame{}, protocolError("payload length has the most significant bit set")
+		}
+	}
+
+	switch f.opcode {
+	case opContinuation, opText, opBinary:
+		if n > uint64(max(remaining, 0)) {
+			return frame{}, &CloseError{Code: CloseMessageTooBig, Reason: "message too big"}
+		}
+	case opClose, opPing, opPong:
+		if !f.fin {
+			return frame{}, protocolError("fragmented control frame")
+		}
+		if n > maxControlPayload {
+			return frame{}, protocolError("control frame payload too long")
+		}
+	default:
+		return frame{}, protocolError(fmt.Sprintf("reserved opcode %#x", f.opcode))
+	}
+
+	// Clients mask every frame and servers none (RFC 6455 section 5.1).
+	if masked != c.isServer {
+		if c.isServer {
+			return frame{}, protocolError("client frame is not masked")
+		}
+		return frame{}, protocolError("server frame is masked")
+	}
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return frame{}, err
+		}
+	}
+
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		mask(key, f.payload)
+	}
+	return f, nil
+}
+
+// handleClose replies to a close frame from the peer, unless it answers one
+// sent by this side, and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	switch {
+	case len(payload) == 1:
+		return c.fail(protocolError("close frame payload of one byte"))
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(protocolError(fmt.Sprintf("invalid close code %d", closeErr.Code)))
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(&CloseError{Code: CloseInvalidPayload, Reason: "invalid UTF-8 in close reason"})
+		}
+	}
+
+	// The reply echoes the code (RFC 6455 section 5.5.1).
+	c.writeClose(closeErr.Code, "")
+	c.closeConn()
+	c.readErr = closeErr
+	return closeErr
+}
+
+// fail closes the connection after a read error, first sending a close
+// frame if err is a protocol violation. It returns the error to report from
+// then on.
+func (c *Conn) fail(err error) error {
+	if closeErr, ok := err.(*CloseError); ok {
+		c.writeClose(closeErr.Code, closeErr.Reason)
+	}
+	c.closeConn()
+	c.readErr = err
+	return err
+}
+
+// validCloseCode reports whether code may be sent in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WriteMessage writes data as a single frame.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrame(byte(typ), data)
+}
+
+// Ping writes a ping with data, which the peer answers with a pong.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return errors.New("websocket: ping payload too long")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.writeFrame(opPing, data)
+}
+
+// writeControl writes a control frame in reply to the peer with its own
+// deadline, so an unresponsive peer cannot block the reader.
+func (c *Conn) writeControl(opcode byte, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	defer c.conn.SetWriteDeadline(c.writeDeadline)
+	return c.writeFrame(opcode, data)
+}
+
+// writeClose sends a close frame unless one was already sent.
+func (c *Conn) writeClose(code int, reason string) error {
+	var payload []byte
+	if code != CloseNoStatusReceived {
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	err := c.writeFrame(opClose, payload)
+	c.closeSent = true
+	return err
+}
+
+// writeFrame writes a final frame. The caller holds writeMu.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	var h [14]byte
+	h[0] = 0x80 | opcode
+	n := 2
+	switch l := len(payload); {
+	case l < 126:
+		h[1] = byte(l)
+	case l <= 0xffff:
+		h[1] = 126
+		binary.BigEndian.PutUint16(h[2:], uint16(l))
+		n += 2
+	default:
+		h[1] = 127
+		binary.BigEndian.PutUint64(h[2:], uint64(l))
+		n += 8
+	}
+
+	if !c.isServer {
+		h[1] |= 0x80
+		var key [4]byte
+		rand.Read(key[:])
+		copy(h[n:], key[:])
+		n += 4
+		masked := make([]byte, len(payload))
+		copy(masked, payload)
+		mask(key, masked)
+		payload = masked
+	}
+
+	if _, err := c.bw.Write(h[:n]); err != nil {
+		return err
+	}
+	if _, err := c.bw.Write(payload); err != nil {
+		return err
+	}
+	return c.bw.Flush()
+}
+
+// mask applies the masking key to b in place; masking and unmasking are the
+// same operation.
+func mask(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// Close starts the closing handshake with code and reason and closes the
+// connection once the peer replies or after a timeout. A goroutine blocked
+// in ReadMessage receives the reply and returns its *CloseError; without
+// one, Close reads until the reply itself, discarding data messages.
+func (c *Conn) Close(code int, reason string) error {
+	if !validCloseCode(code) {
+		return fmt.Errorf("websocket: invalid close code %d", code)
+	}
+	if len(reason) > maxControlPayload-2 {
+		return errors.New("websocket: close reason too long")
+	}
+
+	err := c.writeClose(code, reason)
+	if errors.Is(err, ErrClosed) {
+		return nil
+	}
+	if err != nil {
+		c.closeConn()
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	if c.readMu.TryLock() {
+		defer c.readMu.Unlock()
+		for {
+			if _, _, err := c.readMessage(); err != nil {
+				break
+			}
+		}
+		c.closeConn()
+	}
+	return nil
+}
+
+// CloseNow closes the connection without the closing handshake, unblocking
+// any read or write in progress.
+func (c *Conn) CloseNow() error {
+	return c.closeConn()
+}
+
+func (c *Conn) closeConn() error {
+	var err error
+	c.closeOnce.Do(func() { err = c.conn.Close() })
+	return err
+}
@@ -0,0 +1,524 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests below drive the server with a raw client that writes frames byte
+// by byte, in the spirit of the Autobahn test suite: each case sends a
+// sequence of frames and checks the frames the server answers with.
+
+const testReadLimit = 1 << 17
+
+// newEchoServer returns a server that echoes every message back.
+func newEchoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r, &UpgradeOptions{Subprotocols: []string{"chat.v2", "chat.v1"}, ReadLimit: testReadLimit})
+		if err != nil {
+			var herr *HandshakeError
+			if errors.As(err, &herr) {
+				http.Error(w, herr.Reason, herr.Status)
+			}
+			return
+		}
+		for {
+			typ, msg, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(typ, msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// rawConn is a client connection that sends frames as given.
+type rawConn struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// handshakeHeaders returns the headers of a valid opening handshake.
+func handshakeHeaders() http.Header {
+	return http.Header{
+		"Upgrade":               {"websocket"},
+		"Connection":            {"keep-alive, Upgrade"},
+		"Sec-Websocket-Key":     {"dGhlIHNhbXBsZSBub25jZQ=="},
+		"Sec-Websocket-Version": {"13"},
+	}
+}
+
+// rawHandshake sends an opening handshake with header and returns the
+// response and, after a 101, the connection.
+func rawHandshake(t *testing.T, srv *httptest.Server, header http.Header) (*http.Response, *rawConn) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header = header
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, &rawConn{t: t, conn: conn, br: br}
+}
+
+func dialRaw(t *testing.T, srv *httptest.Server) *rawConn {
+	t.Helper()
+	resp, c := rawHandshake(t, srv, handshakeHeaders())
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake: got status %v", resp.StatusCode)
+	}
+	return c
+}
+
+// writeFrame writes a masked frame with the first byte b0, which holds FIN,
+// RSV and the opcode.
+func (c *rawConn) writeFrame(b0 byte, payload []byte) {
+	c.writeFrameMasked(b0, payload, true)
+}
+
+func (c *rawConn) writeFrameMasked(b0 byte, payload []byte, masked bool) {
+	c.t.Helper()
+
+	buf := []byte{b0}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch l := len(payload); {
+	case l < 126:
+		buf = append(buf, maskBit|byte(l))
+	case l <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(l))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(l))
+	}
+	p := bytes.Clone(payload)
+	if masked {
+		key := [4]byte{0x12, 0x34, 0x56, 0x78}
+		buf = append(buf, key[:]...)
+		mask(key, p)
+	}
+	if _, err := c.conn.Write(append(buf, p...)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// readFrame reads a frame from the server, which must be unmasked.
+func (c *rawConn) readFrame() (b0 byte, payload []byte) {
+	c.t.Helper()
+
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		c.t.Fatalf("reading frame: %v", err)
+	}
+	if h[1]&0x80 != 0 {
+		c.t.Fatal("server frame is masked")
+	}
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		io.ReadFull(c.br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatalf("reading payload: %v", err)
+	}
+	return h[0], payload
+}
+
+// expectFrame reads a frame and checks its first byte and payload.
+func (c *rawConn) expectFrame(b0 byte, payload []byte) {
+	c.t.Helper()
+	gotB0, gotPayload := c.readFrame()
+	if gotB0 != b0 || !bytes.Equal(gotPayload, payload) {
+		c.t.Fatalf("got frame %#x %.40q want %#x %.40q", gotB0, gotPayload, b0, payload)
+	}
+}
+
+// expectClose reads a close frame with code and checks the server then
+// closes the TCP connection.
+func (c *rawConn) expectClose(code int) {
+	c.t.Helper()
+	b0, payload := c.readFrame()
+	if b0 != 0x80|opClose {
+		c.t.Fatalf("got frame %#x %q want a close frame", b0, payload)
+	}
+	got := CloseNoStatusReceived
+	if len(payload) >= 2 {
+		got = int(binary.BigEndian.Uint16(payload))
+	}
+	if got != code {
+		c.t.Fatalf("got close code %d (%q) want %d", got, payload, code)
+	}
+	// A reset instead of EOF is fine: the server may close with unread
+	// data, such as the rest of a message over the limit.
+	if b, err := c.br.ReadByte(); err == nil {
+		c.t.Fatalf("got byte %#x after close frame want the connection closed", b)
+	}
+}
+
+func closePayload(code int, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+// fin is the FIN bit of the first byte of a frame.
+const fin = 0x80
+
+func TestHandshake(t *testing.T) {
+	srv := newEchoServer(t)
+
+	t.Run("accept", func(t *testing.T) {
+		header := handshakeHeaders()
+		header.Set("Sec-WebSocket-Protocol", "chat.v1, chat.v2")
+		resp, _ := rawHandshake(t, srv, header)
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("got status %v", resp.StatusCode)
+		}
+		// The example from RFC 6455 section 1.3.
+		if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf("got Sec-WebSocket-Accept %q", got)
+		}
+		if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "chat.v2" {
+			t.Errorf("got subprotocol %q want the server's preference chat.v2", got)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		modify func(h http.Header)
+		status int
+		header string
+	}{
+		{"not_an_upgrade", func(h http.Header) { h.Del("Upgrade") }, http.StatusUpgradeRequired, "Upgrade"},
+		{"connection_without_upgrade", func(h http.Header) { h.Set("Connection", "keep-alive") }, http.StatusUpgradeRequired, "Upgrade"},
+		{"unsupported_version", func(h http.Header) { h.Set("Sec-WebSocket-Version", "8") }, http.StatusUpgradeRequired, "Sec-WebSocket-Version"},
+		{"missing_key", func(h http.Header) { h.Del("Sec-WebSocket-Key") }, http.StatusBadRequest, ""},
+		{"short_key", func(h http.Header) { h.Set("Sec-WebSocket-Key", "c2hvcnQ=") }, http.StatusBadRequest, ""},
+		{"cross_origin", func(h http.Header) { h.Set("Origin", "https://evil.example") }, http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := handshakeHeaders()
+			tt.modify(header)
+			resp, _ := rawHandshake(t, srv, header)
+			if resp.StatusCode != tt.status {
+				t.Errorf("got status %v want %v", resp.StatusCode, tt.status)
+			}
+			if tt.header != "" && resp.Header.Get(tt.header) == "" {
+				t.Errorf("missing %s header", tt.header)
+			}
+		})
+	}
+
+	t.Run("same_origin", func(t *testing.T) {
+		header := handshakeHeaders()
+		header.Set("Origin", srv.URL)
+		if resp, _ := rawHandshake(t, srv, header); resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Errorf("got status %v", resp.StatusCode)
+		}
+	})
+}
+
+func TestFraming(t *testing.T) {
+	srv := newEchoServer(t)
+
+	// Lengths around the 7, 16 and 64 bit length encodings.
+	for _, n := range []int{0, 125, 126, 127, 0xffff, 0x10000} {
+		c := dialRaw(t, srv)
+		payload := bytes.Repeat([]byte("*"), n)
+		c.writeFrame(fin|opText, payload)
+		c.expectFrame(fin|opText, payload)
+
+		c.writeFrame(fin|opBinary, payload)
+		c.expectFrame(fin|opBinary, payload)
+	}
+
+	t.Run("binary_is_not_validated", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(fin|opBinary, []byte{0xff, 0xfe, 0x00})
+		c.expectFrame(fin|opBinary, []byte{0xff, 0xfe, 0x00})
+	})
+
+	t.Run("unmasked_client_frame", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrameMasked(fin|opText, []byte("hello"), false)
+		c.expectClose(CloseProtocolError)
+	})
+
+	for _, rsv := range []byte{0x40, 0x20, 0x10} {
+		c := dialRaw(t, srv)
+		c.writeFrame(fin|rsv|opText, []byte("hello"))
+		c.expectClose(CloseProtocolError)
+	}
+
+	for _, op := range []byte{0x3, 0x7, 0xb, 0xf} {
+		c := dialRaw(t, srv)
+		c.writeFrame(fin|op, nil)
+		c.expectClose(CloseProtocolError)
+	}
+
+	t.Run("message_too_big", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(fin|opBinary, make([]byte, testReadLimit+1))
+		c.expectClose(CloseMessageTooBig)
+	})
+
+	t.Run("fragments_too_big", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(opBinary, make([]byte, testReadLimit))
+		c.writeFrame(fin|opContinuation, []byte{1})
+		c.expectClose(CloseMessageTooBig)
+	})
+}
+
+func TestFragmentation(t *testing.T) {
+	srv := newEchoServer(t)
+
+	t.Run("reassembled", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(opText, []byte("frag"))
+		c.writeFrame(opContinuation, []byte("men"))
+		c.writeFrame(fin|opContinuation, []byte("ted"))
+		c.expectFrame(fin|opText, []byte("fragmented"))
+	})
+
+	t.Run("empty_fragments", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(opBinary, nil)
+		c.writeFrame(opContinuation, nil)
+		c.writeFrame(fin|opContinuation, nil)
+		c.expectFrame(fin|opBinary, []byte{})
+	})
+
+	t.Run("control_frames_interleaved", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(opText, []byte("hel"))
+		c.writeFrame(fin|opPing, []byte("p"))
+		c.writeFrame(fin|opPong, []byte("unsolicited"))
+		c.writeFrame(fin|opContinuation, []byte("lo"))
+		c.expectFrame(fin|opPong, []byte("p"))
+		c.expectFrame(fin|opText, []byte("hello"))
+	})
+
+	t.Run("utf8_split_across_fragments", func(t *testing.T) {
+		euro := []byte("€")
+		c := dialRaw(t, srv)
+		c.writeFrame(opText, euro[:1])
+		c.writeFrame(fin|opContinuation, euro[1:])
+		c.expectFrame(fin|opText, euro)
+	})
+
+	t.Run("continuation_without_start", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(fin|opContinuation, []byte("orphan"))
+		c.expectClose(CloseProtocolError)
+	})
+
+	t.Run("new_message_during_fragments", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(opText, []byte("first"))
+		c.writeFrame(fin|opText, []byte("second"))
+		c.expectClose(CloseProtocolError)
+	})
+}
+
+func TestPingPong(t *testing.T) {
+	srv := newEchoServer(t)
+
+	t.Run("pong_echoes_payload", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(fin|opPing, nil)
+		c.expectFrame(fin|opPong, nil)
+		c.writeFrame(fin|opPing, bytes.Repeat([]byte{0xfe}, 125))
+		c.expectFrame(fin|opPong, bytes.Repeat([]byte{0xfe}, 125))
+	})
+
+	t.Run("ping_too_long", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(fin|opPing, make([]byte, 126))
+		c.expectClose(CloseProtocolError)
+	})
+
+	t.Run("fragmented_ping", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(opPing, []byte("a"))
+		c.expectClose(CloseProtocolError)
+	})
+
+	t.Run("pong_handler", func(t *testing.T) {
+		pongs := make(chan string, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			c.SetPongHandler(func(data []byte) { pongs <- string(data) })
+			c.Ping([]byte("are you there"))
+			c.ReadMessage()
+		}))
+		defer srv.Close()
+
+		c := dialRaw(t, srv)
+		c.expectFrame(fin|opPing, []byte("are you there"))
+		c.writeFrame(fin|opPong, []byte("are you there"))
+		select {
+		case got := <-pongs:
+			if got != "are you there" {
+				t.Errorf("got pong %q", got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("pong handler not called")
+		}
+	})
+}
+
+func TestUTF8(t *testing.T) {
+	srv := newEchoServer(t)
+
+	for _, payload := range [][]byte{
+		{0xff},
+		{0xc0, 0xaf},             // overlong encoding
+		{0xed, 0xa0, 0x80},       // surrogate half
+		{0xf4, 0x90, 0x80, 0x80}, // beyond U+10FFFF
+	} {
+		c := dialRaw(t, srv)
+		c.writeFrame(fin|opText, payload)
+		c.expectClose(CloseInvalidPayload)
+	}
+}
+
+func TestClose(t *testing.T) {
+	srv := newEchoServer(t)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    int
+	}{
+		{"normal", closePayload(CloseNormalClosure, "bye"), CloseNormalClosure},
+		{"no_status", nil, CloseNoStatusReceived},
+		{"registered_code", closePayload(3000, ""), 3000},
+		{"private_code", closePayload(4999, ""), 4999},
+		{"one_byte_payload", []byte{0x03}, CloseProtocolError},
+		{"code_below_range", closePayload(999, ""), CloseProtocolError},
+		{"reserved_1004", closePayload(1004, ""), CloseProtocolError},
+		{"no_status_sent", closePayload(CloseNoStatusReceived, ""), CloseProtocolError},
+		{"abnormal_sent", closePayload(CloseAbnormalClosure, ""), CloseProtocolError},
+		{"code_above_range", closePayload(5000, ""), CloseProtocolError},
+		{"invalid_utf8_reason", closePayload(CloseNormalClosure, "\xff"), CloseInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialRaw(t, srv)
+			c.writeFrame(fin|opClose, tt.payload)
+			c.expectClose(tt.want)
+		})
+	}
+
+	t.Run("no_frames_after_close", func(t *testing.T) {
+		c := dialRaw(t, srv)
+		c.writeFrame(fin|opClose, closePayload(CloseNormalClosure, ""))
+		c.writeFrame(fin|opText, []byte("too late"))
+		c.expectClose(CloseNormalClosure)
+	})
+
+	t.Run("server_initiated", func(t *testing.T) {
+		result := make(chan error, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			result <- c.Close(CloseGoingAway, "restarting")
+		}))
+		defer srv.Close()
+
+		c := dialRaw(t, srv)
+		c.expectFrame(fin|opClose, closePayload(CloseGoingAway, "restarting"))
+		c.writeFrame(fin|opClose, closePayload(CloseGoingAway, ""))
+		if err := <-result; err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.br.ReadByte(); err != io.EOF {
+			t.Errorf("connection not closed: %v", err)
+		}
+	})
+}
+
+func TestDial(t *testing.T) {
+	srv := newEchoServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, resp, err := Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), http.Header{"Sec-WebSocket-Protocol": {"chat.v1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || c.Subprotocol() != "chat.v1" {
+		t.Fatalf("got status %v and subprotocol %q", resp.StatusCode, c.Subprotocol())
+	}
+
+	// The server rejects unmasked frames, so an echo proves the client
+	// masks.
+	for _, msg := range []string{"hello", strings.Repeat("x", 70000)} {
+		if err := c.WriteMessage(TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		typ, got, err := c.ReadMessage()
+		if err != nil || typ != TextMessage || string(got) != msg {
+			t.Fatalf("got %v %.20q %v", typ, got, err)
+		}
+	}
+
+	if err := c.Close(CloseNormalClosure, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.ReadMessage(); !IsCloseError(err, CloseNormalClosure) {
+		t.Errorf("got %v after close want close 1000", err)
+	}
+	if err := c.WriteMessage(TextMessage, nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v writing after close want ErrClosed", err)
+	}
+
+	plain := httptest.NewServer(http.NotFoundHandler())
+	defer plain.Close()
+	if _, resp, err := Dial(ctx, plain.URL, nil); !errors.Is(err, ErrBadHandshake) || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got %v want ErrBadHandshake with the response", err)
+	}
+}